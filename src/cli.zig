@@ -7,12 +7,23 @@ const colors = @import("colors.zig");
 pub const Command = enum {
     main, // Default: generate commit message
     config,
+    undo, // Uncommit HEAD, keeping changes staged
+    serve, // Answer JSON-lines requests on stdin (editor integrations)
+    completion, // Print a shell completion script
 };
 
 pub const ConfigSubcommand = enum {
     edit, // Default when no subcommand given
     show,
     path,
+    profile_list, // `config profile`
+    profile_use, // `config profile use <name>`; the name is in Args.profile
+    import, // `config import [--url] <url>`
+    get, // `config get <key>`; the key is in Args.config_key
+    unset, // `config unset <key>`
+    set_key, // `config set-key <provider>`; the provider is in Args.provider
+    delete_key, // `config delete-key <provider>`
+    reset_prompt, // `config reset-prompt`
     unknown,
 };
 
@@ -22,7 +33,50 @@ pub const Args = struct {
     auto_add: bool = false,
     auto_push: bool = false,
     auto_accept: bool = false,
+    preview: bool = false,
+    dry_run: bool = false,
+    explain_config: bool = false,
+    batch: bool = false,
+    skip_checks: bool = false,
+    /// Rewrite the last commit (message and staged changes) instead of adding one
+    amend: bool = false,
+    /// Sign the commit (-S), like the sign_commits config option
+    sign: bool = false,
+    /// Commit even when the message looks like it contains a secret
+    allow_secrets: bool = false,
+    /// Pass --no-verify to git commit, skipping its pre-commit and commit-msg hooks
+    no_verify: bool = false,
+    /// Print only the subject line of the message (--dry-run, --preview, --diff-file, --range)
+    first_line_only: bool = false,
+    /// Use the model's output verbatim: no reasoning, fence, or quote cleanup
+    raw: bool = false,
+    /// Also emit the printed message as a GitHub Actions ::notice:: (automatic when GITHUB_ACTIONS=true)
+    github_annotation: bool = false,
     provider: ?[]const u8 = null,
+    model: ?[]const u8 = null,
+    /// Config profile to apply (--profile), or the one to activate with `config profile use`
+    profile: ?[]const u8 = null,
+    /// Team config fragment to merge with `config import`
+    import_url: ?[]const u8 = null,
+    /// Setting named by `config get`/`config unset`, e.g. default_provider or providers.groq.model
+    config_key: ?[]const u8 = null,
+    /// Shell named by `completion <shell>`, or "providers" for the provider list scripts use
+    completion_target: ?[]const u8 = null,
+    context_file: ?[]const u8 = null,
+    /// Read the diff from this file instead of the index; "-" means stdin
+    diff_file: ?[]const u8 = null,
+    /// Summarize this revision range (e.g. main..HEAD) instead of the staged changes
+    range: ?[]const u8 = null,
+    /// Issue reference appended as a Refs: trailer (e.g. 123 or PROJ-42)
+    issue: ?[]const u8 = null,
+    /// Author date for the commit, e.g. "2 hours ago" or "2024-03-01 09:30"
+    date: ?[]const u8 = null,
+    /// Number of messages to generate and pick from; overrides the candidates option
+    candidates: ?u32 = null,
+    /// Recent commits sent as style context; overrides the recent_commits option
+    context_commits: ?u32 = null,
+    /// Proxy URL for this run; overrides the proxy options and the proxy env vars
+    proxy: ?[]const u8 = null,
     debug: bool = false,
 };
 
@@ -30,6 +84,21 @@ pub const ParseError = error{
     HelpRequested,
     VersionRequested,
     MissingProviderValue,
+    MissingModelValue,
+    MissingProfileValue,
+    MissingImportUrl,
+    MissingConfigKey,
+    MissingCompletionShell,
+    MissingContextFileValue,
+    MissingDiffFileValue,
+    MissingRangeValue,
+    MissingIssueValue,
+    MissingDateValue,
+    MissingCandidatesValue,
+    InvalidCandidatesValue,
+    MissingProxyValue,
+    MissingContextValue,
+    InvalidContextValue,
 };
 
 pub const API_KEY_PLACEHOLDER = "paste-key-here";
@@ -67,26 +136,171 @@ pub fn parseFromSlice(allocator: std.mem.Allocator, args: []const []const u8) !A
                 } else if (std.mem.eql(u8, sub, "path")) {
                     result.config_sub = .path;
                     i += 1;
+                } else if (std.mem.eql(u8, sub, "profile")) {
+                    result.config_sub = .profile_list;
+                    i += 1;
+                    if (i + 1 < args.len and std.mem.eql(u8, args[i + 1], "use")) {
+                        i += 2;
+                        if (i >= args.len) {
+                            return error.MissingProfileValue;
+                        }
+                        result.config_sub = .profile_use;
+                        if (result.profile) |previous| allocator.free(previous);
+                        result.profile = try allocator.dupe(u8, args[i]);
+                    }
+                } else if (std.mem.eql(u8, sub, "import")) {
+                    result.config_sub = .import;
+                    i += 1;
+                    if (i + 1 < args.len and std.mem.eql(u8, args[i + 1], "--url")) i += 1;
+                    i += 1;
+                    if (i >= args.len) {
+                        return error.MissingImportUrl;
+                    }
+                    result.import_url = try allocator.dupe(u8, args[i]);
+                } else if (std.mem.eql(u8, sub, "get") or std.mem.eql(u8, sub, "unset")) {
+                    result.config_sub = if (std.mem.eql(u8, sub, "get")) .get else .unset;
+                    i += 2;
+                    if (i >= args.len) {
+                        return error.MissingConfigKey;
+                    }
+                    result.config_key = try allocator.dupe(u8, args[i]);
+                } else if (std.mem.eql(u8, sub, "set-key") or std.mem.eql(u8, sub, "delete-key")) {
+                    result.config_sub = if (std.mem.eql(u8, sub, "set-key")) .set_key else .delete_key;
+                    i += 2;
+                    if (i >= args.len) {
+                        return error.MissingProviderValue;
+                    }
+                    if (result.provider) |previous| allocator.free(previous);
+                    result.provider = try allocator.dupe(u8, args[i]);
                 } else if (std.mem.eql(u8, sub, "edit")) {
                     result.config_sub = .edit;
                     i += 1;
+                } else if (std.mem.eql(u8, sub, "reset-prompt")) {
+                    result.config_sub = .reset_prompt;
+                    i += 1;
                 } else if (!std.mem.startsWith(u8, sub, "-")) {
                     result.config_sub = .unknown;
                     i += 1;
                 }
             }
+        } else if (std.mem.eql(u8, arg, "undo")) {
+            result.command = .undo;
+        } else if (std.mem.eql(u8, arg, "serve")) {
+            result.command = .serve;
+        } else if (std.mem.eql(u8, arg, "completion")) {
+            result.command = .completion;
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingCompletionShell;
+            }
+            result.completion_target = try allocator.dupe(u8, args[i]);
         } else if (std.mem.eql(u8, arg, "--add")) {
             result.auto_add = true;
         } else if (std.mem.eql(u8, arg, "--push")) {
             result.auto_push = true;
-        } else if (std.mem.eql(u8, arg, "--accept")) {
+        } else if (std.mem.eql(u8, arg, "--accept") or std.mem.eql(u8, arg, "--yes") or std.mem.eql(u8, arg, "-y")) {
             result.auto_accept = true;
+        } else if (std.mem.eql(u8, arg, "--preview")) {
+            result.preview = true;
+        } else if (std.mem.eql(u8, arg, "--dry-run") or std.mem.eql(u8, arg, "-n")) {
+            result.dry_run = true;
+        } else if (std.mem.eql(u8, arg, "--explain-config")) {
+            result.explain_config = true;
+        } else if (std.mem.eql(u8, arg, "--batch")) {
+            result.batch = true;
+        } else if (std.mem.eql(u8, arg, "--skip-checks")) {
+            result.skip_checks = true;
+        } else if (std.mem.eql(u8, arg, "--amend")) {
+            result.amend = true;
+        } else if (std.mem.eql(u8, arg, "--no-verify")) {
+            result.no_verify = true;
+        } else if (std.mem.eql(u8, arg, "--sign")) {
+            result.sign = true;
+        } else if (std.mem.eql(u8, arg, "--allow-secrets")) {
+            result.allow_secrets = true;
+        } else if (std.mem.eql(u8, arg, "--first-line-only")) {
+            result.first_line_only = true;
+        } else if (std.mem.eql(u8, arg, "--raw")) {
+            result.raw = true;
+        } else if (std.mem.eql(u8, arg, "--github-annotation")) {
+            result.github_annotation = true;
         } else if (std.mem.eql(u8, arg, "--provider")) {
             i += 1;
             if (i >= args.len) {
                 return error.MissingProviderValue;
             }
             result.provider = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--model")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingModelValue;
+            }
+            result.model = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--profile")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingProfileValue;
+            }
+            if (result.profile) |previous| allocator.free(previous);
+            result.profile = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--context-file")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingContextFileValue;
+            }
+            result.context_file = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--diff-file")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingDiffFileValue;
+            }
+            if (result.diff_file) |previous| allocator.free(previous);
+            result.diff_file = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--range")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingRangeValue;
+            }
+            if (result.range) |previous| allocator.free(previous);
+            result.range = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--issue")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingIssueValue;
+            }
+            if (result.issue) |previous| allocator.free(previous);
+            result.issue = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--date")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingDateValue;
+            }
+            if (result.date) |previous| allocator.free(previous);
+            result.date = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--candidates")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingCandidatesValue;
+            }
+            const count = std.fmt.parseInt(u32, args[i], 10) catch return error.InvalidCandidatesValue;
+            if (count == 0) return error.InvalidCandidatesValue;
+            result.candidates = count;
+        } else if (std.mem.eql(u8, arg, "--context")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingContextValue;
+            }
+            result.context_commits = std.fmt.parseInt(u32, args[i], 10) catch return error.InvalidContextValue;
+        } else if (std.mem.eql(u8, arg, "--proxy")) {
+            i += 1;
+            if (i >= args.len) {
+                return error.MissingProxyValue;
+            }
+            if (result.proxy) |previous| allocator.free(previous);
+            result.proxy = try allocator.dupe(u8, args[i]);
+        } else if (std.mem.eql(u8, arg, "--stdin")) {
+            if (result.diff_file) |previous| allocator.free(previous);
+            result.diff_file = try allocator.dupe(u8, "-");
         } else if (std.mem.eql(u8, arg, "--debug")) {
             result.debug = true;
         }
@@ -106,6 +320,12 @@ pub fn isApiKeyPlaceholder(api_key: []const u8) bool {
     return std.mem.eql(u8, api_key, API_KEY_PLACEHOLDER);
 }
 
+/// Inline API key masked to its last 4 characters (e.g. "****abcd"); shorter keys are fully masked
+fn maskApiKey(buf: *[8]u8, api_key: []const u8) []const u8 {
+    const visible = if (api_key.len > 8) api_key[api_key.len - 4 ..] else "";
+    return std.fmt.bufPrint(buf, "****{s}", .{visible}) catch "****";
+}
+
 pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
     // Get config path
     const config_path = config.getConfigPath(allocator) catch |err| {
@@ -140,6 +360,9 @@ pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
     defer cfg.deinit(allocator);
 
     try writer.print("\n{s}Current Settings:{s}\n", .{ Color.bold, Color.reset });
+    if (cfg.active_profile.len > 0) {
+        try writer.print("  Profile: {s}{s}{s}\n", .{ Color.cyan, cfg.active_profile, Color.reset });
+    }
     try writer.print("  Default Provider: {s}{s}{s}\n", .{ Color.cyan, cfg.default_provider, Color.reset });
 
     // Get the model from the default provider using registry lookup
@@ -150,6 +373,10 @@ pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
     };
 
     try writer.print("  Active Model: {s}{s}{s}\n", .{ Color.cyan, active_model, Color.reset });
+    try writer.print("  Auto Push: {s}\n", .{if (cfg.auto_push) "on" else "off"});
+
+    const custom_prompt = !std.mem.eql(u8, std.mem.trim(u8, cfg.system_prompt, " \n\r\t"), std.mem.trim(u8, config.SYSTEM_PROMPT_TEMPLATE, " \n\r\t"));
+    try writer.print("  Custom Prompt: {s}\n", .{if (custom_prompt) "yes" else "no (default)"});
 
     try writer.print("  System Prompt:\n{s}{s}{s}", .{ Color.yellow, cfg.system_prompt, Color.reset });
 
@@ -160,8 +387,8 @@ pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
         const provider_config = cfg.getProvider(metadata.name) catch continue;
         const is_default = std.mem.eql(u8, cfg.default_provider, metadata.id.name());
 
-        // Check if API key is set (not a placeholder and not empty)
-        const api_set = checkApiKeySet(provider_config.api_key);
+        // Check if API key is set (not a placeholder and not empty) or sourced externally
+        const api_set = checkApiKeySet(provider_config.api_key) or provider_config.hasExternalKeySource() or !metadata.requires_api_key;
 
         // Provider name - default in cyan, others in gray
         if (is_default) {
@@ -173,8 +400,11 @@ pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
         // Model (always shown in normal color)
         try writer.print("    Model: {s}\n", .{provider_config.model});
 
-        // API Key with color coding
-        if (api_set) {
+        // API Key with color coding; inline keys show only their last 4 characters
+        if (checkApiKeySet(provider_config.api_key)) {
+            var mask_buf: [8]u8 = undefined;
+            try writer.print("    API Key: {s}✓ set{s} ({s})\n\n", .{ Color.green, Color.reset, maskApiKey(&mask_buf, provider_config.api_key) });
+        } else if (api_set) {
             try writer.print("    API Key: {s}✓ set{s}\n\n", .{ Color.green, Color.reset });
         } else {
             try writer.print("    API Key: {s}✗ not set{s}\n\n", .{ Color.red, Color.reset });
@@ -182,6 +412,31 @@ pub fn printConfigInfo(allocator: std.mem.Allocator, writer: anytype) !void {
     }
 }
 
+/// List the default profile and each configured one, marking the active profile
+pub fn printProfiles(allocator: std.mem.Allocator, writer: anytype) !void {
+    const cfg = config.load(allocator) catch |err| {
+        try writer.print("Error loading config: {s}\n", .{@errorName(err)});
+        return;
+    };
+    defer cfg.deinit(allocator);
+
+    try writer.print("{s}Profiles:{s}\n", .{ Color.bold, Color.reset });
+    try printProfileLine(writer, config.DEFAULT_PROFILE, cfg.default_provider, cfg.active_profile.len == 0);
+    for (cfg.profiles) |profile| {
+        // Profiles without their own default provider inherit the top-level one
+        const provider = if (profile.default_provider.len > 0) profile.default_provider else "(inherited)";
+        try printProfileLine(writer, profile.name, provider, std.mem.eql(u8, profile.name, cfg.active_profile));
+    }
+}
+
+fn printProfileLine(writer: anytype, name: []const u8, provider: []const u8, active: bool) !void {
+    if (active) {
+        try writer.print("  {s}{s}{s} {s}(active){s}: {s}\n", .{ Color.cyan, name, Color.reset, Color.yellow, Color.reset, provider });
+    } else {
+        try writer.print("  {s}{s}{s}: {s}\n", .{ Color.cyan, name, Color.reset, provider });
+    }
+}
+
 pub fn printConfigPath(allocator: std.mem.Allocator, writer: anytype) !void {
     const config_path = config.getConfigPath(allocator) catch |err| {
         try writer.print("Error getting config path: {s}\n", .{@errorName(err)});
@@ -212,6 +467,39 @@ pub fn free(args: *const Args, allocator: std.mem.Allocator) void {
     if (args.provider) |provider| {
         allocator.free(provider);
     }
+    if (args.model) |model| {
+        allocator.free(model);
+    }
+    if (args.profile) |profile| {
+        allocator.free(profile);
+    }
+    if (args.import_url) |url| {
+        allocator.free(url);
+    }
+    if (args.config_key) |key| {
+        allocator.free(key);
+    }
+    if (args.completion_target) |target| {
+        allocator.free(target);
+    }
+    if (args.context_file) |path| {
+        allocator.free(path);
+    }
+    if (args.diff_file) |path| {
+        allocator.free(path);
+    }
+    if (args.range) |range| {
+        allocator.free(range);
+    }
+    if (args.issue) |issue| {
+        allocator.free(issue);
+    }
+    if (args.date) |date| {
+        allocator.free(date);
+    }
+    if (args.proxy) |proxy| {
+        allocator.free(proxy);
+    }
 }
 
 pub fn printHelp(writer: anytype) !void {
@@ -221,17 +509,54 @@ pub fn printHelp(writer: anytype) !void {
         \\Usage:
         \\  autocommit [options]              # Generate commit message for staged changes
         \\  autocommit config [subcommand]    # Manage configuration
+        \\  autocommit undo                   # Undo the last commit, keeping changes staged
+        \\  autocommit serve                  # Answer JSON-lines requests on stdin
+        \\  autocommit completion <shell>     # Print a bash, zsh, or fish completion script
         \\
         \\Commands:
         \\  config              Open configuration file in $EDITOR
         \\  config show         Display current configuration
         \\  config path         Show configuration file path
+        \\  config get <key>    Print one resolved setting, e.g. providers.groq.model
+        \\  config unset <key>  Remove a setting or a whole provider (providers.<name>)
+        \\  config profile      List config profiles
+        \\  config profile use <name>  Make <name> the active profile ("default" = top level)
+        \\  config import --url <https-url>  Merge a shared team config fragment (no API keys)
+        \\  config set-key <provider>     Store the provider's API key in the system keyring
+        \\  config delete-key <provider>  Remove the provider's API key from the keyring
+        \\  config reset-prompt  Restore the default system_prompt (backs up the config first)
+        \\  undo                Undo the last (unpushed) commit; --accept skips confirmation
+        \\  serve               Read {"diff", "recent_commits"} lines on stdin, write {"message"} lines
+        \\  completion <shell>  Print a completion script (bash, zsh, fish)
         \\
         \\Options:
         \\  --add               Auto-add all unstaged files before committing
         \\  --push              Auto-push after committing
-        \\  --accept            Auto-accept generated commit message without prompting
-        \\  --provider <name>   Override provider (zai, groq)
+        \\  --accept, --yes, -y  Auto-accept generated commit message without prompting
+        \\  --preview           Preview a message for unstaged changes (no staging or committing)
+        \\  -n, --dry-run       Print only the message for staged changes and exit (no commit)
+        \\  --batch             Experimental: one commit per top-level directory of staged files
+        \\  --skip-checks       Skip the configured pre_commit_command
+        \\  --amend             Regenerate the last commit's message and amend it (with any staged changes)
+        \\  --no-verify         Skip git's pre-commit and commit-msg hooks when committing
+        \\  --sign              Sign the commit with GPG or SSH (git commit -S)
+        \\  --allow-secrets     Commit even if the message looks like it contains a secret
+        \\  --provider <name>   Override provider (zai, groq, ollama, openrouter, gemini, azure)
+        \\  --model <name>      Override the provider's model for this run
+        \\  --profile <name>    Use a config profile for this run
+        \\  --context <n>       Send the n most recent commits as style context (0 = none)
+        \\  --context-file <path>  Add the file's contents as extra context for the message
+        \\  --diff-file <path>  Generate from a diff file instead of staged changes ("-" = stdin)
+        \\  --stdin             Same as --diff-file -
+        \\  --range <A..B>      Generate one message summarizing a commit range
+        \\  --issue <ref>       Append a "Refs: #<ref>" trailer to the commit message
+        \\  --date <when>       Set the author date ("now", "2 hours ago", or any date git accepts)
+        \\  --candidates <n>    Generate n messages and pick one at the commit prompt
+        \\  --proxy <url>       Send provider requests through this HTTP(S) proxy for this run
+        \\  --first-line-only   Print only the subject line of the message (no body or trailers)
+        \\  --raw               Use the model's output verbatim, without any cleanup
+        \\  --github-annotation  Also emit printed messages as a GitHub Actions notice (on stderr)
+        \\  --explain-config    Show each resolved setting and where it came from, then exit
         \\  --debug             Enable debug output
         \\  --version           Show version information
         \\  --help              Show this help message
@@ -240,6 +565,11 @@ pub fn printHelp(writer: anytype) !void {
         \\  autocommit                          # Generate commit message interactively
         \\  autocommit --add --accept --push    # Full automation (add, accept, push)
         \\  autocommit --provider groq          # Use specific provider
+        \\  autocommit --provider groq --model llama-3.3-70b-versatile
+        \\  autocommit --preview                # Preview message for working changes
+        \\  msg=$(autocommit --dry-run)          # Capture a message in a script
+        \\  git diff --cached | autocommit --stdin  # Message for a precomputed diff
+        \\  autocommit --batch                  # Split staged changes into grouped commits
         \\  autocommit config                   # Edit configuration
         \\  autocommit config show              # Display current config
         \\
@@ -292,6 +622,106 @@ test "parse config path subcommand" {
     try std.testing.expectEqual(ConfigSubcommand.path, result.config_sub);
 }
 
+test "parse config profile subcommands" {
+    const list_args = &[_][]const u8{ "autocommit", "config", "profile" };
+    var list = try parseFromSlice(std.testing.allocator, list_args);
+    defer free(&list, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.profile_list, list.config_sub);
+
+    const use_args = &[_][]const u8{ "autocommit", "config", "profile", "use", "work" };
+    var use = try parseFromSlice(std.testing.allocator, use_args);
+    defer free(&use, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.profile_use, use.config_sub);
+    try std.testing.expectEqualStrings("work", use.profile.?);
+
+    const missing_args = &[_][]const u8{ "autocommit", "config", "profile", "use" };
+    try std.testing.expectError(error.MissingProfileValue, parseFromSlice(std.testing.allocator, missing_args));
+}
+
+test "parse config import subcommand" {
+    const test_args = &[_][]const u8{ "autocommit", "config", "import", "--url", "https://example.com/team.toml" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqual(ConfigSubcommand.import, result.config_sub);
+    try std.testing.expectEqualStrings("https://example.com/team.toml", result.import_url.?);
+
+    const bare_args = &[_][]const u8{ "autocommit", "config", "import", "https://example.com/team.toml" };
+    var bare = try parseFromSlice(std.testing.allocator, bare_args);
+    defer free(&bare, std.testing.allocator);
+    try std.testing.expectEqualStrings("https://example.com/team.toml", bare.import_url.?);
+
+    try std.testing.expectError(error.MissingImportUrl, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "config", "import", "--url" }));
+}
+
+test "parse config get subcommand" {
+    const test_args = &[_][]const u8{ "autocommit", "config", "get", "providers.groq.model" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqual(ConfigSubcommand.get, result.config_sub);
+    try std.testing.expectEqualStrings("providers.groq.model", result.config_key.?);
+    try std.testing.expectError(error.MissingConfigKey, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "config", "get" }));
+
+    const unset_args = &[_][]const u8{ "autocommit", "config", "unset", "providers.groq" };
+    var unset = try parseFromSlice(std.testing.allocator, unset_args);
+    defer free(&unset, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.unset, unset.config_sub);
+    try std.testing.expectEqualStrings("providers.groq", unset.config_key.?);
+}
+
+test "parse config set-key and delete-key subcommands" {
+    const set_args = &[_][]const u8{ "autocommit", "config", "set-key", "groq" };
+    var set = try parseFromSlice(std.testing.allocator, set_args);
+    defer free(&set, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.set_key, set.config_sub);
+    try std.testing.expectEqualStrings("groq", set.provider.?);
+
+    const delete_args = &[_][]const u8{ "autocommit", "config", "delete-key", "zai" };
+    var delete = try parseFromSlice(std.testing.allocator, delete_args);
+    defer free(&delete, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.delete_key, delete.config_sub);
+    try std.testing.expectEqualStrings("zai", delete.provider.?);
+
+    try std.testing.expectError(error.MissingProviderValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "config", "set-key" }));
+}
+
+test "parse config reset-prompt subcommand" {
+    const test_args = &[_][]const u8{ "autocommit", "config", "reset-prompt", "--accept" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqual(ConfigSubcommand.reset_prompt, result.config_sub);
+    try std.testing.expect(result.auto_accept);
+}
+
+test "parse --profile flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--profile", "work", "--accept" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqualStrings("work", result.profile.?);
+    try std.testing.expect(result.auto_accept);
+    try std.testing.expectError(error.MissingProfileValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--profile" }));
+}
+
+test "parse serve command" {
+    const test_args = &[_][]const u8{ "autocommit", "serve", "--provider", "ollama" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqual(Command.serve, result.command);
+    try std.testing.expectEqualStrings("ollama", result.provider.?);
+}
+
+test "parse undo command" {
+    const test_args = &[_][]const u8{ "autocommit", "undo", "--accept" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqual(Command.undo, result.command);
+    try std.testing.expect(result.auto_accept);
+}
+
 test "parse with auto_add flag" {
     const test_args = &[_][]const u8{ "autocommit", "--add" };
     var result = try parseFromSlice(std.testing.allocator, test_args);
@@ -322,6 +752,89 @@ test "parse with auto_accept flag" {
     try std.testing.expect(result.auto_accept);
 }
 
+test "parse --yes and -y as --accept" {
+    for ([_][]const u8{ "--yes", "-y" }) |flag| {
+        const test_args = &[_][]const u8{ "autocommit", "--add", flag };
+        var result = try parseFromSlice(std.testing.allocator, test_args);
+        defer free(&result, std.testing.allocator);
+
+        try std.testing.expect(result.auto_accept);
+        try std.testing.expect(result.auto_add);
+    }
+}
+
+test "parse with preview flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--preview" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.preview);
+    try std.testing.expect(!result.auto_add);
+}
+
+test "parse with dry-run flag" {
+    const long_args = &[_][]const u8{ "autocommit", "--dry-run" };
+    var long_result = try parseFromSlice(std.testing.allocator, long_args);
+    defer free(&long_result, std.testing.allocator);
+    try std.testing.expect(long_result.dry_run);
+
+    const short_args = &[_][]const u8{ "autocommit", "-n", "--add" };
+    var short_result = try parseFromSlice(std.testing.allocator, short_args);
+    defer free(&short_result, std.testing.allocator);
+    try std.testing.expect(short_result.dry_run);
+    try std.testing.expect(short_result.auto_add);
+}
+
+test "parse with explain-config flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--explain-config", "--provider", "groq" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.explain_config);
+    try std.testing.expectEqualStrings("groq", result.provider.?);
+}
+
+test "parse with batch flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--batch", "--accept" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.batch);
+    try std.testing.expect(result.auto_accept);
+}
+
+test "parse with skip-checks flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--skip-checks" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.skip_checks);
+    try std.testing.expect(!result.batch);
+}
+
+test "parse with amend and no-verify flags" {
+    const test_args = &[_][]const u8{ "autocommit", "--amend", "--no-verify", "--sign", "--allow-secrets" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.amend);
+    try std.testing.expect(result.no_verify);
+    try std.testing.expect(result.sign);
+    try std.testing.expect(result.allow_secrets);
+    try std.testing.expect(!result.skip_checks);
+}
+
+test "parse completion command" {
+    const test_args = &[_][]const u8{ "autocommit", "completion", "zsh" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqual(Command.completion, result.command);
+    try std.testing.expectEqualStrings("zsh", result.completion_target.?);
+
+    try std.testing.expectError(error.MissingCompletionShell, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "completion" }));
+}
+
 test "parse with provider flag" {
     const test_args = &[_][]const u8{ "autocommit", "--provider", "groq" };
     var result = try parseFromSlice(std.testing.allocator, test_args);
@@ -330,6 +843,125 @@ test "parse with provider flag" {
     try std.testing.expectEqualStrings("groq", result.provider.?);
 }
 
+test "parse with model flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--provider", "groq", "--model", "llama-3.3-70b-versatile" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqualStrings("groq", result.provider.?);
+    try std.testing.expectEqualStrings("llama-3.3-70b-versatile", result.model.?);
+}
+
+test "parse with context-file flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--context-file", "ticket.md" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expectEqualStrings("ticket.md", result.context_file.?);
+}
+
+test "parse with diff-file and stdin flags" {
+    const file_args = &[_][]const u8{ "autocommit", "--diff-file", "changes.patch" };
+    var file_result = try parseFromSlice(std.testing.allocator, file_args);
+    defer free(&file_result, std.testing.allocator);
+    try std.testing.expectEqualStrings("changes.patch", file_result.diff_file.?);
+
+    const stdin_args = &[_][]const u8{ "autocommit", "--diff-file", "changes.patch", "--stdin" };
+    var stdin_result = try parseFromSlice(std.testing.allocator, stdin_args);
+    defer free(&stdin_result, std.testing.allocator);
+    try std.testing.expectEqualStrings("-", stdin_result.diff_file.?);
+}
+
+test "parse with github-annotation flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--dry-run", "--github-annotation" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.github_annotation);
+    try std.testing.expect(result.dry_run);
+}
+
+test "parse with first-line-only flag" {
+    const test_args = &[_][]const u8{ "autocommit", "-n", "--first-line-only" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.first_line_only);
+    try std.testing.expect(result.dry_run);
+}
+
+test "parse with raw flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--raw", "-n" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+
+    try std.testing.expect(result.raw);
+    try std.testing.expect(result.dry_run);
+}
+
+test "parse with range flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--range", "main..HEAD" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqualStrings("main..HEAD", result.range.?);
+
+    try std.testing.expectError(error.MissingRangeValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--range" }));
+}
+
+test "parse with issue flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--issue", "123" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqualStrings("123", result.issue.?);
+
+    try std.testing.expectError(error.MissingIssueValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--issue" }));
+}
+
+test "parse with date flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--date", "2 hours ago" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqualStrings("2 hours ago", result.date.?);
+
+    try std.testing.expectError(error.MissingDateValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--date" }));
+}
+
+test "parse with candidates flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--candidates", "3" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqual(@as(?u32, 3), result.candidates);
+
+    try std.testing.expectError(error.MissingCandidatesValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--candidates" }));
+    try std.testing.expectError(error.InvalidCandidatesValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--candidates", "0" }));
+    try std.testing.expectError(error.InvalidCandidatesValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--candidates", "three" }));
+}
+
+test "parse with context flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--context", "0" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqual(@as(?u32, 0), result.context_commits);
+
+    try std.testing.expectError(error.MissingContextValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--context" }));
+    try std.testing.expectError(error.InvalidContextValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--context", "-1" }));
+}
+
+test "parse with proxy flag" {
+    const test_args = &[_][]const u8{ "autocommit", "--proxy", "http://proxy.corp:3128" };
+    var result = try parseFromSlice(std.testing.allocator, test_args);
+    defer free(&result, std.testing.allocator);
+    try std.testing.expectEqualStrings("http://proxy.corp:3128", result.proxy.?);
+
+    try std.testing.expectError(error.MissingProxyValue, parseFromSlice(std.testing.allocator, &[_][]const u8{ "autocommit", "--proxy" }));
+}
+
+test "parse missing diff-file value" {
+    const test_args = &[_][]const u8{ "autocommit", "--diff-file" };
+    const result = parseFromSlice(std.testing.allocator, test_args);
+    try std.testing.expectError(error.MissingDiffFileValue, result);
+}
+
 test "parse with debug flag" {
     const test_args = &[_][]const u8{ "autocommit", "--debug" };
     var result = try parseFromSlice(std.testing.allocator, test_args);
@@ -369,9 +1001,14 @@ test "help text output" {
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "config"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "config show"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "config path"));
+    try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "config profile use"));
+    try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--profile"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--add"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--push"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--accept"));
+    try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--preview"));
+    try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--batch"));
+    try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--skip-checks"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--provider"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--debug"));
     try std.testing.expect(std.mem.containsAtLeast(u8, help_output, 1, "--version"));
@@ -397,6 +1034,12 @@ test "parse missing provider value" {
     try std.testing.expectError(error.MissingProviderValue, result);
 }
 
+test "parse missing model value" {
+    const test_args = &[_][]const u8{ "autocommit", "--model" };
+    const result = parseFromSlice(std.testing.allocator, test_args);
+    try std.testing.expectError(error.MissingModelValue, result);
+}
+
 test "parse with unknown config subcommand" {
     const test_args = &[_][]const u8{ "autocommit", "config", "invalid" };
     var result = try parseFromSlice(std.testing.allocator, test_args);
@@ -483,6 +1126,12 @@ test "parse ignores unknown arguments" {
     try std.testing.expect(!result.auto_accept);
 }
 
+test "maskApiKey keeps only the last 4 characters" {
+    var buf: [8]u8 = undefined;
+    try std.testing.expectEqualStrings("****wxyz", maskApiKey(&buf, "gsk_abcdefghijklmnopqrstuvwxyz"));
+    try std.testing.expectEqualStrings("****", maskApiKey(&buf, "short"));
+}
+
 test "Args defaults" {
     const args = Args{};
     try std.testing.expectEqual(Command.main, args.command);
@@ -491,5 +1140,15 @@ test "Args defaults" {
     try std.testing.expect(!args.auto_push);
     try std.testing.expect(!args.auto_accept);
     try std.testing.expect(!args.debug);
+    try std.testing.expect(!args.preview);
+    try std.testing.expect(!args.dry_run);
+    try std.testing.expect(!args.batch);
+    try std.testing.expect(!args.skip_checks);
+    try std.testing.expect(!args.amend);
+    try std.testing.expect(!args.no_verify);
+    try std.testing.expect(!args.github_annotation);
     try std.testing.expect(args.provider == null);
+    try std.testing.expect(args.model == null);
+    try std.testing.expect(args.context_file == null);
+    try std.testing.expect(args.diff_file == null);
 }
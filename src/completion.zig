@@ -0,0 +1,296 @@
+const std = @import("std");
+const config = @import("config.zig");
+const registry = @import("providers/registry.zig");
+
+/// Shells `autocommit completion <shell>` can generate a script for
+pub const Shell = enum {
+    bash,
+    zsh,
+    fish,
+};
+
+/// What an option's value completes to
+const ValueKind = enum {
+    none,
+    file,
+    provider,
+    text,
+};
+
+const Option = struct {
+    name: []const u8,
+    value: ValueKind = .none,
+};
+
+/// Completed options; keep in sync with cli.printHelp
+const OPTIONS = [_]Option{
+    .{ .name = "add" },
+    .{ .name = "push" },
+    .{ .name = "accept" },
+    .{ .name = "yes" },
+    .{ .name = "preview" },
+    .{ .name = "dry-run" },
+    .{ .name = "batch" },
+    .{ .name = "skip-checks" },
+    .{ .name = "amend" },
+    .{ .name = "no-verify" },
+    .{ .name = "sign" },
+    .{ .name = "allow-secrets" },
+    .{ .name = "provider", .value = .provider },
+    .{ .name = "model", .value = .text },
+    .{ .name = "profile", .value = .text },
+    .{ .name = "context-file", .value = .file },
+    .{ .name = "diff-file", .value = .file },
+    .{ .name = "stdin" },
+    .{ .name = "range", .value = .text },
+    .{ .name = "issue", .value = .text },
+    .{ .name = "date", .value = .text },
+    .{ .name = "candidates", .value = .text },
+    .{ .name = "proxy", .value = .text },
+    .{ .name = "context", .value = .text },
+    .{ .name = "first-line-only" },
+    .{ .name = "raw" },
+    .{ .name = "github-annotation" },
+    .{ .name = "explain-config" },
+    .{ .name = "debug" },
+    .{ .name = "version" },
+    .{ .name = "help" },
+};
+
+const COMMANDS = "config undo serve completion";
+const CONFIG_SUBCOMMANDS = "show path get unset profile import set-key delete-key reset-prompt edit";
+const SHELLS = "bash zsh fish";
+
+/// Scripts call this back to list provider names, so they follow the config file
+const PROVIDERS_COMMAND = "autocommit completion providers 2>/dev/null";
+
+/// Write the completion script for `shell`
+pub fn writeScript(writer: anytype, shell: Shell) !void {
+    switch (shell) {
+        .bash => try writeBash(writer),
+        .zsh => try writeZsh(writer),
+        .fish => try writeFish(writer),
+    }
+}
+
+/// Print configured provider names one per line, or the built-in ones without a config
+pub fn writeProviderNames(writer: anytype, providers: []const config.ProviderConfig) !void {
+    if (providers.len == 0) {
+        for (registry.all) |metadata| {
+            try writer.print("{s}\n", .{metadata.id.name()});
+        }
+        return;
+    }
+    for (providers) |provider| {
+        try writer.print("{s}\n", .{provider.name});
+    }
+}
+
+/// Space-separated `--name` for every option
+fn writeOptionWords(writer: anytype) !void {
+    for (OPTIONS, 0..) |option, i| {
+        if (i > 0) try writer.writeAll(" ");
+        try writer.print("--{s}", .{option.name});
+    }
+}
+
+/// Indented `--a|--b` case pattern of the options taking a value of `kind`
+fn writeOptionPattern(writer: anytype, kind: ValueKind) !void {
+    try writer.writeAll("        ");
+    var first = true;
+    for (OPTIONS) |option| {
+        if (option.value != kind) continue;
+        if (!first) try writer.writeAll("|");
+        try writer.print("--{s}", .{option.name});
+        first = false;
+    }
+}
+
+fn writeBash(writer: anytype) !void {
+    try writer.writeAll(
+        \\# bash completion for autocommit
+        \\_autocommit() {
+        \\    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+        \\    case "$prev" in
+        \\
+    );
+    try writeOptionPattern(writer, .provider);
+    try writer.writeAll(
+        \\|set-key|delete-key)
+        \\            COMPREPLY=($(compgen -W "$(
+    ++ PROVIDERS_COMMAND ++
+        \\)" -- "$cur"))
+        \\            return ;;
+        \\
+    );
+    try writeOptionPattern(writer, .file);
+    try writer.writeAll(
+        \\)
+        \\            COMPREPLY=($(compgen -f -- "$cur"))
+        \\            return ;;
+        \\
+    );
+    try writeOptionPattern(writer, .text);
+    try writer.writeAll(
+        \\)
+        \\            return ;;
+        \\        config)
+        \\            COMPREPLY=($(compgen -W "
+    ++ CONFIG_SUBCOMMANDS ++
+        \\" -- "$cur"))
+        \\            return ;;
+        \\        completion)
+        \\            COMPREPLY=($(compgen -W "
+    ++ SHELLS ++
+        \\" -- "$cur"))
+        \\            return ;;
+        \\    esac
+        \\    if [[ "$cur" == -* ]]; then
+        \\        COMPREPLY=($(compgen -W "
+    );
+    try writeOptionWords(writer);
+    try writer.writeAll(
+        \\" -- "$cur"))
+        \\    else
+        \\        COMPREPLY=($(compgen -W "
+    ++ COMMANDS ++
+        \\" -- "$cur"))
+        \\    fi
+        \\}
+        \\complete -F _autocommit autocommit
+        \\
+    );
+}
+
+fn writeZsh(writer: anytype) !void {
+    try writer.writeAll(
+        \\#compdef autocommit
+        \\_autocommit() {
+        \\    case "$words[CURRENT-1]" in
+        \\
+    );
+    try writeOptionPattern(writer, .provider);
+    try writer.writeAll(
+        \\|set-key|delete-key)
+        \\            compadd -- ${(f)"$(
+    ++ PROVIDERS_COMMAND ++
+        \\)"}
+        \\            return ;;
+        \\
+    );
+    try writeOptionPattern(writer, .file);
+    try writer.writeAll(
+        \\)
+        \\            _files
+        \\            return ;;
+        \\
+    );
+    try writeOptionPattern(writer, .text);
+    try writer.writeAll(
+        \\)
+        \\            return ;;
+        \\        config)
+        \\            compadd --
+    ++ " " ++ CONFIG_SUBCOMMANDS ++
+        \\
+        \\            return ;;
+        \\        completion)
+        \\            compadd --
+    ++ " " ++ SHELLS ++
+        \\
+        \\            return ;;
+        \\    esac
+        \\    if [[ "$PREFIX" == -* ]]; then
+        \\        compadd --
+    ++ " ");
+    try writeOptionWords(writer);
+    try writer.writeAll(
+        \\
+        \\    else
+        \\        compadd --
+    ++ " " ++ COMMANDS ++
+        \\
+        \\    fi
+        \\}
+        \\if [[ "$funcstack[1]" == "_autocommit" ]]; then
+        \\    _autocommit "$@"
+        \\else
+        \\    compdef _autocommit autocommit
+        \\fi
+        \\
+    );
+}
+
+fn writeFish(writer: anytype) !void {
+    try writer.writeAll(
+        \\# fish completion for autocommit
+        \\complete -c autocommit -f
+        \\complete -c autocommit -n __fish_use_subcommand -a "
+    ++ COMMANDS ++
+        \\"
+        \\complete -c autocommit -n "__fish_seen_subcommand_from config" -a "
+    ++ CONFIG_SUBCOMMANDS ++
+        \\"
+        \\complete -c autocommit -n "__fish_seen_subcommand_from completion" -a "
+    ++ SHELLS ++
+        \\"
+        \\complete -c autocommit -n "__fish_seen_subcommand_from set-key delete-key" -a "(
+    ++ PROVIDERS_COMMAND ++
+        \\)"
+        \\
+    );
+    for (OPTIONS) |option| {
+        try writer.print("complete -c autocommit -l {s}", .{option.name});
+        switch (option.value) {
+            .none => {},
+            .file => try writer.writeAll(" -r -F"),
+            .provider => try writer.writeAll(" -x -a \"(" ++ PROVIDERS_COMMAND ++ ")\""),
+            .text => try writer.writeAll(" -x"),
+        }
+        try writer.writeAll("\n");
+    }
+}
+
+// Test section
+fn renderScript(shell: Shell) ![]const u8 {
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    errdefer output.deinit();
+    try writeScript(output.writer(), shell);
+    return output.toOwnedSlice();
+}
+
+test "bash script completes providers and file options" {
+    const script = try renderScript(.bash);
+    defer std.testing.allocator.free(script);
+
+    try std.testing.expect(std.mem.indexOf(u8, script, "        --provider|set-key|delete-key)\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, script, "        --context-file|--diff-file)\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, script, "compgen -W \"$(autocommit completion providers 2>/dev/null)\"") != null);
+    try std.testing.expect(std.mem.indexOf(u8, script, "--add --push --accept") != null);
+    try std.testing.expect(std.mem.endsWith(u8, script, "complete -F _autocommit autocommit\n"));
+}
+
+test "zsh and fish scripts register the command" {
+    const zsh = try renderScript(.zsh);
+    defer std.testing.allocator.free(zsh);
+    try std.testing.expect(std.mem.startsWith(u8, zsh, "#compdef autocommit\n"));
+    try std.testing.expect(std.mem.indexOf(u8, zsh, "        config)\n            compadd -- show path") != null);
+
+    const fish = try renderScript(.fish);
+    defer std.testing.allocator.free(fish);
+    try std.testing.expect(std.mem.indexOf(u8, fish, "complete -c autocommit -l provider -x -a \"(autocommit completion providers 2>/dev/null)\"\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, fish, "complete -c autocommit -l diff-file -r -F\n") != null);
+}
+
+test "writeProviderNames prefers configured providers" {
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    const configured = [_]config.ProviderConfig{ .{ .name = "groq", .model = "m" }, .{ .name = "work-ollama", .model = "m" } };
+    try writeProviderNames(output.writer(), &configured);
+    try std.testing.expectEqualStrings("groq\nwork-ollama\n", output.items);
+
+    output.clearRetainingCapacity();
+    try writeProviderNames(output.writer(), &.{});
+    try std.testing.expect(std.mem.indexOf(u8, output.items, "ollama\n") != null);
+}
@@ -0,0 +1,255 @@
+const std = @import("std");
+
+/// Byte range of a `key = value` assignment, including a multi-line string value
+pub const Span = struct {
+    start: usize,
+    /// End of the last line of the assignment, excluding its newline
+    end: usize,
+};
+
+/// Iterates lines, tracking multi-line strings so their contents aren't mistaken for keys or tables
+const LineIterator = struct {
+    content: []const u8,
+    pos: usize,
+    in_multiline: bool = false,
+
+    const Line = struct {
+        start: usize,
+        end: usize,
+        text: []const u8,
+        /// The line starts inside a multi-line string
+        in_string: bool,
+    };
+
+    fn next(self: *LineIterator) ?Line {
+        if (self.pos >= self.content.len) return null;
+        const start = self.pos;
+        const end = std.mem.indexOfScalarPos(u8, self.content, start, '\n') orelse self.content.len;
+        self.pos = end + 1;
+
+        const text = self.content[start..end];
+        const in_string = self.in_multiline;
+        const delimiters = std.mem.count(u8, text, "\"\"\"") + std.mem.count(u8, text, "'''");
+        if (delimiters % 2 == 1) self.in_multiline = !self.in_multiline;
+        return .{ .start = start, .end = end, .text = text, .in_string = in_string };
+    }
+};
+
+fn isTableHeader(line: []const u8) bool {
+    return std.mem.startsWith(u8, std.mem.trimLeft(u8, line, " \t"), "[");
+}
+
+/// Value text of `key = value` on this line, or null if the line assigns another key
+fn assignedValue(line: []const u8, key: []const u8) ?[]const u8 {
+    const trimmed = std.mem.trimLeft(u8, line, " \t");
+    if (!std.mem.startsWith(u8, trimmed, key)) return null;
+    const rest = std.mem.trimLeft(u8, trimmed[key.len..], " \t");
+    if (!std.mem.startsWith(u8, rest, "=")) return null;
+    return std.mem.trim(u8, rest[1..], " \t\r");
+}
+
+/// Find `key = ...` in the table starting at `region_start` (0 = the top-level settings)
+pub fn findAssignment(content: []const u8, region_start: usize, key: []const u8) ?Span {
+    var lines = LineIterator{ .content = content, .pos = region_start };
+    while (lines.next()) |line| {
+        if (line.in_string) continue;
+        if (isTableHeader(line.text)) return null;
+        if (assignedValue(line.text, key) == null) continue;
+
+        var end = line.end;
+        while (lines.in_multiline) {
+            const continued = lines.next() orelse break;
+            end = continued.end;
+        }
+        return .{ .start = line.start, .end = end };
+    }
+    return null;
+}
+
+const ProviderTable = struct {
+    /// Start of the `[[providers]]` header line
+    header_start: usize,
+    /// The table's `name = ...` line
+    name: Span,
+};
+
+/// Find the `[[providers]]` table named `name`
+fn findProviderTable(content: []const u8, name: []const u8) ?ProviderTable {
+    var lines = LineIterator{ .content = content, .pos = 0 };
+    while (lines.next()) |line| {
+        if (line.in_string) continue;
+        if (!std.mem.eql(u8, std.mem.trim(u8, line.text, " \t\r"), "[[providers]]")) continue;
+
+        const name_span = findAssignment(content, lines.pos, "name") orelse continue;
+        const value = assignedValue(content[name_span.start..name_span.end], "name").?;
+        if (value.len >= 2 and std.mem.eql(u8, value[1 .. value.len - 1], name)) {
+            return .{ .header_start = line.start, .name = name_span };
+        }
+    }
+    return null;
+}
+
+/// Start of the next table header at or after `from`, or the end of the content
+fn nextTableStart(content: []const u8, from: usize) usize {
+    var lines = LineIterator{ .content = content, .pos = from };
+    while (lines.next()) |line| {
+        if (!line.in_string and isTableHeader(line.text)) return line.start;
+    }
+    return content.len;
+}
+
+/// Quote `value` as a TOML basic string. Caller owns the result
+pub fn tomlString(allocator: std.mem.Allocator, value: []const u8) ![]const u8 {
+    // JSON string escapes are a subset of TOML basic-string escapes
+    return std.json.stringifyAlloc(allocator, value, .{});
+}
+
+/// Set a top-level `key = literal` (literal is already TOML-encoded), replacing an existing
+/// assignment or adding one at the top of the file. Caller owns the returned content
+pub fn setTopLevel(allocator: std.mem.Allocator, content: []const u8, key: []const u8, literal: []const u8) ![]const u8 {
+    if (findAssignment(content, 0, key)) |span| {
+        return std.mem.concat(allocator, u8, &.{ content[0..span.start], key, " = ", literal, content[span.end..] });
+    }
+    return std.mem.concat(allocator, u8, &.{ key, " = ", literal, "\n", content });
+}
+
+/// Set `key = literal` in the `[[providers]]` table named `provider`, adding the table if missing
+/// Caller owns the returned content
+pub fn setProviderValue(allocator: std.mem.Allocator, content: []const u8, provider: []const u8, key: []const u8, literal: []const u8) ![]const u8 {
+    const table = findProviderTable(content, provider) orelse {
+        const quoted_name = try tomlString(allocator, provider);
+        defer allocator.free(quoted_name);
+        const separator = if (content.len == 0 or std.mem.endsWith(u8, content, "\n")) "" else "\n";
+        return std.mem.concat(allocator, u8, &.{ content, separator, "\n[[providers]]\nname = ", quoted_name, "\n", key, " = ", literal, "\n" });
+    };
+
+    const table_start = @min(table.name.end + 1, content.len);
+    if (findAssignment(content, table_start, key)) |span| {
+        return std.mem.concat(allocator, u8, &.{ content[0..span.start], key, " = ", literal, content[span.end..] });
+    }
+    // A table whose name line ends the file has no trailing newline to insert after
+    if (table.name.end == content.len) {
+        return std.mem.concat(allocator, u8, &.{ content, "\n", key, " = ", literal, "\n" });
+    }
+    return std.mem.concat(allocator, u8, &.{ content[0..table_start], key, " = ", literal, "\n", content[table_start..] });
+}
+
+/// Remove `key` from the `[[providers]]` table named `provider`; returns null when it isn't set
+/// Caller owns the returned content
+pub fn removeProviderValue(allocator: std.mem.Allocator, content: []const u8, provider: []const u8, key: []const u8) !?[]const u8 {
+    const table = findProviderTable(content, provider) orelse return null;
+    const span = findAssignment(content, @min(table.name.end + 1, content.len), key) orelse return null;
+    const end = @min(span.end + 1, content.len);
+    return try std.mem.concat(allocator, u8, &.{ content[0..span.start], content[end..] });
+}
+
+/// Remove the whole `[[providers]]` table named `provider`; returns null when there is none
+/// Caller owns the returned content
+pub fn removeProviderTable(allocator: std.mem.Allocator, content: []const u8, provider: []const u8) !?[]const u8 {
+    const table = findProviderTable(content, provider) orelse return null;
+    const end = nextTableStart(content, @min(table.name.end + 1, content.len));
+    return try std.mem.concat(allocator, u8, &.{ content[0..table.header_start], content[end..] });
+}
+
+/// Remove a top-level assignment; returns null when the key isn't set. Caller owns the result
+pub fn removeTopLevel(allocator: std.mem.Allocator, content: []const u8, key: []const u8) !?[]const u8 {
+    const span = findAssignment(content, 0, key) orelse return null;
+    const end = @min(span.end + 1, content.len);
+    return try std.mem.concat(allocator, u8, &.{ content[0..span.start], content[end..] });
+}
+
+// Test section
+const sample_config =
+    \\default_provider = "groq"
+    \\system_prompt = """
+    \\  max_retries = 9
+    \\  [not a table]
+    \\"""
+    \\
+    \\[[providers]]
+    \\name = "groq"
+    \\model = "llama-3"
+    \\
+    \\[[providers]]
+    \\name = "zai"
+    \\api_key = "key"
+    \\
+;
+
+test "findAssignment skips multi-line strings and stops at tables" {
+    const prompt = findAssignment(sample_config, 0, "system_prompt").?;
+    try std.testing.expect(std.mem.startsWith(u8, sample_config[prompt.start..prompt.end], "system_prompt = \"\"\""));
+    try std.testing.expect(std.mem.endsWith(u8, sample_config[prompt.start..prompt.end], "\"\"\""));
+
+    try std.testing.expect(findAssignment(sample_config, 0, "max_retries") == null);
+    try std.testing.expect(findAssignment(sample_config, 0, "model") == null);
+}
+
+test "setTopLevel replaces or adds a top-level setting" {
+    const allocator = std.testing.allocator;
+
+    const replaced = try setTopLevel(allocator, sample_config, "system_prompt", "\"short\"");
+    defer allocator.free(replaced);
+    try std.testing.expect(std.mem.startsWith(u8, replaced, "default_provider = \"groq\"\nsystem_prompt = \"short\"\n\n[[providers]]"));
+
+    const added = try setTopLevel(allocator, sample_config, "active_profile", "\"work\"");
+    defer allocator.free(added);
+    try std.testing.expect(std.mem.startsWith(u8, added, "active_profile = \"work\"\ndefault_provider"));
+    try std.testing.expect(std.mem.endsWith(u8, added, sample_config));
+}
+
+test "setProviderValue edits the named table or appends one" {
+    const allocator = std.testing.allocator;
+
+    const replaced = try setProviderValue(allocator, sample_config, "groq", "model", "\"llama-3.3-70b\"");
+    defer allocator.free(replaced);
+    try std.testing.expect(std.mem.indexOf(u8, replaced, "name = \"groq\"\nmodel = \"llama-3.3-70b\"\n") != null);
+
+    const inserted = try setProviderValue(allocator, sample_config, "zai", "model", "\"glm-4.7\"");
+    defer allocator.free(inserted);
+    try std.testing.expect(std.mem.endsWith(u8, inserted, "name = \"zai\"\nmodel = \"glm-4.7\"\napi_key = \"key\"\n"));
+
+    const appended = try setProviderValue(allocator, sample_config, "ollama", "model", "\"llama3.2\"");
+    defer allocator.free(appended);
+    try std.testing.expect(std.mem.endsWith(u8, appended, "\n[[providers]]\nname = \"ollama\"\nmodel = \"llama3.2\"\n"));
+}
+
+test "removeProviderValue drops the assignment from the named table only" {
+    const allocator = std.testing.allocator;
+
+    const removed = (try removeProviderValue(allocator, sample_config, "zai", "api_key")).?;
+    defer allocator.free(removed);
+    try std.testing.expect(std.mem.endsWith(u8, removed, "name = \"zai\"\n"));
+
+    try std.testing.expect((try removeProviderValue(allocator, sample_config, "groq", "api_key")) == null);
+    try std.testing.expect((try removeProviderValue(allocator, sample_config, "ollama", "model")) == null);
+}
+
+test "removeTopLevel drops the whole assignment" {
+    const allocator = std.testing.allocator;
+
+    const removed = (try removeTopLevel(allocator, sample_config, "system_prompt")).?;
+    defer allocator.free(removed);
+    try std.testing.expect(std.mem.startsWith(u8, removed, "default_provider = \"groq\"\n\n[[providers]]"));
+    try std.testing.expect((try removeTopLevel(allocator, sample_config, "stream")) == null);
+}
+
+test "removeProviderTable drops the named table up to the next one" {
+    const allocator = std.testing.allocator;
+
+    const removed = (try removeProviderTable(allocator, sample_config, "groq")).?;
+    defer allocator.free(removed);
+    try std.testing.expect(std.mem.indexOf(u8, removed, "groq\"\nmodel") == null);
+    try std.testing.expect(std.mem.endsWith(u8, removed, "\"\"\"\n\n[[providers]]\nname = \"zai\"\napi_key = \"key\"\n"));
+
+    const last = (try removeProviderTable(allocator, sample_config, "zai")).?;
+    defer allocator.free(last);
+    try std.testing.expect(std.mem.endsWith(u8, last, "model = \"llama-3\"\n\n"));
+    try std.testing.expect((try removeProviderTable(allocator, sample_config, "ollama")) == null);
+}
+
+test "tomlString escapes quotes and newlines" {
+    const quoted = try tomlString(std.testing.allocator, "say \"hi\"\nthen\\leave");
+    defer std.testing.allocator.free(quoted);
+    try std.testing.expectEqualStrings("\"say \\\"hi\\\"\\nthen\\\\leave\"", quoted);
+}
@@ -1,4 +1,5 @@
 const std = @import("std");
+const commit_msg = @import("commit_msg.zig");
 
 pub const GitError = error{
     NotARepo,
@@ -280,6 +281,247 @@ pub const UntrackedIterator = struct {
     }
 };
 
+pub const Rename = struct {
+    from: []const u8,
+    to: []const u8,
+    score: ?u8 = null,
+};
+
+pub const Renames = struct {
+    arena: std.heap.ArenaAllocator,
+    entries: []Rename,
+    total_files: usize,
+
+    pub fn deinit(self: *Renames) void {
+        self.arena.deinit();
+    }
+
+    /// A change is rename-dominated when at least half of the changed files are renames
+    pub fn isRenameDominated(self: Renames) bool {
+        return self.entries.len > 0 and self.entries.len * 2 >= self.total_files;
+    }
+
+    /// Parse `git diff --name-status` output: R<score>\t<old>\t<new>
+    pub fn parseNameStatus(allocator: std.mem.Allocator, output: []const u8) !Renames {
+        var arena = std.heap.ArenaAllocator.init(allocator);
+        errdefer arena.deinit();
+        const arena_allocator = arena.allocator();
+
+        var entries = std.ArrayList(Rename).init(arena_allocator);
+        var total_files: usize = 0;
+
+        var lines = std.mem.splitScalar(u8, output, '\n');
+        while (lines.next()) |line| {
+            if (line.len == 0) continue;
+            total_files += 1;
+
+            if (line[0] != 'R') continue;
+
+            var fields = std.mem.splitScalar(u8, line, '\t');
+            const status_field = fields.next() orelse continue;
+            const from = fields.next() orelse continue;
+            const to = fields.next() orelse continue;
+
+            try entries.append(.{
+                .from = try arena_allocator.dupe(u8, from),
+                .to = try arena_allocator.dupe(u8, to),
+                .score = std.fmt.parseInt(u8, status_field[1..], 10) catch null,
+            });
+        }
+
+        return .{
+            .arena = arena,
+            .entries = try entries.toOwnedSlice(),
+            .total_files = total_files,
+        };
+    }
+};
+
+pub const GitVersion = struct {
+    major: u32,
+    minor: u32,
+    patch: u32 = 0,
+
+    pub fn atLeast(self: GitVersion, other: GitVersion) bool {
+        if (self.major != other.major) return self.major > other.major;
+        if (self.minor != other.minor) return self.minor > other.minor;
+        return self.patch >= other.patch;
+    }
+
+    /// Parse `git --version` output, e.g. "git version 2.39.3 (Apple Git-145)"
+    pub fn parse(output: []const u8) ?GitVersion {
+        const prefix = "git version ";
+        const trimmed = std.mem.trim(u8, output, " \t\r\n");
+        if (!std.mem.startsWith(u8, trimmed, prefix)) return null;
+
+        const rest = trimmed[prefix.len..];
+        const end = std.mem.indexOfAny(u8, rest, " ") orelse rest.len;
+
+        var parts = std.mem.splitScalar(u8, rest[0..end], '.');
+        const major = std.fmt.parseInt(u32, parts.next() orelse return null, 10) catch return null;
+        const minor = std.fmt.parseInt(u32, parts.next() orelse return null, 10) catch return null;
+        // Patch may carry suffixes like "1.windows.1" or "0-rc1"; default to 0 when unparsable
+        const patch = if (parts.next()) |p| parseLeadingInt(p) else 0;
+
+        return .{ .major = major, .minor = minor, .patch = patch };
+    }
+
+    fn parseLeadingInt(str: []const u8) u32 {
+        var end: usize = 0;
+        while (end < str.len and std.ascii.isDigit(str[end])) end += 1;
+        return std.fmt.parseInt(u32, str[0..end], 10) catch 0;
+    }
+};
+
+/// Oldest git supported: `status --porcelain=v2` was added in 2.11
+pub const MIN_GIT_VERSION = GitVersion{ .major = 2, .minor = 11 };
+
+/// `branch --show-current` was added in 2.22
+const SHOW_CURRENT_VERSION = GitVersion{ .major = 2, .minor = 22 };
+
+/// Check that git is on PATH and new enough, returning its version
+pub fn checkVersion(allocator: std.mem.Allocator) !GitVersion {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "--version" },
+        .max_output_bytes = 1024,
+    }) catch |err| {
+        return switch (err) {
+            error.FileNotFound => error.GitNotFound,
+            else => error.GitCommandFailed,
+        };
+    };
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    const version = GitVersion.parse(result.stdout) orelse return error.GitCommandFailed;
+    if (!version.atLeast(MIN_GIT_VERSION)) {
+        return error.GitTooOld;
+    }
+
+    return version;
+}
+
+/// Select the command for reading the current branch, falling back to
+/// `rev-parse --abbrev-ref HEAD` on git versions without `--show-current`
+pub fn currentBranchArgs(version: GitVersion) []const []const u8 {
+    if (version.atLeast(SHOW_CURRENT_VERSION)) {
+        return &[_][]const u8{ "git", "branch", "--show-current" };
+    }
+    return &[_][]const u8{ "git", "rev-parse", "--abbrev-ref", "HEAD" };
+}
+
+/// Get the current branch name
+/// Caller owns the returned memory
+pub fn getCurrentBranch(allocator: std.mem.Allocator, version: GitVersion) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = currentBranchArgs(version),
+        .max_output_bytes = 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trim(u8, result.stdout, " \t\r\n"));
+}
+
+/// URL of `remote` (`git remote get-url`)
+/// Caller owns the returned memory
+pub fn getRemoteUrl(allocator: std.mem.Allocator, remote: []const u8) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "remote", "get-url", remote },
+        .max_output_bytes = 4096,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trim(u8, result.stdout, " \t\r\n"));
+}
+
+pub const CommitInfo = struct {
+    subject: []const u8,
+    body: []const u8 = "",
+};
+
+pub const RecentCommits = struct {
+    arena: std.heap.ArenaAllocator,
+    commits: []CommitInfo,
+
+    pub fn deinit(self: *RecentCommits) void {
+        self.arena.deinit();
+    }
+
+    pub fn empty(allocator: std.mem.Allocator) RecentCommits {
+        return .{
+            .arena = std.heap.ArenaAllocator.init(allocator),
+            .commits = &[_]CommitInfo{},
+        };
+    }
+
+    /// Parse `git log --format=%s` output, one subject per line
+    pub fn parseSubjects(allocator: std.mem.Allocator, output: []const u8) !RecentCommits {
+        var arena = std.heap.ArenaAllocator.init(allocator);
+        errdefer arena.deinit();
+        const arena_allocator = arena.allocator();
+
+        var commits = std.ArrayList(CommitInfo).init(arena_allocator);
+
+        var lines = std.mem.splitScalar(u8, output, '\n');
+        while (lines.next()) |line| {
+            const subject = std.mem.trim(u8, line, " \t\r");
+            if (subject.len == 0) continue;
+            try commits.append(.{ .subject = try arena_allocator.dupe(u8, subject) });
+        }
+
+        return .{
+            .arena = arena,
+            .commits = try commits.toOwnedSlice(),
+        };
+    }
+
+    /// Parse `git log --format=%s%n%b%x00` output: NUL-separated records of subject + body
+    pub fn parseFull(allocator: std.mem.Allocator, output: []const u8) !RecentCommits {
+        var arena = std.heap.ArenaAllocator.init(allocator);
+        errdefer arena.deinit();
+        const arena_allocator = arena.allocator();
+
+        var commits = std.ArrayList(CommitInfo).init(arena_allocator);
+
+        var records = std.mem.splitScalar(u8, output, 0);
+        while (records.next()) |record| {
+            const trimmed = std.mem.trim(u8, record, " \t\r\n");
+            if (trimmed.len == 0) continue;
+
+            const subject_end = std.mem.indexOfScalar(u8, trimmed, '\n') orelse trimmed.len;
+            const subject = std.mem.trim(u8, trimmed[0..subject_end], " \t\r");
+            const body = std.mem.trim(u8, trimmed[subject_end..], " \t\r\n");
+
+            try commits.append(.{
+                .subject = try arena_allocator.dupe(u8, subject),
+                .body = try arena_allocator.dupe(u8, body),
+            });
+        }
+
+        return .{
+            .arena = arena,
+            .commits = try commits.toOwnedSlice(),
+        };
+    }
+};
+
 pub fn isRepo() bool {
     const result = std.process.Child.run(.{
         .allocator = std.heap.page_allocator,
@@ -392,10 +634,91 @@ pub fn getStagedDiff(allocator: std.mem.Allocator) ![]const u8 {
     return result.stdout;
 }
 
-pub fn commit(allocator: std.mem.Allocator, message: []const u8) !void {
+/// Get the diff of unstaged working tree changes (`git diff`)
+/// Read-only: never touches the index or creates commits
+pub fn getUnstagedDiff(allocator: std.mem.Allocator) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "diff" },
+        .max_output_bytes = 10 * 1024 * 1024, // 10MB max
+    }) catch return error.GitCommandFailed;
+
+    if (result.term.Exited != 0) {
+        allocator.free(result.stdout);
+        allocator.free(result.stderr);
+        return error.GitCommandFailed;
+    }
+
+    allocator.free(result.stderr);
+    return result.stdout;
+}
+
+/// Check if a diff contains any hunks (lines starting with "@@")
+fn hasHunks(diff: []const u8) bool {
+    if (std.mem.startsWith(u8, diff, "@@")) return true;
+    return std.mem.indexOf(u8, diff, "\n@@") != null;
+}
+
+/// A change is whitespace-only when the full diff has hunks but the
+/// whitespace-insensitive diff has none (`git diff -w` still prints file headers)
+pub fn isWhitespaceOnlyDiff(full_diff: []const u8, ignore_whitespace_diff: []const u8) bool {
+    return hasHunks(full_diff) and !hasHunks(ignore_whitespace_diff);
+}
+
+/// Check if the staged change only touches whitespace/indentation
+pub fn isWhitespaceOnlyChange(allocator: std.mem.Allocator, staged_diff: []const u8) !bool {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "diff", "--cached", "-w", "--ignore-blank-lines" },
+        .max_output_bytes = 10 * 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return isWhitespaceOnlyDiff(staged_diff, result.stdout);
+}
+
+pub const ShortStat = struct {
+    files: u32 = 0,
+    insertions: u32 = 0,
+    deletions: u32 = 0,
+
+    pub fn totalLines(self: ShortStat) u32 {
+        return self.insertions + self.deletions;
+    }
+
+    /// Parse `git diff --shortstat` output, e.g.
+    /// " 3 files changed, 10 insertions(+), 2 deletions(-)"
+    pub fn parse(output: []const u8) ShortStat {
+        var stat = ShortStat{};
+        var parts = std.mem.splitScalar(u8, std.mem.trim(u8, output, " \t\r\n"), ',');
+        while (parts.next()) |part| {
+            const trimmed = std.mem.trim(u8, part, " ");
+            const space = std.mem.indexOfScalar(u8, trimmed, ' ') orelse continue;
+            const count = std.fmt.parseInt(u32, trimmed[0..space], 10) catch continue;
+            const label = trimmed[space + 1 ..];
+
+            if (std.mem.startsWith(u8, label, "file")) {
+                stat.files = count;
+            } else if (std.mem.startsWith(u8, label, "insertion")) {
+                stat.insertions = count;
+            } else if (std.mem.startsWith(u8, label, "deletion")) {
+                stat.deletions = count;
+            }
+        }
+        return stat;
+    }
+};
+
+/// Get file/insertion/deletion totals for staged changes (`git diff --cached --shortstat`)
+pub fn getShortStat(allocator: std.mem.Allocator) !ShortStat {
     const result = std.process.Child.run(.{
         .allocator = allocator,
-        .argv = &[_][]const u8{ "git", "commit", "-m", message },
+        .argv = &[_][]const u8{ "git", "diff", "--cached", "--shortstat" },
         .max_output_bytes = 10 * 1024,
     }) catch return error.GitCommandFailed;
     defer allocator.free(result.stdout);
@@ -404,12 +727,196 @@ pub fn commit(allocator: std.mem.Allocator, message: []const u8) !void {
     if (result.term.Exited != 0) {
         return error.GitCommandFailed;
     }
+
+    return ShortStat.parse(result.stdout);
 }
 
-pub fn push(allocator: std.mem.Allocator) !void {
+/// Get the per-file summary of staged changes (`git diff --cached --stat`)
+/// Caller owns the returned memory
+pub fn getStagedDiffStat(allocator: std.mem.Allocator) ![]const u8 {
     const result = std.process.Child.run(.{
         .allocator = allocator,
-        .argv = &[_][]const u8{ "git", "push" },
+        .argv = &[_][]const u8{ "git", "diff", "--cached", "--stat" },
+        .max_output_bytes = 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+
+    if (result.term.Exited != 0) {
+        allocator.free(result.stdout);
+        allocator.free(result.stderr);
+        return error.GitCommandFailed;
+    }
+
+    allocator.free(result.stderr);
+    return result.stdout;
+}
+
+/// Detect staged renames using `git diff --cached --find-renames --name-status`
+pub fn getRenames(allocator: std.mem.Allocator) !Renames {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "diff", "--cached", "--find-renames", "--name-status" },
+        .max_output_bytes = 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return Renames.parseNameStatus(allocator, result.stdout);
+}
+
+/// How git cleans up the commit message (`--cleanup=<mode>`)
+pub const CleanupMode = enum {
+    strip,
+    whitespace,
+    verbatim,
+    scissors,
+
+    pub fn flag(self: CleanupMode) []const u8 {
+        return switch (self) {
+            .strip => "--cleanup=strip",
+            .whitespace => "--cleanup=whitespace",
+            .verbatim => "--cleanup=verbatim",
+            .scissors => "--cleanup=scissors",
+        };
+    }
+};
+
+pub const CommitOptions = struct {
+    /// Replace the last commit instead of creating a new one (`--amend`)
+    amend: bool = false,
+    /// Skip the pre-commit and commit-msg hooks (`--no-verify`)
+    no_verify: bool = false,
+    /// Message cleanup mode; null leaves git's default (commit.cleanup)
+    cleanup: ?CleanupMode = null,
+    /// Signing flag from signFlag ("-S" or "--gpg-sign=<keyid>"); null leaves commit.gpgsign in charge
+    gpg_sign: ?[]const u8 = null,
+    /// Author date flag ("--date=<when>"); null uses the current time
+    date: ?[]const u8 = null,
+};
+
+/// `-S` for the configured signing key, or `--gpg-sign=<keyid>` for an explicit one
+/// Caller owns the returned memory
+pub fn signFlag(allocator: std.mem.Allocator, key_id: []const u8) ![]const u8 {
+    const key = std.mem.trim(u8, key_id, " \t");
+    if (key.len == 0) return allocator.dupe(u8, "-S");
+    return std.fmt.allocPrint(allocator, "--gpg-sign={s}", .{key});
+}
+
+/// Whether git's stderr from a failed commit points at signing (GPG or SSH) rather than the commit
+pub fn isSigningFailure(stderr: []const u8) bool {
+    const markers = [_][]const u8{ "failed to sign", "gpg:", "ssh-keygen", "couldn't load public key", "signing key" };
+    for (markers) |marker| {
+        if (std.ascii.indexOfIgnoreCase(stderr, marker) != null) return true;
+    }
+    return false;
+}
+
+/// Build the `git commit` command, which reads the message from stdin (`-F -`)
+/// `buf` backs the returned slice
+pub fn commitArgs(buf: *[9][]const u8, options: CommitOptions) []const []const u8 {
+    buf.* = .{ "git", "commit", "-F", "-", "", "", "", "", "" };
+    var len: usize = 4;
+    if (options.amend) {
+        buf[len] = "--amend";
+        len += 1;
+    }
+    if (options.no_verify) {
+        buf[len] = "--no-verify";
+        len += 1;
+    }
+    if (options.cleanup) |mode| {
+        buf[len] = mode.flag();
+        len += 1;
+    }
+    if (options.gpg_sign) |flag| {
+        buf[len] = flag;
+        len += 1;
+    }
+    if (options.date) |flag| {
+        buf[len] = flag;
+        len += 1;
+    }
+    return buf[0..len];
+}
+
+/// Whether a message has nothing but whitespace, which git would reject or commit as blank
+pub fn isBlankMessage(message: []const u8) bool {
+    return std.mem.trim(u8, message, " \t\r\n").len == 0;
+}
+
+/// Commit the staged changes with `message`
+/// The message is piped to stdin rather than passed with -m, so long messages can't hit
+/// argument length limits and nothing in them is interpreted by a shell
+/// Returns error.EmptyMessage for a blank message, without running git, and
+/// error.SigningFailed when git could not sign the commit (e.g. no GPG or SSH agent)
+pub fn commit(allocator: std.mem.Allocator, message: []const u8, options: CommitOptions) !void {
+    if (isBlankMessage(message)) return error.EmptyMessage;
+
+    var buf: [9][]const u8 = undefined;
+    var child = std.process.Child.init(commitArgs(&buf, options), allocator);
+    child.stdin_behavior = .Pipe;
+    child.stdout_behavior = .Ignore;
+    child.stderr_behavior = .Pipe;
+    child.spawn() catch return error.GitCommandFailed;
+
+    child.stdin.?.writeAll(message) catch {
+        _ = child.kill() catch {};
+        return error.GitCommandFailed;
+    };
+    child.stdin.?.close();
+    child.stdin = null;
+
+    const stderr = child.stderr.?.reader().readAllAlloc(allocator, 1024 * 1024) catch {
+        _ = child.kill() catch {};
+        return error.GitCommandFailed;
+    };
+    defer allocator.free(stderr);
+
+    const term = child.wait() catch return error.GitCommandFailed;
+    const succeeded = switch (term) {
+        .Exited => |code| code == 0,
+        else => false,
+    };
+    if (succeeded) return;
+    if (isSigningFailure(stderr)) return error.SigningFailed;
+    return error.GitCommandFailed;
+}
+
+/// Build the `git commit-tree` command; `buf` backs the returned slice
+pub fn commitTreeArgs(buf: *[7][]const u8, tree: []const u8, parent: ?[]const u8, message: []const u8) []const []const u8 {
+    buf.* = .{ "git", "commit-tree", tree, "-m", message, "", "" };
+    if (parent) |p| {
+        buf[5] = "-p";
+        buf[6] = p;
+        return buf[0..7];
+    }
+    return buf[0..5];
+}
+
+/// Validate and return the object id printed by `git commit-tree`
+pub fn parseCommitHash(output: []const u8) ![]const u8 {
+    const hash = std.mem.trim(u8, output, " \t\r\n");
+    // SHA-1 repositories use 40 hex digits, SHA-256 repositories 64
+    if (hash.len != 40 and hash.len != 64) return error.InvalidCommitHash;
+    for (hash) |c| {
+        if (!std.ascii.isHex(c)) return error.InvalidCommitHash;
+    }
+    return hash;
+}
+
+/// Create a commit object from `tree` without touching the index or working tree
+/// Useful for bots and server-side tools; the caller decides which ref to update
+/// Returns error.EmptyMessage for a blank message; caller owns the returned commit hash
+pub fn commitTree(allocator: std.mem.Allocator, tree: []const u8, parent: ?[]const u8, message: []const u8) ![]const u8 {
+    if (isBlankMessage(message)) return error.EmptyMessage;
+
+    var buf: [7][]const u8 = undefined;
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = commitTreeArgs(&buf, tree, parent, message),
         .max_output_bytes = 10 * 1024,
     }) catch return error.GitCommandFailed;
     defer allocator.free(result.stdout);
@@ -418,22 +925,1392 @@ pub fn push(allocator: std.mem.Allocator) !void {
     if (result.term.Exited != 0) {
         return error.GitCommandFailed;
     }
+
+    return allocator.dupe(u8, try parseCommitHash(result.stdout));
 }
 
-pub fn truncateDiff(allocator: std.mem.Allocator, diff: []const u8, max_size: usize) ![]const u8 {
-    if (diff.len > max_size) {
-        return std.fmt.allocPrint(allocator, "{s}\n... (truncated)", .{diff[0..max_size]});
-    } else {
-        return allocator.dupe(u8, diff);
+/// Hash of the tree the index would commit (`git write-tree`)
+/// Writes any missing tree objects but leaves the index, working tree, and refs alone
+/// Caller owns the returned memory
+pub fn stagedTreeHash(allocator: std.mem.Allocator) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "write-tree" },
+        .max_output_bytes = 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
     }
+
+    return allocator.dupe(u8, try parseCommitHash(result.stdout));
 }
 
-pub fn unstagedAndUntrackedCount(status: *GitStatus) usize {
-    return status.unstagedCount() + status.untrackedCount();
+/// Whether the staged content differs from when `captured` was taken with stagedTreeHash
+pub fn stagedTreeChanged(allocator: std.mem.Allocator, captured: []const u8) !bool {
+    const current = try stagedTreeHash(allocator);
+    defer allocator.free(current);
+    return !std.mem.eql(u8, captured, current);
 }
 
-test "isRepo detects git repository" {
-    try std.testing.expect(isRepo());
+/// Get the repository's configured `i18n.commitEncoding`, or null when unset
+/// Caller owns the returned memory
+pub fn getCommitEncoding(allocator: std.mem.Allocator) !?[]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "config", "--get", "i18n.commitEncoding" },
+        .max_output_bytes = 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    // `git config --get` exits 1 when the key is not set
+    if (result.term.Exited != 0) return null;
+
+    const value = std.mem.trim(u8, result.stdout, " \t\r\n");
+    if (value.len == 0) return null;
+    return try allocator.dupe(u8, value);
+}
+
+/// Get the most recent commits (`git log -n <count>`), optionally including bodies
+/// Returns an empty list for repositories without commits
+pub fn getRecentCommits(allocator: std.mem.Allocator, count: usize, include_body: bool) !RecentCommits {
+    if (count == 0) return RecentCommits.empty(allocator);
+
+    const output = (try recentLog(allocator, count, include_body)) orelse return RecentCommits.empty(allocator);
+    defer allocator.free(output);
+    return parseRecentLog(allocator, output, include_body);
+}
+
+/// Like getRecentCommits, but reuses the `git log` output of a run less than `ttl_seconds`
+/// ago when HEAD hasn't moved since. A ttl of 0 disables the cache
+pub fn getRecentCommitsCached(allocator: std.mem.Allocator, count: usize, include_body: bool, ttl_seconds: u32) !RecentCommits {
+    if (count == 0 or ttl_seconds == 0) return getRecentCommits(allocator, count, include_body);
+
+    // No HEAD (a fresh repo) or no git dir: nothing worth caching
+    const head = headSha(allocator) catch return getRecentCommits(allocator, count, include_body);
+    defer allocator.free(head);
+    const cache_path = gitPath(allocator, LOG_CACHE_FILE) catch return getRecentCommits(allocator, count, include_body);
+    defer allocator.free(cache_path);
+
+    const cache = LogCache{ .path = cache_path, .ttl_seconds = ttl_seconds };
+    var key_buf: [128]u8 = undefined;
+    const key = try std.fmt.bufPrint(&key_buf, "{s} -n{d} body={}", .{ head, count, include_body });
+
+    if (cache.load(allocator, key, std.time.nanoTimestamp())) |cached| {
+        defer allocator.free(cached);
+        return parseRecentLog(allocator, cached, include_body);
+    }
+
+    const output = (try recentLog(allocator, count, include_body)) orelse return RecentCommits.empty(allocator);
+    defer allocator.free(output);
+    cache.store(key, output) catch {};
+    return parseRecentLog(allocator, output, include_body);
+}
+
+/// Raw `git log` output for getRecentCommits; null when the repository has no commits
+/// Caller owns the returned memory
+fn recentLog(allocator: std.mem.Allocator, count: usize, include_body: bool) !?[]const u8 {
+    var count_buf: [32]u8 = undefined;
+    const count_arg = try std.fmt.bufPrint(&count_buf, "-n{d}", .{count});
+    const format_arg = if (include_body) "--format=%s%n%b%x00" else "--format=%s";
+
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "log", count_arg, "--no-merges", format_arg },
+        .max_output_bytes = 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        // `git log` fails on a repository with no commits yet
+        allocator.free(result.stdout);
+        return null;
+    }
+    return result.stdout;
+}
+
+fn parseRecentLog(allocator: std.mem.Allocator, output: []const u8, include_body: bool) !RecentCommits {
+    if (include_body) {
+        return RecentCommits.parseFull(allocator, output);
+    }
+    return RecentCommits.parseSubjects(allocator, output);
+}
+
+/// File under the git dir holding the cached recent-commit log
+const LOG_CACHE_FILE = "autocommit-log-cache";
+
+/// A single cached `git log` output, valid while its key (HEAD and log options) matches
+/// and the file is younger than the ttl. Format: the key line, then the output
+pub const LogCache = struct {
+    path: []const u8,
+    ttl_seconds: u32,
+
+    /// Cached output for `key`, or null when missing, stale, or for another key
+    /// Caller owns the returned memory
+    pub fn load(self: LogCache, allocator: std.mem.Allocator, key: []const u8, now_ns: i128) ?[]const u8 {
+        const file = std.fs.cwd().openFile(self.path, .{}) catch return null;
+        defer file.close();
+
+        const stat = file.stat() catch return null;
+        if (now_ns - stat.mtime > @as(i128, self.ttl_seconds) * std.time.ns_per_s) return null;
+
+        const content = file.readToEndAlloc(allocator, 2 * 1024 * 1024) catch return null;
+        defer allocator.free(content);
+
+        const key_end = std.mem.indexOfScalar(u8, content, '\n') orelse return null;
+        if (!std.mem.eql(u8, content[0..key_end], key)) return null;
+        return allocator.dupe(u8, content[key_end + 1 ..]) catch null;
+    }
+
+    pub fn store(self: LogCache, key: []const u8, output: []const u8) !void {
+        const file = try std.fs.cwd().createFile(self.path, .{});
+        defer file.close();
+        try file.writeAll(key);
+        try file.writeAll("\n");
+        try file.writeAll(output);
+    }
+};
+
+/// Full SHA of HEAD; fails in a repository without commits
+/// Caller owns the returned memory
+pub fn headSha(allocator: std.mem.Allocator) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "rev-parse", "--verify", "--quiet", "HEAD" },
+        .max_output_bytes = 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trim(u8, result.stdout, " \t\r\n"));
+}
+
+/// Path of `name` inside the git dir (`git rev-parse --git-path`), honouring worktrees
+/// Caller owns the returned memory
+fn gitPath(allocator: std.mem.Allocator, name: []const u8) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "rev-parse", "--git-path", name },
+        .max_output_bytes = 4096,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trim(u8, result.stdout, " \t\r\n"));
+}
+
+/// Check a revision range such as `main..feature` or `v1.0...HEAD` before handing it to git
+pub fn validateRange(range: []const u8) !void {
+    const separator = std.mem.indexOf(u8, range, "..") orelse return error.InvalidRange;
+    const dots: usize = if (std.mem.startsWith(u8, range[separator..], "...")) 3 else 2;
+    const from = range[0..separator];
+    const to = range[separator + dots ..];
+
+    if (from.len == 0 or to.len == 0) return error.InvalidRange;
+    if (std.mem.indexOf(u8, to, "..") != null) return error.InvalidRange;
+    // A leading dash would be read as an option
+    if (from[0] == '-') return error.InvalidRange;
+    for (range) |c| {
+        if (std.ascii.isWhitespace(c) or std.ascii.isControl(c)) return error.InvalidRange;
+    }
+}
+
+/// Build `git diff <range> --`; `buf` backs the returned slice
+pub fn rangeDiffArgs(buf: *[4][]const u8, range: []const u8) []const []const u8 {
+    buf.* = .{ "git", "diff", range, "--" };
+    return buf;
+}
+
+/// Build `git log` listing the range's subjects oldest first; `buf` backs the returned slice
+pub fn rangeLogArgs(buf: *[6][]const u8, range: []const u8) []const []const u8 {
+    buf.* = .{ "git", "log", "--reverse", "--format=%s", range, "--" };
+    return buf;
+}
+
+/// Get the combined diff of a revision range, e.g. to summarize commits being squashed
+/// Caller owns the returned memory
+pub fn getRangeDiff(allocator: std.mem.Allocator, range: []const u8) ![]const u8 {
+    try validateRange(range);
+
+    var buf: [4][]const u8 = undefined;
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = rangeDiffArgs(&buf, range),
+        .max_output_bytes = 10 * 1024 * 1024, // 10MB max
+    }) catch return error.GitCommandFailed;
+
+    if (result.term.Exited != 0) {
+        allocator.free(result.stdout);
+        allocator.free(result.stderr);
+        return error.GitCommandFailed;
+    }
+
+    allocator.free(result.stderr);
+    return result.stdout;
+}
+
+/// Get the subjects of the commits in a revision range, oldest first
+pub fn getRangeCommits(allocator: std.mem.Allocator, range: []const u8) !RecentCommits {
+    try validateRange(range);
+
+    var buf: [6][]const u8 = undefined;
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = rangeLogArgs(&buf, range),
+        .max_output_bytes = 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    // Unlike recent commits, an unknown revision is the caller's mistake and is reported
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return RecentCommits.parseSubjects(allocator, result.stdout);
+}
+
+/// Get the subject line of a commit (`git log -1 --format=%s <ref>`)
+/// Caller owns the returned memory
+pub fn getCommitSubject(allocator: std.mem.Allocator, ref: []const u8) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "log", "-1", "--format=%s", ref, "--" },
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trim(u8, result.stdout, " \t\r\n"));
+}
+
+/// Get the full message of a commit (`git log -1 --format=%B <ref>`), without trailing newlines
+/// Caller owns the returned memory
+pub fn getCommitMessage(allocator: std.mem.Allocator, ref: []const u8) ![]const u8 {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "log", "-1", "--format=%B", ref, "--" },
+        .max_output_bytes = 64 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return allocator.dupe(u8, std.mem.trimRight(u8, result.stdout, " \t\r\n"));
+}
+
+/// Tree with no files, used as the parent side when amending a root commit (SHA-1 repositories)
+pub const EMPTY_TREE = "4b825dc642cb6eb9a060e54bf8d69288fbee4904";
+
+/// Get the diff an amended commit would contain: HEAD's own changes plus the staged ones
+/// Caller owns the returned memory
+pub fn getAmendDiff(allocator: std.mem.Allocator) ![]const u8 {
+    // The root commit has no parent to compare against
+    const base = if (hasParent(allocator)) "HEAD~1" else EMPTY_TREE;
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "diff", "--cached", base, "--" },
+        .max_output_bytes = 10 * 1024 * 1024, // 10MB max
+    }) catch return error.GitCommandFailed;
+
+    if (result.term.Exited != 0) {
+        allocator.free(result.stdout);
+        allocator.free(result.stderr);
+        return error.GitCommandFailed;
+    }
+
+    allocator.free(result.stderr);
+    return result.stdout;
+}
+
+fn hasParent(allocator: std.mem.Allocator) bool {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "rev-parse", "--verify", "--quiet", "HEAD~1" },
+        .max_output_bytes = 1024,
+    }) catch return false;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+    return result.term.Exited == 0;
+}
+
+/// Check that the committed subject matches the first line of the generated message
+pub fn subjectMatches(generated: []const u8, committed_subject: []const u8) bool {
+    return std.mem.eql(u8, commit_msg.subjectLine(generated), std.mem.trim(u8, committed_subject, " \t\r\n"));
+}
+
+/// Command used by `autocommit undo`: uncommit HEAD but keep its changes staged
+pub const SOFT_RESET_ARGS = [_][]const u8{ "git", "reset", "--soft", "HEAD~1" };
+
+/// Undo the last commit, keeping its changes staged
+pub fn softResetLast(allocator: std.mem.Allocator) !void {
+    const parent = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "rev-parse", "--verify", "--quiet", "HEAD~1" },
+        .max_output_bytes = 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(parent.stdout);
+    defer allocator.free(parent.stderr);
+
+    if (parent.term.Exited != 0) {
+        return error.NoParentCommit;
+    }
+
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &SOFT_RESET_ARGS,
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+}
+
+/// Check `git branch -r --contains` output for any remote branch
+pub fn hasRemoteBranch(branch_output: []const u8) bool {
+    var lines = std.mem.splitScalar(u8, branch_output, '\n');
+    while (lines.next()) |line| {
+        if (std.mem.trim(u8, line, " \t\r").len > 0) return true;
+    }
+    return false;
+}
+
+/// Check whether `ref` is reachable from any remote-tracking branch (i.e. already pushed)
+pub fn isCommitPushed(allocator: std.mem.Allocator, ref: []const u8) !bool {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "branch", "-r", "--contains", ref },
+        .max_output_bytes = 64 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+
+    return hasRemoteBranch(result.stdout);
+}
+
+/// Notes ref used for autocommit provenance, kept separate from the default notes
+pub const NOTES_REF = "autocommit";
+
+/// Build the `git notes add` command for attaching a note to a commit
+pub fn noteArgs(ref: []const u8, note: []const u8) [8][]const u8 {
+    return .{ "git", "notes", "--ref=" ++ NOTES_REF, "add", "-f", "-m", note, ref };
+}
+
+/// Attach a note (e.g. generation metadata) to a commit under refs/notes/autocommit
+pub fn addNote(allocator: std.mem.Allocator, ref: []const u8, note: []const u8) !void {
+    const argv = noteArgs(ref, note);
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &argv,
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+}
+
+pub fn push(allocator: std.mem.Allocator) !void {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "push" },
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return classifyPushError(result.stderr);
+    }
+}
+
+/// Map `git push` stderr to a specific error where we can suggest a fix
+pub fn classifyPushError(stderr: []const u8) error{ NoUpstream, GitCommandFailed } {
+    if (std.mem.indexOf(u8, stderr, "has no upstream branch") != null or
+        std.mem.indexOf(u8, stderr, "No configured push destination") != null)
+    {
+        return error.NoUpstream;
+    }
+    return error.GitCommandFailed;
+}
+
+/// Group name for files at the repository root in batch mode
+pub const ROOT_GROUP = "(root)";
+
+/// Changed paths grouped by top-level directory, in insertion order
+pub const FileGroups = struct {
+    map: std.StringArrayHashMap(std.ArrayListUnmanaged([]const u8)),
+
+    pub fn deinit(self: *FileGroups) void {
+        for (self.map.values()) |*paths| {
+            paths.deinit(self.map.allocator);
+        }
+        self.map.deinit();
+    }
+};
+
+fn topLevelName(path: []const u8) []const u8 {
+    const slash = std.mem.indexOfScalar(u8, path, '/') orelse return ROOT_GROUP;
+    return path[0..slash];
+}
+
+/// Group paths by top-level directory; paths are borrowed, not copied
+pub fn groupByTopLevel(allocator: std.mem.Allocator, paths: []const []const u8) !FileGroups {
+    var groups = FileGroups{
+        .map = std.StringArrayHashMap(std.ArrayListUnmanaged([]const u8)).init(allocator),
+    };
+    errdefer groups.deinit();
+
+    for (paths) |path| {
+        const entry = try groups.map.getOrPut(topLevelName(path));
+        if (!entry.found_existing) {
+            entry.value_ptr.* = .{};
+        }
+        try entry.value_ptr.append(allocator, path);
+    }
+
+    return groups;
+}
+
+/// Build argv for a git command followed by `--` and the given paths
+/// Caller owns the returned slice (path strings are borrowed)
+pub fn pathCommandArgs(allocator: std.mem.Allocator, command: []const []const u8, paths: []const []const u8) ![]const []const u8 {
+    const argv = try allocator.alloc([]const u8, command.len + 1 + paths.len);
+    @memcpy(argv[0..command.len], command);
+    argv[command.len] = "--";
+    @memcpy(argv[command.len + 1 ..], paths);
+    return argv;
+}
+
+fn runPathCommand(allocator: std.mem.Allocator, command: []const []const u8, paths: []const []const u8) !void {
+    const argv = try pathCommandArgs(allocator, command, paths);
+    defer allocator.free(argv);
+
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = argv,
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+}
+
+/// Unstage the given paths, keeping working tree changes (`git reset -q -- <paths>`)
+pub fn unstagePaths(allocator: std.mem.Allocator, paths: []const []const u8) !void {
+    try runPathCommand(allocator, &[_][]const u8{ "git", "reset", "-q" }, paths);
+}
+
+/// The staged changes to `paths` as a patch that stagePatch can replay
+/// Prefixes, color, and external diff drivers are pinned so user diff settings can't break it
+/// Caller owns the returned memory
+pub fn stagedPatch(allocator: std.mem.Allocator, paths: []const []const u8) ![]const u8 {
+    const argv = try pathCommandArgs(allocator, &[_][]const u8{ "git", "diff", "--cached", "--binary", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/" }, paths);
+    defer allocator.free(argv);
+
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = argv,
+        .max_output_bytes = 10 * 1024 * 1024,
+    }) catch return error.GitCommandFailed;
+    allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        allocator.free(result.stdout);
+        return error.GitCommandFailed;
+    }
+    return result.stdout;
+}
+
+/// Apply a patch from stagedPatch to the index only (`git apply --cached`)
+pub fn stagePatch(allocator: std.mem.Allocator, patch: []const u8) !void {
+    var child = std.process.Child.init(&[_][]const u8{ "git", "apply", "--cached", "-" }, allocator);
+    child.stdin_behavior = .Pipe;
+    child.stdout_behavior = .Ignore;
+    child.stderr_behavior = .Ignore;
+    child.spawn() catch return error.GitCommandFailed;
+
+    child.stdin.?.writeAll(patch) catch {
+        _ = child.kill() catch {};
+        return error.GitCommandFailed;
+    };
+    child.stdin.?.close();
+    child.stdin = null;
+
+    const term = child.wait() catch return error.GitCommandFailed;
+    switch (term) {
+        .Exited => |code| if (code != 0) return error.GitCommandFailed,
+        else => return error.GitCommandFailed,
+    }
+}
+
+/// Replace the index with `tree`, e.g. one captured by stagedTreeHash (`git read-tree`)
+/// The working tree is not touched
+pub fn restoreIndex(allocator: std.mem.Allocator, tree: []const u8) !void {
+    const result = std.process.Child.run(.{
+        .allocator = allocator,
+        .argv = &[_][]const u8{ "git", "read-tree", tree },
+        .max_output_bytes = 10 * 1024,
+    }) catch return error.GitCommandFailed;
+    defer allocator.free(result.stdout);
+    defer allocator.free(result.stderr);
+
+    if (result.term.Exited != 0) {
+        return error.GitCommandFailed;
+    }
+}
+
+/// The index operations commitGroups needs, run against the repository
+pub const RepoIndex = struct {
+    allocator: std.mem.Allocator,
+    commit_options: CommitOptions,
+
+    pub fn snapshot(self: RepoIndex) ![]const u8 {
+        return stagedTreeHash(self.allocator);
+    }
+
+    pub fn patchFor(self: RepoIndex, paths: []const []const u8) ![]const u8 {
+        return stagedPatch(self.allocator, paths);
+    }
+
+    pub fn unstage(self: RepoIndex, paths: []const []const u8) !void {
+        return unstagePaths(self.allocator, paths);
+    }
+
+    pub fn apply(self: RepoIndex, patch: []const u8) !void {
+        return stagePatch(self.allocator, patch);
+    }
+
+    pub fn stagedDiff(self: RepoIndex) ![]const u8 {
+        return getStagedDiff(self.allocator);
+    }
+
+    pub fn commitStaged(self: RepoIndex, message: []const u8) !void {
+        return commit(self.allocator, message, self.commit_options);
+    }
+
+    pub fn restore(self: RepoIndex, tree: []const u8) !void {
+        return restoreIndex(self.allocator, tree);
+    }
+};
+
+/// How a batch run ended
+pub const BatchResult = struct {
+    committed: usize = 0,
+    /// Files from declined groups, staged again afterwards
+    skipped: usize = 0,
+};
+
+/// Commit each group of staged paths on its own. The index is snapshotted and each group's
+/// staged changes saved as a patch before everything is unstaged; each group is then staged by
+/// replaying only its patch, so unstaged working tree edits are never committed.
+/// `index` is a RepoIndex or anything with the same methods, allocating with `allocator`.
+/// `driver` needs `message(name, paths, diff) !?[]const u8`, returning an owned message, or
+/// null to skip the group. The snapshot is read back at the end and on any error, so skipped
+/// groups stay staged and a failure leaves the index as it was before the batch
+pub fn commitGroups(allocator: std.mem.Allocator, index: anytype, groups: *const FileGroups, driver: anytype) !BatchResult {
+    const tree = try index.snapshot();
+    defer allocator.free(tree);
+
+    var patches = std.ArrayList([]const u8).init(allocator);
+    defer {
+        for (patches.items) |patch| allocator.free(patch);
+        patches.deinit();
+    }
+    var all_paths = std.ArrayList([]const u8).init(allocator);
+    defer all_paths.deinit();
+
+    for (groups.map.values()) |paths| {
+        const patch = try index.patchFor(paths.items);
+        errdefer allocator.free(patch);
+        try patches.append(patch);
+        try all_paths.appendSlice(paths.items);
+    }
+
+    errdefer index.restore(tree) catch {};
+    try index.unstage(all_paths.items);
+
+    var result = BatchResult{};
+    for (groups.map.keys(), groups.map.values(), patches.items) |name, paths, patch| {
+        if (patch.len > 0) try index.apply(patch);
+
+        const diff = try index.stagedDiff();
+        defer allocator.free(diff);
+
+        const message = (try driver.message(name, paths.items, diff)) orelse {
+            if (patch.len > 0) {
+                try index.unstage(paths.items);
+                result.skipped += paths.items.len;
+            }
+            continue;
+        };
+        defer allocator.free(message);
+
+        try index.commitStaged(message);
+        result.committed += 1;
+    }
+
+    try index.restore(tree);
+    return result;
+}
+
+/// Hunk body sizes tried (largest first) when shrinking an oversized diff
+const HUNK_KEEP_STEPS = [_]usize{ 400, 200, 100, 50, 20, 8, 0 };
+
+/// Shrink a diff to at most `max_size` bytes while keeping its shape
+/// File headers (`diff --git`, `---`/`+++`) and hunk headers (`@@`) are always kept; the middle of
+/// long hunks is replaced with `[... N lines truncated ...]`. If headers alone exceed the budget
+/// the diff is cut at a line boundary as a last resort.
+/// Caller owns the returned memory and must free it
+pub fn truncateDiff(allocator: std.mem.Allocator, diff: []const u8, max_size: usize) ![]const u8 {
+    if (diff.len <= max_size) {
+        return allocator.dupe(u8, diff);
+    }
+
+    for (HUNK_KEEP_STEPS) |keep| {
+        const condensed = try condenseHunks(allocator, diff, keep);
+        if (condensed.len <= max_size) return condensed;
+        allocator.free(condensed);
+    }
+
+    const cut = std.mem.lastIndexOfScalar(u8, diff[0..max_size], '\n') orelse max_size;
+    return std.fmt.allocPrint(allocator, "{s}\n... (truncated)", .{diff[0..cut]});
+}
+
+/// Rebuild the diff keeping at most `keep` body lines per hunk (head and tail)
+fn condenseHunks(allocator: std.mem.Allocator, diff: []const u8, keep: usize) ![]const u8 {
+    var output = std.ArrayList(u8).init(allocator);
+    errdefer output.deinit();
+
+    var hunk = std.ArrayList([]const u8).init(allocator);
+    defer hunk.deinit();
+
+    var in_hunk = false;
+    var lines = std.mem.splitScalar(u8, std.mem.trimRight(u8, diff, "\n"), '\n');
+    while (lines.next()) |line| {
+        const is_header = std.mem.startsWith(u8, line, "diff --git ") or std.mem.startsWith(u8, line, "@@");
+        if (is_header) {
+            try flushHunk(&output, hunk.items, keep);
+            hunk.clearRetainingCapacity();
+            in_hunk = std.mem.startsWith(u8, line, "@@");
+            try output.appendSlice(line);
+            try output.append('\n');
+        } else if (in_hunk) {
+            try hunk.append(line);
+        } else {
+            try output.appendSlice(line);
+            try output.append('\n');
+        }
+    }
+    try flushHunk(&output, hunk.items, keep);
+
+    if (!std.mem.endsWith(u8, diff, "\n")) {
+        _ = output.pop();
+    }
+
+    return output.toOwnedSlice();
+}
+
+fn flushHunk(output: *std.ArrayList(u8), hunk: []const []const u8, keep: usize) !void {
+    if (hunk.len <= keep) {
+        for (hunk) |line| {
+            try output.appendSlice(line);
+            try output.append('\n');
+        }
+        return;
+    }
+
+    const head = keep / 2;
+    const tail = keep - head;
+    for (hunk[0..head]) |line| {
+        try output.appendSlice(line);
+        try output.append('\n');
+    }
+    try output.writer().print("[... {d} lines truncated ...]\n", .{hunk.len - keep});
+    for (hunk[hunk.len - tail ..]) |line| {
+        try output.appendSlice(line);
+        try output.append('\n');
+    }
+}
+
+pub fn unstagedAndUntrackedCount(status: *GitStatus) usize {
+    return status.unstagedCount() + status.untrackedCount();
+}
+
+test "truncateDiff returns small diffs unchanged" {
+    const diff = "diff --git a/a.zig b/a.zig\n@@ -1 +1 @@\n-old\n+new\n";
+    const result = try truncateDiff(std.testing.allocator, diff, 1024);
+    defer std.testing.allocator.free(result);
+
+    try std.testing.expectEqualStrings(diff, result);
+}
+
+test "truncateDiff keeps headers and drops the middle of long hunks" {
+    var diff = std.ArrayList(u8).init(std.testing.allocator);
+    defer diff.deinit();
+
+    try diff.appendSlice("diff --git a/big.zig b/big.zig\n--- a/big.zig\n+++ b/big.zig\n@@ -0,0 +1,2000 @@\n");
+    for (0..2000) |i| try diff.writer().print("+line {d}\n", .{i});
+    try diff.appendSlice("diff --git a/small.zig b/small.zig\n--- a/small.zig\n+++ b/small.zig\n@@ -1 +1 @@\n-old\n+new\n");
+
+    const result = try truncateDiff(std.testing.allocator, diff.items, 4 * 1024);
+    defer std.testing.allocator.free(result);
+
+    try std.testing.expect(result.len <= 4 * 1024);
+    try std.testing.expect(std.mem.indexOf(u8, result, "diff --git a/big.zig b/big.zig\n--- a/big.zig\n+++ b/big.zig\n@@ -0,0 +1,2000 @@\n+line 0\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, result, "lines truncated ...]") != null);
+    try std.testing.expect(std.mem.indexOf(u8, result, "+line 1999\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, result, "+line 1000\n") == null);
+    try std.testing.expect(std.mem.endsWith(u8, result, "diff --git a/small.zig b/small.zig\n--- a/small.zig\n+++ b/small.zig\n@@ -1 +1 @@\n-old\n+new\n"));
+}
+
+test "truncateDiff falls back to a hard cut when headers exceed the budget" {
+    const diff = "diff --git a/a.zig b/a.zig\n@@ -1 +1 @@\n-old\n+new\ndiff --git a/b.zig b/b.zig\n@@ -1 +1 @@\n-old\n+new\n";
+    const result = try truncateDiff(std.testing.allocator, diff, 30);
+    defer std.testing.allocator.free(result);
+
+    try std.testing.expectEqualStrings("diff --git a/a.zig b/a.zig\n... (truncated)", result);
+}
+
+test "isRepo detects git repository" {
+    try std.testing.expect(isRepo());
+}
+
+test "Renames.parseNameStatus parses rename entries" {
+    const output = "R100\tsrc/old.zig\tsrc/new.zig\nM\tsrc/main.zig\nR087\tlib/a.zig\tlib/b.zig\n";
+    var renames = try Renames.parseNameStatus(std.testing.allocator, output);
+    defer renames.deinit();
+
+    try std.testing.expectEqual(@as(usize, 2), renames.entries.len);
+    try std.testing.expectEqual(@as(usize, 3), renames.total_files);
+    try std.testing.expectEqualStrings("src/old.zig", renames.entries[0].from);
+    try std.testing.expectEqualStrings("src/new.zig", renames.entries[0].to);
+    try std.testing.expectEqual(@as(u8, 100), renames.entries[0].score.?);
+    try std.testing.expectEqual(@as(u8, 87), renames.entries[1].score.?);
+    try std.testing.expect(renames.isRenameDominated());
+}
+
+test "Renames.parseNameStatus without renames" {
+    const output = "M\tsrc/main.zig\nA\tsrc/new.zig\nD\tsrc/old.zig\n";
+    var renames = try Renames.parseNameStatus(std.testing.allocator, output);
+    defer renames.deinit();
+
+    try std.testing.expectEqual(@as(usize, 0), renames.entries.len);
+    try std.testing.expectEqual(@as(usize, 3), renames.total_files);
+    try std.testing.expect(!renames.isRenameDominated());
+}
+
+test "Renames.parseNameStatus minority renames are not dominant" {
+    const output = "R100\ta.zig\tb.zig\nM\tc.zig\nM\td.zig\n";
+    var renames = try Renames.parseNameStatus(std.testing.allocator, output);
+    defer renames.deinit();
+
+    try std.testing.expectEqual(@as(usize, 1), renames.entries.len);
+    try std.testing.expect(!renames.isRenameDominated());
+}
+
+test "GitVersion.parse handles common formats" {
+    const linux = GitVersion.parse("git version 2.39.2\n").?;
+    try std.testing.expectEqual(@as(u32, 2), linux.major);
+    try std.testing.expectEqual(@as(u32, 39), linux.minor);
+    try std.testing.expectEqual(@as(u32, 2), linux.patch);
+
+    const apple = GitVersion.parse("git version 2.39.3 (Apple Git-145)").?;
+    try std.testing.expectEqual(@as(u32, 39), apple.minor);
+    try std.testing.expectEqual(@as(u32, 3), apple.patch);
+
+    const windows = GitVersion.parse("git version 2.45.1.windows.1").?;
+    try std.testing.expectEqual(@as(u32, 45), windows.minor);
+    try std.testing.expectEqual(@as(u32, 1), windows.patch);
+
+    const rc = GitVersion.parse("git version 2.46.0-rc1").?;
+    try std.testing.expectEqual(@as(u32, 0), rc.patch);
+
+    try std.testing.expect(GitVersion.parse("not git") == null);
+    try std.testing.expect(GitVersion.parse("git version abc") == null);
+}
+
+test "GitVersion.atLeast compares versions" {
+    const v = GitVersion{ .major = 2, .minor = 22, .patch = 1 };
+    try std.testing.expect(v.atLeast(.{ .major = 2, .minor = 22 }));
+    try std.testing.expect(v.atLeast(.{ .major = 2, .minor = 11 }));
+    try std.testing.expect(v.atLeast(.{ .major = 1, .minor = 99 }));
+    try std.testing.expect(!v.atLeast(.{ .major = 2, .minor = 23 }));
+    try std.testing.expect(!v.atLeast(.{ .major = 3, .minor = 0 }));
+    try std.testing.expect(!v.atLeast(.{ .major = 2, .minor = 22, .patch = 2 }));
+}
+
+test "currentBranchArgs falls back on old git" {
+    const modern = currentBranchArgs(.{ .major = 2, .minor = 30 });
+    try std.testing.expectEqualStrings("--show-current", modern[2]);
+
+    const old = currentBranchArgs(.{ .major = 2, .minor = 20 });
+    try std.testing.expectEqualStrings("rev-parse", old[1]);
+    try std.testing.expectEqualStrings("HEAD", old[3]);
+}
+
+test "getCommitSubject reads HEAD subject" {
+    const subject = try getCommitSubject(std.testing.allocator, "HEAD");
+    defer std.testing.allocator.free(subject);
+
+    try std.testing.expect(subject.len > 0);
+    try std.testing.expect(std.mem.indexOfScalar(u8, subject, '\n') == null);
+}
+
+test "subjectMatches compares generated and committed subjects" {
+    try std.testing.expect(subjectMatches("feat: add login", "feat: add login\n"));
+    try std.testing.expect(subjectMatches("feat: add login\n\n- body line", "feat: add login"));
+    try std.testing.expect(!subjectMatches("feat: add login", "feat: add logi"));
+    try std.testing.expect(!subjectMatches("# feat: add login", "feat: add login"));
+}
+
+test "RecentCommits.parseSubjects parses log output" {
+    var recent = try RecentCommits.parseSubjects(std.testing.allocator, "feat: add login\n\nfix(api): handle 500s\nWIP\n");
+    defer recent.deinit();
+
+    try std.testing.expectEqual(@as(usize, 3), recent.commits.len);
+    try std.testing.expectEqualStrings("feat: add login", recent.commits[0].subject);
+    try std.testing.expectEqualStrings("fix(api): handle 500s", recent.commits[1].subject);
+    try std.testing.expectEqualStrings("WIP", recent.commits[2].subject);
+}
+
+test "RecentCommits.parseFull parses subjects and bodies" {
+    const output = "feat: add login\n\n- Add form\n- Add validation\n\x00\nfix: typo\n\n\x00\n";
+    var recent = try RecentCommits.parseFull(std.testing.allocator, output);
+    defer recent.deinit();
+
+    try std.testing.expectEqual(@as(usize, 2), recent.commits.len);
+    try std.testing.expectEqualStrings("feat: add login", recent.commits[0].subject);
+    try std.testing.expectEqualStrings("- Add form\n- Add validation", recent.commits[0].body);
+    try std.testing.expectEqualStrings("fix: typo", recent.commits[1].subject);
+    try std.testing.expectEqualStrings("", recent.commits[1].body);
+}
+
+test "LogCache hits while HEAD is unchanged and misses once it moves" {
+    var tmp = std.testing.tmpDir(.{});
+    defer tmp.cleanup();
+    const dir_path = try tmp.dir.realpathAlloc(std.testing.allocator, ".");
+    defer std.testing.allocator.free(dir_path);
+    const path = try std.fs.path.join(std.testing.allocator, &.{ dir_path, LOG_CACHE_FILE });
+    defer std.testing.allocator.free(path);
+
+    const cache = LogCache{ .path = path, .ttl_seconds = 30 };
+    const now = std.time.nanoTimestamp();
+    try std.testing.expect(cache.load(std.testing.allocator, "abc123 -n5 body=false", now) == null);
+
+    try cache.store("abc123 -n5 body=false", "feat: add login\nfix: typo\n");
+
+    const hit = cache.load(std.testing.allocator, "abc123 -n5 body=false", now).?;
+    defer std.testing.allocator.free(hit);
+    try std.testing.expectEqualStrings("feat: add login\nfix: typo\n", hit);
+
+    // HEAD moved, or different log options
+    try std.testing.expect(cache.load(std.testing.allocator, "def456 -n5 body=false", now) == null);
+    try std.testing.expect(cache.load(std.testing.allocator, "abc123 -n5 body=true", now) == null);
+
+    // Older than the ttl
+    try std.testing.expect(cache.load(std.testing.allocator, "abc123 -n5 body=false", now + 31 * std.time.ns_per_s) == null);
+}
+
+test "getRecentCommits with zero count is empty" {
+    var recent = try getRecentCommits(std.testing.allocator, 0, false);
+    defer recent.deinit();
+
+    try std.testing.expectEqual(@as(usize, 0), recent.commits.len);
+}
+
+test "isWhitespaceOnlyDiff detects formatting-only changes" {
+    const full_diff =
+        "diff --git a/src/main.zig b/src/main.zig\n" ++
+        "index abc..def 100644\n" ++
+        "--- a/src/main.zig\n" ++
+        "+++ b/src/main.zig\n" ++
+        "@@ -1,3 +1,3 @@\n" ++
+        "-  const x = 1;\n" ++
+        "+    const x = 1;\n";
+    const ignore_whitespace_diff =
+        "diff --git a/src/main.zig b/src/main.zig\n" ++
+        "index abc..def 100644\n";
+
+    try std.testing.expect(isWhitespaceOnlyDiff(full_diff, ignore_whitespace_diff));
+    try std.testing.expect(isWhitespaceOnlyDiff(full_diff, ""));
+    try std.testing.expect(!isWhitespaceOnlyDiff(full_diff, full_diff));
+    try std.testing.expect(!isWhitespaceOnlyDiff("", ""));
+}
+
+test "ShortStat.parse parses totals" {
+    const stat = ShortStat.parse(" 3 files changed, 10 insertions(+), 2 deletions(-)\n");
+    try std.testing.expectEqual(@as(u32, 3), stat.files);
+    try std.testing.expectEqual(@as(u32, 10), stat.insertions);
+    try std.testing.expectEqual(@as(u32, 2), stat.deletions);
+    try std.testing.expectEqual(@as(u32, 12), stat.totalLines());
+}
+
+test "ShortStat.parse handles singular and missing parts" {
+    const insert_only = ShortStat.parse(" 1 file changed, 1 insertion(+)\n");
+    try std.testing.expectEqual(@as(u32, 1), insert_only.files);
+    try std.testing.expectEqual(@as(u32, 1), insert_only.insertions);
+    try std.testing.expectEqual(@as(u32, 0), insert_only.deletions);
+
+    const delete_only = ShortStat.parse(" 2 files changed, 5 deletions(-)");
+    try std.testing.expectEqual(@as(u32, 0), delete_only.insertions);
+    try std.testing.expectEqual(@as(u32, 5), delete_only.deletions);
+
+    const empty = ShortStat.parse("");
+    try std.testing.expectEqual(@as(u32, 0), empty.totalLines());
+}
+
+test "noteArgs builds notes command" {
+    const argv = noteArgs("HEAD", "autocommit: provider=groq");
+    try std.testing.expectEqualStrings("git", argv[0]);
+    try std.testing.expectEqualStrings("notes", argv[1]);
+    try std.testing.expectEqualStrings("--ref=autocommit", argv[2]);
+    try std.testing.expectEqualStrings("add", argv[3]);
+    try std.testing.expectEqualStrings("-f", argv[4]);
+    try std.testing.expectEqualStrings("-m", argv[5]);
+    try std.testing.expectEqualStrings("autocommit: provider=groq", argv[6]);
+    try std.testing.expectEqualStrings("HEAD", argv[7]);
+}
+
+test "SOFT_RESET_ARGS keeps changes staged" {
+    const expected = [_][]const u8{ "git", "reset", "--soft", "HEAD~1" };
+    for (expected, SOFT_RESET_ARGS) |want, actual| {
+        try std.testing.expectEqualStrings(want, actual);
+    }
+}
+
+test "hasRemoteBranch detects pushed commits" {
+    try std.testing.expect(hasRemoteBranch("  origin/main\n  origin/feature\n"));
+    try std.testing.expect(hasRemoteBranch("  origin/HEAD -> origin/main\n"));
+    try std.testing.expect(!hasRemoteBranch(""));
+    try std.testing.expect(!hasRemoteBranch("\n"));
+}
+
+test "commitArgs adds amend and no-verify flags" {
+    var buf: [9][]const u8 = undefined;
+
+    const plain = commitArgs(&buf, .{});
+    try std.testing.expectEqual(@as(usize, 4), plain.len);
+    try std.testing.expectEqualStrings("-F", plain[2]);
+    try std.testing.expectEqualStrings("-", plain[3]);
+
+    const both = commitArgs(&buf, .{ .amend = true, .no_verify = true });
+    const expected = [_][]const u8{ "git", "commit", "-F", "-", "--amend", "--no-verify" };
+    try std.testing.expectEqual(expected.len, both.len);
+    for (expected, both) |expected_arg, actual| {
+        try std.testing.expectEqualStrings(expected_arg, actual);
+    }
+
+    const no_verify = commitArgs(&buf, .{ .no_verify = true });
+    try std.testing.expectEqual(@as(usize, 5), no_verify.len);
+    try std.testing.expectEqualStrings("--no-verify", no_verify[4]);
+}
+
+test "commitArgs appends the cleanup mode after the other flags" {
+    var buf: [9][]const u8 = undefined;
+
+    const expected = [_]struct { CleanupMode, []const u8 }{
+        .{ .strip, "--cleanup=strip" },
+        .{ .whitespace, "--cleanup=whitespace" },
+        .{ .verbatim, "--cleanup=verbatim" },
+        .{ .scissors, "--cleanup=scissors" },
+    };
+    for (expected) |case| {
+        const args = commitArgs(&buf, .{ .cleanup = case[0] });
+        try std.testing.expectEqual(@as(usize, 5), args.len);
+        try std.testing.expectEqualStrings(case[1], args[4]);
+    }
+
+    const all = commitArgs(&buf, .{ .amend = true, .no_verify = true, .cleanup = .verbatim });
+    try std.testing.expectEqual(@as(usize, 7), all.len);
+    try std.testing.expectEqualStrings("--cleanup=verbatim", all[6]);
+}
+
+test "commitArgs appends the signing flag" {
+    var buf: [9][]const u8 = undefined;
+
+    const default_key = try signFlag(std.testing.allocator, "");
+    defer std.testing.allocator.free(default_key);
+    const signed = commitArgs(&buf, .{ .gpg_sign = default_key });
+    try std.testing.expectEqual(@as(usize, 5), signed.len);
+    try std.testing.expectEqualStrings("-S", signed[4]);
+
+    const explicit_key = try signFlag(std.testing.allocator, " 3AA5C34371567BD2 ");
+    defer std.testing.allocator.free(explicit_key);
+    const all = commitArgs(&buf, .{ .amend = true, .no_verify = true, .cleanup = .strip, .gpg_sign = explicit_key });
+    try std.testing.expectEqual(@as(usize, 8), all.len);
+    try std.testing.expectEqualStrings("--gpg-sign=3AA5C34371567BD2", all[7]);
+}
+
+test "commitArgs appends the date flag last" {
+    var buf: [9][]const u8 = undefined;
+
+    const dated = commitArgs(&buf, .{ .date = "--date=@1700000000 +0100" });
+    try std.testing.expectEqual(@as(usize, 5), dated.len);
+    try std.testing.expectEqualStrings("--date=@1700000000 +0100", dated[4]);
+
+    const all = commitArgs(&buf, .{ .amend = true, .no_verify = true, .cleanup = .strip, .gpg_sign = "-S", .date = "--date=now" });
+    try std.testing.expectEqual(@as(usize, 9), all.len);
+    try std.testing.expectEqualStrings("--date=now", all[8]);
+}
+
+test "isSigningFailure recognizes gpg and ssh signing errors" {
+    try std.testing.expect(isSigningFailure("error: gpg failed to sign the data\nfatal: failed to write commit object\n"));
+    try std.testing.expect(isSigningFailure("error: Couldn't load public key /home/me/.ssh/id_ed25519.pub: No such file or directory?\n"));
+    try std.testing.expect(!isSigningFailure("nothing to commit, working tree clean\n"));
+    try std.testing.expect(!isSigningFailure(""));
+}
+
+test "commit keeps quotes, newlines, and option-like lines intact" {
+    var tmp = std.testing.tmpDir(.{});
+    defer tmp.cleanup();
+    var original_cwd = try std.fs.cwd().openDir(".", .{});
+    defer original_cwd.close();
+    try tmp.dir.setAsCwd();
+    defer original_cwd.setAsCwd() catch {};
+
+    const setup = [_][]const []const u8{
+        &.{ "git", "init", "-q" },
+        &.{ "git", "config", "user.email", "test@example.com" },
+        &.{ "git", "config", "user.name", "Test" },
+        &.{ "git", "config", "commit.gpgsign", "false" },
+    };
+    for (setup) |argv| {
+        const result = try std.process.Child.run(.{ .allocator = std.testing.allocator, .argv = argv });
+        std.testing.allocator.free(result.stdout);
+        std.testing.allocator.free(result.stderr);
+    }
+    try tmp.dir.writeFile(.{ .sub_path = "notes.txt", .data = "hello\n" });
+    try addAll(std.testing.allocator);
+
+    const message = "fix: handle \"quotes\", $HOME, `ticks` & 100% of -m\n\n- Keep the body\n--amend is just text here";
+    try commit(std.testing.allocator, message, .{});
+
+    const committed = try getCommitMessage(std.testing.allocator, "HEAD");
+    defer std.testing.allocator.free(committed);
+    try std.testing.expectEqualStrings(message, committed);
+}
+
+test "stagedTreeChanged notices staged edits but not unstaged ones" {
+    var tmp = std.testing.tmpDir(.{});
+    defer tmp.cleanup();
+    var original_cwd = try std.fs.cwd().openDir(".", .{});
+    defer original_cwd.close();
+    try tmp.dir.setAsCwd();
+    defer original_cwd.setAsCwd() catch {};
+
+    const init = try std.process.Child.run(.{ .allocator = std.testing.allocator, .argv = &.{ "git", "init", "-q" } });
+    std.testing.allocator.free(init.stdout);
+    std.testing.allocator.free(init.stderr);
+
+    try tmp.dir.writeFile(.{ .sub_path = "notes.txt", .data = "hello\n" });
+    try addAll(std.testing.allocator);
+    const captured = try stagedTreeHash(std.testing.allocator);
+    defer std.testing.allocator.free(captured);
+    try std.testing.expect(!try stagedTreeChanged(std.testing.allocator, captured));
+
+    // An editor saving the file doesn't change what would be committed until it is staged
+    try tmp.dir.writeFile(.{ .sub_path = "notes.txt", .data = "hello again\n" });
+    try std.testing.expect(!try stagedTreeChanged(std.testing.allocator, captured));
+
+    try addAll(std.testing.allocator);
+    try std.testing.expect(try stagedTreeChanged(std.testing.allocator, captured));
+}
+
+test "commit and commitTree reject blank messages before running git" {
+    try std.testing.expect(isBlankMessage(""));
+    try std.testing.expect(isBlankMessage(" \n\t\r\n"));
+    try std.testing.expect(!isBlankMessage("\nfix: keep going\n"));
+
+    try std.testing.expectError(error.EmptyMessage, commit(std.testing.allocator, "  \n\n", .{}));
+    try std.testing.expectError(error.EmptyMessage, commitTree(std.testing.allocator, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", null, "\t"));
+}
+
+test "commitTreeArgs builds commit-tree command" {
+    var buf: [7][]const u8 = undefined;
+
+    const with_parent = commitTreeArgs(&buf, "4b825dc6", "HEAD", "feat: add bot support");
+    const expected_with_parent = [_][]const u8{ "git", "commit-tree", "4b825dc6", "-m", "feat: add bot support", "-p", "HEAD" };
+    try std.testing.expectEqual(expected_with_parent.len, with_parent.len);
+    for (expected_with_parent, with_parent) |expected, actual| {
+        try std.testing.expectEqualStrings(expected, actual);
+    }
+
+    const root = commitTreeArgs(&buf, "4b825dc6", null, "chore: initial commit");
+    try std.testing.expectEqual(@as(usize, 5), root.len);
+    try std.testing.expectEqualStrings("chore: initial commit", root[4]);
+}
+
+test "validateRange accepts two- and three-dot ranges" {
+    try validateRange("main..feature");
+    try validateRange("v1.0...HEAD");
+    try validateRange("HEAD~3..HEAD");
+
+    try std.testing.expectError(error.InvalidRange, validateRange("HEAD"));
+    try std.testing.expectError(error.InvalidRange, validateRange("..HEAD"));
+    try std.testing.expectError(error.InvalidRange, validateRange("main.."));
+    try std.testing.expectError(error.InvalidRange, validateRange("a..b..c"));
+    try std.testing.expectError(error.InvalidRange, validateRange("--output=x..HEAD"));
+    try std.testing.expectError(error.InvalidRange, validateRange("main ..HEAD"));
+}
+
+test "range args end with a path separator" {
+    var diff_buf: [4][]const u8 = undefined;
+    const diff_args = rangeDiffArgs(&diff_buf, "main..HEAD");
+    const expected_diff = [_][]const u8{ "git", "diff", "main..HEAD", "--" };
+    for (expected_diff, diff_args) |expected, actual| {
+        try std.testing.expectEqualStrings(expected, actual);
+    }
+
+    var log_buf: [6][]const u8 = undefined;
+    const log_args = rangeLogArgs(&log_buf, "main..HEAD");
+    try std.testing.expectEqual(@as(usize, 6), log_args.len);
+    try std.testing.expectEqualStrings("--reverse", log_args[2]);
+    try std.testing.expectEqualStrings("main..HEAD", log_args[4]);
+}
+
+test "getRangeDiff rejects invalid ranges before running git" {
+    try std.testing.expectError(error.InvalidRange, getRangeDiff(std.testing.allocator, "HEAD"));
+}
+
+test "parseCommitHash returns the new commit hash" {
+    const hash = try parseCommitHash("3f786850e387550fdab836ed7e6dc881de23001b\n");
+    try std.testing.expectEqualStrings("3f786850e387550fdab836ed7e6dc881de23001b", hash);
+
+    try std.testing.expectError(error.InvalidCommitHash, parseCommitHash("fatal: not a valid object name\n"));
+    try std.testing.expectError(error.InvalidCommitHash, parseCommitHash(""));
+}
+
+test "classifyPushError detects missing upstream" {
+    const no_upstream =
+        \\fatal: The current branch feature has no upstream branch.
+        \\To push the current branch and set the remote as upstream, use
+        \\
+        \\    git push --set-upstream origin feature
+    ;
+    try std.testing.expectEqual(error.NoUpstream, classifyPushError(no_upstream));
+    try std.testing.expectEqual(error.GitCommandFailed, classifyPushError("error: failed to push some refs"));
+}
+
+test "groupByTopLevel groups paths by first directory" {
+    const paths = [_][]const u8{ "README.md", "docs/usage.md", "src/git.zig", "src/providers/zai.zig", "build.zig" };
+
+    var groups = try groupByTopLevel(std.testing.allocator, &paths);
+    defer groups.deinit();
+
+    try std.testing.expectEqual(@as(usize, 3), groups.map.count());
+    try std.testing.expectEqualStrings(ROOT_GROUP, groups.map.keys()[0]);
+    try std.testing.expectEqualStrings("docs", groups.map.keys()[1]);
+    try std.testing.expectEqualStrings("src", groups.map.keys()[2]);
+
+    const root_paths = groups.map.get(ROOT_GROUP).?.items;
+    try std.testing.expectEqual(@as(usize, 2), root_paths.len);
+    try std.testing.expectEqualStrings("README.md", root_paths[0]);
+    try std.testing.expectEqualStrings("build.zig", root_paths[1]);
+
+    const src_paths = groups.map.get("src").?.items;
+    try std.testing.expectEqual(@as(usize, 2), src_paths.len);
+    try std.testing.expectEqualStrings("src/providers/zai.zig", src_paths[1]);
+}
+
+test "pathCommandArgs appends separator and paths" {
+    const paths = [_][]const u8{ "src/a.zig", "src/b.zig" };
+    const argv = try pathCommandArgs(std.testing.allocator, &[_][]const u8{ "git", "add", "-A" }, &paths);
+    defer std.testing.allocator.free(argv);
+
+    try std.testing.expectEqual(@as(usize, 6), argv.len);
+    try std.testing.expectEqualStrings("-A", argv[2]);
+    try std.testing.expectEqualStrings("--", argv[3]);
+    try std.testing.expectEqualStrings("src/a.zig", argv[4]);
+    try std.testing.expectEqualStrings("src/b.zig", argv[5]);
+}
+
+/// Records the index operations commitGroups runs; the staged diff is whatever patch was
+/// last applied, so each group's diff shows what was staged for it
+const FakeIndex = struct {
+    calls: std.ArrayList([]const u8),
+    staged: []const u8 = "",
+    /// Space-joined paths of a group that has nothing staged
+    empty_path: []const u8 = "",
+
+    fn init() FakeIndex {
+        return .{ .calls = std.ArrayList([]const u8).init(std.testing.allocator) };
+    }
+
+    fn deinit(self: *FakeIndex) void {
+        for (self.calls.items) |call| std.testing.allocator.free(call);
+        self.calls.deinit();
+    }
+
+    fn record(self: *FakeIndex, comptime fmt: []const u8, args: anytype) !void {
+        try self.calls.append(try std.fmt.allocPrint(std.testing.allocator, fmt, args));
+    }
+
+    fn joined(paths: []const []const u8) ![]const u8 {
+        return std.mem.join(std.testing.allocator, " ", paths);
+    }
+
+    pub fn snapshot(self: *FakeIndex) ![]const u8 {
+        try self.record("snapshot", .{});
+        return std.testing.allocator.dupe(u8, "tree");
+    }
+
+    pub fn patchFor(self: *FakeIndex, paths: []const []const u8) ![]const u8 {
+        const names = try joined(paths);
+        defer std.testing.allocator.free(names);
+        try self.record("patch {s}", .{names});
+        if (std.mem.eql(u8, names, self.empty_path)) return std.testing.allocator.dupe(u8, "");
+        return std.fmt.allocPrint(std.testing.allocator, "diff:{s}", .{names});
+    }
+
+    pub fn unstage(self: *FakeIndex, paths: []const []const u8) !void {
+        const names = try joined(paths);
+        defer std.testing.allocator.free(names);
+        try self.record("unstage {s}", .{names});
+        self.staged = "";
+    }
+
+    pub fn apply(self: *FakeIndex, patch: []const u8) !void {
+        try self.record("apply {s}", .{patch});
+        self.staged = patch;
+    }
+
+    pub fn stagedDiff(self: *FakeIndex) ![]const u8 {
+        return std.testing.allocator.dupe(u8, self.staged);
+    }
+
+    pub fn commitStaged(self: *FakeIndex, message: []const u8) !void {
+        try self.record("commit {s}", .{message});
+        self.staged = "";
+    }
+
+    pub fn restore(self: *FakeIndex, tree: []const u8) !void {
+        try self.record("restore {s}", .{tree});
+    }
+};
+
+/// Commits every group with a non-empty diff except `skip`, and fails on `fail`
+const FakeDriver = struct {
+    skip: []const u8 = "",
+    fail: []const u8 = "",
+
+    pub fn message(self: FakeDriver, name: []const u8, _: []const []const u8, diff: []const u8) !?[]const u8 {
+        if (std.mem.eql(u8, name, self.fail)) return error.GenerationFailed;
+        if (diff.len == 0 or std.mem.eql(u8, name, self.skip)) return null;
+        return try std.fmt.allocPrint(std.testing.allocator, "update {s} ({s})", .{ name, diff });
+    }
+};
+
+fn expectCalls(index: *const FakeIndex, expected: []const []const u8) !void {
+    try std.testing.expectEqual(expected.len, index.calls.items.len);
+    for (expected, index.calls.items) |want, got| {
+        try std.testing.expectEqualStrings(want, got);
+    }
+}
+
+test "commitGroups stages and commits one group at a time" {
+    const paths = [_][]const u8{ "README.md", "docs/usage.md", "src/a.zig", "src/b.zig" };
+    var groups = try groupByTopLevel(std.testing.allocator, &paths);
+    defer groups.deinit();
+
+    var index = FakeIndex.init();
+    defer index.deinit();
+
+    const result = try commitGroups(std.testing.allocator, &index, &groups, FakeDriver{ .skip = "docs" });
+    try std.testing.expectEqual(@as(usize, 2), result.committed);
+    try std.testing.expectEqual(@as(usize, 1), result.skipped);
+
+    try expectCalls(&index, &.{
+        "snapshot",
+        "patch README.md",
+        "patch docs/usage.md",
+        "patch src/a.zig src/b.zig",
+        "unstage README.md docs/usage.md src/a.zig src/b.zig",
+        "apply diff:README.md",
+        "commit update (root) (diff:README.md)",
+        "apply diff:docs/usage.md",
+        "unstage docs/usage.md",
+        "apply diff:src/a.zig src/b.zig",
+        "commit update src (diff:src/a.zig src/b.zig)",
+        "restore tree",
+    });
+}
+
+test "commitGroups skips a group with nothing staged" {
+    const paths = [_][]const u8{ "docs/usage.md", "src/a.zig" };
+    var groups = try groupByTopLevel(std.testing.allocator, &paths);
+    defer groups.deinit();
+
+    var index = FakeIndex.init();
+    defer index.deinit();
+    index.empty_path = "docs/usage.md";
+
+    const result = try commitGroups(std.testing.allocator, &index, &groups, FakeDriver{});
+    try std.testing.expectEqual(@as(usize, 1), result.committed);
+    try std.testing.expectEqual(@as(usize, 0), result.skipped);
+
+    try expectCalls(&index, &.{
+        "snapshot",
+        "patch docs/usage.md",
+        "patch src/a.zig",
+        "unstage docs/usage.md src/a.zig",
+        "apply diff:src/a.zig",
+        "commit update src (diff:src/a.zig)",
+        "restore tree",
+    });
+}
+
+test "commitGroups restores the index when a group fails" {
+    const paths = [_][]const u8{ "docs/usage.md", "src/a.zig", "tests/a_test.zig" };
+    var groups = try groupByTopLevel(std.testing.allocator, &paths);
+    defer groups.deinit();
+
+    var index = FakeIndex.init();
+    defer index.deinit();
+
+    try std.testing.expectError(error.GenerationFailed, commitGroups(std.testing.allocator, &index, &groups, FakeDriver{ .fail = "src" }));
+
+    try expectCalls(&index, &.{
+        "snapshot",
+        "patch docs/usage.md",
+        "patch src/a.zig",
+        "patch tests/a_test.zig",
+        "unstage docs/usage.md src/a.zig tests/a_test.zig",
+        "apply diff:docs/usage.md",
+        "commit update docs (diff:docs/usage.md)",
+        "apply diff:src/a.zig",
+        "restore tree",
+    });
 }
 
 test "FileStatus enum values" {
@@ -0,0 +1,52 @@
+const std = @import("std");
+
+/// Title shown on the notice in the Actions run summary
+pub const NOTICE_TITLE = "Suggested commit message";
+
+/// Whether we are running inside a GitHub Actions job (GITHUB_ACTIONS=true)
+pub fn isActions(allocator: std.mem.Allocator) bool {
+    const value = std.process.getEnvVarOwned(allocator, "GITHUB_ACTIONS") catch return false;
+    defer allocator.free(value);
+    return std.mem.eql(u8, value, "true");
+}
+
+/// Write `message` as a `::notice::` workflow command line
+/// Newlines are escaped so a multi-line message stays one command
+pub fn writeNotice(writer: anytype, title: []const u8, message: []const u8) !void {
+    try writer.writeAll("::notice title=");
+    try writeEscaped(writer, title, true);
+    try writer.writeAll("::");
+    try writeEscaped(writer, message, false);
+    try writer.writeAll("\n");
+}
+
+/// Escape workflow command data; property values also escape ':' and ','
+fn writeEscaped(writer: anytype, value: []const u8, is_property: bool) !void {
+    for (value) |c| {
+        switch (c) {
+            '%' => try writer.writeAll("%25"),
+            '\r' => try writer.writeAll("%0D"),
+            '\n' => try writer.writeAll("%0A"),
+            ':' => try writer.writeAll(if (is_property) "%3A" else ":"),
+            ',' => try writer.writeAll(if (is_property) "%2C" else ","),
+            else => try writer.writeByte(c),
+        }
+    }
+}
+
+// Test section
+test "writeNotice escapes newlines and percent signs" {
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    try writeNotice(output.writer(), NOTICE_TITLE, "feat(api): add 100% coverage\n\n- Add tests\r\n");
+    try std.testing.expectEqualStrings("::notice title=Suggested commit message::feat(api): add 100%25 coverage%0A%0A- Add tests%0D%0A\n", output.items);
+}
+
+test "writeNotice escapes property separators in the title" {
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    try writeNotice(output.writer(), "groq: llama, fast", "fix: typo");
+    try std.testing.expectEqualStrings("::notice title=groq%3A llama%2C fast::fix: typo\n", output.items);
+}
@@ -0,0 +1,97 @@
+const std = @import("std");
+const builtin = @import("builtin");
+
+/// Keychain service name API keys are stored under
+pub const SERVICE = "autocommit";
+
+/// OS secret stores reached through their command-line tools
+pub const Backend = enum {
+    /// macOS Keychain via `security`
+    macos_keychain,
+    /// libsecret (GNOME Keyring, KWallet) via `secret-tool`
+    secret_service,
+
+    /// The backend for this OS, if its tool is installed
+    pub fn detect(allocator: std.mem.Allocator) ?Backend {
+        const backend: Backend = switch (builtin.os.tag) {
+            .macos => .macos_keychain,
+            .linux => .secret_service,
+            else => return null,
+        };
+        const found = runCommand(allocator, &[_][]const u8{ "sh", "-c", backend.probeCommand() }, null) catch return null;
+        return if (found) backend else null;
+    }
+
+    fn probeCommand(self: Backend) []const u8 {
+        return switch (self) {
+            .macos_keychain => "command -v security",
+            .secret_service => "command -v secret-tool",
+        };
+    }
+
+    /// Shell command printing the stored key, suitable for `api_key_command`
+    /// Caller owns the returned memory
+    pub fn lookupCommand(self: Backend, allocator: std.mem.Allocator, provider: []const u8) ![]const u8 {
+        return switch (self) {
+            .macos_keychain => std.fmt.allocPrint(allocator, "security find-generic-password -s " ++ SERVICE ++ " -a {s} -w", .{provider}),
+            .secret_service => std.fmt.allocPrint(allocator, "secret-tool lookup service " ++ SERVICE ++ " provider {s}", .{provider}),
+        };
+    }
+
+    /// Save `key` for `provider`, replacing any existing entry
+    pub fn store(self: Backend, allocator: std.mem.Allocator, provider: []const u8, key: []const u8) !void {
+        const ok = switch (self) {
+            // `security` only takes the secret as an argument
+            .macos_keychain => try runCommand(allocator, &[_][]const u8{ "security", "add-generic-password", "-U", "-s", SERVICE, "-a", provider, "-w", key }, null),
+            .secret_service => blk: {
+                const label = try std.fmt.allocPrint(allocator, "autocommit API key ({s})", .{provider});
+                defer allocator.free(label);
+                break :blk try runCommand(allocator, &[_][]const u8{ "secret-tool", "store", "--label", label, "service", SERVICE, "provider", provider }, key);
+            },
+        };
+        if (!ok) return error.KeyringFailed;
+    }
+
+    /// Remove the stored key for `provider`
+    pub fn delete(self: Backend, allocator: std.mem.Allocator, provider: []const u8) !void {
+        const ok = switch (self) {
+            .macos_keychain => try runCommand(allocator, &[_][]const u8{ "security", "delete-generic-password", "-s", SERVICE, "-a", provider }, null),
+            .secret_service => try runCommand(allocator, &[_][]const u8{ "secret-tool", "clear", "service", SERVICE, "provider", provider }, null),
+        };
+        if (!ok) return error.KeyringFailed;
+    }
+};
+
+/// Run `argv` quietly, optionally writing `input` to its stdin; returns whether it exited 0
+fn runCommand(allocator: std.mem.Allocator, argv: []const []const u8, input: ?[]const u8) !bool {
+    var child = std.process.Child.init(argv, allocator);
+    child.stdin_behavior = if (input != null) .Pipe else .Ignore;
+    child.stdout_behavior = .Ignore;
+    child.stderr_behavior = .Ignore;
+    try child.spawn();
+
+    if (input) |data| {
+        try child.stdin.?.writeAll(data);
+        child.stdin.?.close();
+        child.stdin = null;
+    }
+
+    const term = try child.wait();
+    return switch (term) {
+        .Exited => |code| code == 0,
+        else => false,
+    };
+}
+
+// Test section
+test "lookupCommand reads back the stored entry" {
+    const allocator = std.testing.allocator;
+
+    const keychain = try Backend.macos_keychain.lookupCommand(allocator, "groq");
+    defer allocator.free(keychain);
+    try std.testing.expectEqualStrings("security find-generic-password -s autocommit -a groq -w", keychain);
+
+    const secret_service = try Backend.secret_service.lookupCommand(allocator, "zai");
+    defer allocator.free(secret_service);
+    try std.testing.expectEqualStrings("secret-tool lookup service autocommit provider zai", secret_service);
+}
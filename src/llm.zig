@@ -2,6 +2,7 @@ const std = @import("std");
 const http_client = @import("http_client.zig");
 const config = @import("config.zig");
 const registry = @import("providers/registry.zig");
+const commit_msg = @import("commit_msg.zig");
 
 pub const LlmError = error{
     InvalidApiKey,
@@ -10,12 +11,63 @@ pub const LlmError = error{
     Timeout,
     InvalidResponse,
     EmptyContent,
+    /// A reasoning model returned only its <think> section and no message
+    ReasoningOnly,
+    /// The request was larger than the model's context window
+    ContextLengthExceeded,
+    /// The configured model does not exist or was retired by the provider
+    ModelNotFound,
+    /// The endpoint answered 404 without saying why (usually a wrong endpoint URL or model)
+    NotFound,
     ApiError,
     OutOfMemory,
 };
 
+/// Sampling temperature for the first generation
+pub const DEFAULT_TEMPERATURE: f32 = 0.7;
+/// How much each regenerate raises the temperature
+pub const REGENERATE_TEMPERATURE_STEP: f32 = 0.15;
+/// Upper bound so repeated regenerates stay coherent
+pub const MAX_TEMPERATURE: f32 = 1.2;
+
+/// Temperature for the given regenerate attempt (0 = first generation), capped at MAX_TEMPERATURE
+pub fn regenerateTemperature(attempt: u32) f32 {
+    const nudged = DEFAULT_TEMPERATURE + REGENERATE_TEMPERATURE_STEP * @as(f32, @floatFromInt(attempt));
+    return @min(nudged, MAX_TEMPERATURE);
+}
+
+pub const FeatureMode = enum {
+    native,
+    fallback,
+};
+
+/// Token counts a provider reported for one request
+pub const Usage = struct {
+    prompt_tokens: u64 = 0,
+    completion_tokens: u64 = 0,
+    total_tokens: u64 = 0,
+
+    /// Read a non-negative integer field from a parsed JSON object, 0 when missing
+    pub fn field(object: std.json.ObjectMap, name: []const u8) u64 {
+        const value = object.get(name) orelse return 0;
+        return if (value == .integer and value.integer >= 0) @intCast(value.integer) else 0;
+    }
+
+    /// Whether the provider reported any counts
+    pub fn reported(self: Usage) bool {
+        return self.total_tokens > 0;
+    }
+
+    /// One summary line, e.g. "Tokens: 812 prompt + 9 completion = 821"
+    pub fn print(self: Usage, writer: anytype) !void {
+        try writer.print("Tokens: {d} prompt + {d} completion = {d}\n", .{ self.prompt_tokens, self.completion_tokens, self.total_tokens });
+    }
+};
+
 pub const DebugLogFn = *const fn (ctx: ?*anyopaque, message: []const u8) void;
 
+pub const TokenFn = *const fn (ctx: ?*anyopaque, token: []const u8) void;
+
 pub const Provider = struct {
     name: []const u8,
     config: config.ProviderConfig,
@@ -24,14 +76,47 @@ pub const Provider = struct {
     vtable: *const VTable,
     debug_log: ?DebugLogFn,
     debug_ctx: ?*anyopaque,
+    /// Sampling temperature sent with each request; raised on regenerate
+    temperature: f32 = DEFAULT_TEMPERATURE,
+    /// Request a streamed response (set internally when on_token is used)
+    stream: bool = false,
+    /// Called with each chunk of message content as it streams in
+    on_token: ?TokenFn = null,
+    token_ctx: ?*anyopaque = null,
+    /// Tried next when this provider fails with an API or network error (see isFallbackError)
+    fallback: ?*const Provider = null,
+    /// Retry once with a smaller diff on ContextLengthExceeded (see retryOnContextOverflow)
+    context_overflow_retry: bool = true,
+    /// Receives the token counts of each response that reports them, streamed or not
+    usage: ?*Usage = null,
+    /// Set to the registry model that answered when the configured one was not found
+    replacement_model: ?*?[]const u8 = null,
+    /// Return message content verbatim (--raw); only the empty check still applies
+    raw_output: bool = false,
+    /// Reflow the message body to this many columns (see commit_msg.formatBody); 0 = as generated
+    body_wrap_width: u32 = 0,
 
     pub const VTable = struct {
-        buildRequest: *const fn (self: Provider, diff: []const u8, prompt: []const u8) std.mem.Allocator.Error![]const u8,
+        buildRequest: *const fn (self: Provider, user_content: []const u8, prompt: []const u8) std.mem.Allocator.Error![]const u8,
         parseResponse: *const fn (self: Provider, response: []const u8) LlmError![]const u8,
         getEndpoint: *const fn (self: Provider) []const u8,
         getAuthHeader: *const fn (self: Provider) std.mem.Allocator.Error![]const u8,
+        /// Optional provider-specific cleanup of the raw message content before it is returned
+        postProcess: ?PostProcessFn = null,
+        /// Append the content carried by one streamed response line to `out`
+        /// Returns false for lines that are not part of the stream (e.g. a JSON error body)
+        appendStreamLine: ?AppendStreamLineFn = null,
+        /// Optional request URL built per request (e.g. with the model and key in it); caller frees
+        /// getEndpoint still names the endpoint in debug logs, so keys stay out of them
+        buildEndpoint: ?BuildEndpointFn = null,
+        /// Header that carries getAuthHeader's value instead of Authorization (e.g. Azure's api-key)
+        auth_header_name: ?[]const u8 = null,
     };
 
+    pub const PostProcessFn = *const fn (self: Provider, raw: []const u8) []const u8;
+    pub const AppendStreamLineFn = *const fn (self: Provider, line: []const u8, out: *std.ArrayList(u8)) LlmError!bool;
+    pub const BuildEndpointFn = *const fn (self: Provider) std.mem.Allocator.Error![]const u8;
+
     fn logDebug(self: Provider, comptime fmt: []const u8, args: anytype) void {
         if (self.debug_log) |log_fn| {
             var buf: [2048]u8 = undefined;
@@ -43,41 +128,281 @@ pub const Provider = struct {
         }
     }
 
-    pub fn generateCommitMessage(self: Provider, diff: []const u8, system_prompt: []const u8) LlmError![]const u8 {
+    /// Store and debug-log the token counts parsed from a response
+    pub fn recordUsage(self: Provider, usage: Usage) void {
+        self.logDebug("Token usage: prompt={d} completion={d} total={d}", .{ usage.prompt_tokens, usage.completion_tokens, usage.total_tokens });
+        if (self.usage) |target| target.* = usage;
+    }
+
+    /// Whether the provider natively supports an optional API feature
+    pub fn supports(self: Provider, capability: registry.Capability) bool {
+        const metadata = registry.getByName(self.name) orelse return false;
+        return metadata.capabilities.supports(capability);
+    }
+
+    /// Decide how a requested feature is served: natively, or via a client-side fallback
+    /// Callers must use the fallback path rather than sending an unsupported request
+    pub fn featureMode(self: Provider, capability: registry.Capability) FeatureMode {
+        return if (self.supports(capability)) .native else .fallback;
+    }
+
+    /// Parse configured "Name: value" header strings, skipping malformed entries, after
+    /// `key_header` and the provider's default headers that none of them replace (registry default_headers)
+    /// Caller owns the returned slice; header names/values borrow from the config, registry, and `key_header`
+    fn parseCustomHeaders(self: Provider, key_header: ?std.http.Header) std.mem.Allocator.Error![]std.http.Header {
+        var headers = std.ArrayList(std.http.Header).init(self.allocator);
+        errdefer headers.deinit();
+
+        if (key_header) |header| try headers.append(header);
+
+        if (registry.getByName(self.name)) |metadata| {
+            for (metadata.default_headers) |line| {
+                const header = http_client.parseHeaderLine(line) orelse continue;
+                if (!self.configuresHeader(header.name)) try headers.append(header);
+            }
+        }
+
+        for (self.config.headers) |line| {
+            if (http_client.parseHeaderLine(line)) |header| {
+                try headers.append(header);
+            } else {
+                self.logDebug("Ignoring malformed header: {s}", .{line});
+            }
+        }
+
+        return headers.toOwnedSlice();
+    }
+
+    fn configuresHeader(self: Provider, name: []const u8) bool {
+        for (self.config.headers) |line| {
+            const header = http_client.parseHeaderLine(line) orelse continue;
+            if (std.ascii.eqlIgnoreCase(header.name, name)) return true;
+        }
+        return false;
+    }
+
+    /// Request body and headers shared by the blocking and streaming paths
+    const PreparedRequest = struct {
+        body: []const u8,
+        auth_header: []const u8,
+        custom_headers: []std.http.Header,
+        /// The key went out in custom_headers under VTable.auth_header_name
+        key_in_headers: bool,
+
+        fn deinit(self: PreparedRequest, allocator: std.mem.Allocator) void {
+            allocator.free(self.body);
+            allocator.free(self.auth_header);
+            allocator.free(self.custom_headers);
+        }
+
+        /// Keyless providers (e.g. local ollama) return an empty auth header
+        fn auth(self: PreparedRequest) ?[]const u8 {
+            if (self.key_in_headers) return null;
+            return if (self.auth_header.len > 0) self.auth_header else null;
+        }
+    };
+
+    fn prepareRequest(self: Provider, user_content: []const u8, system_prompt: []const u8) LlmError!PreparedRequest {
         self.logDebug("Building LLM request...", .{});
 
-        const request_body = self.vtable.buildRequest(self, diff, system_prompt) catch |err| {
-            std.log.err("Failed to build request: {s}", .{@errorName(err)});
+        const request_body = self.vtable.buildRequest(self, user_content, system_prompt) catch |err| {
+            self.logDebug("Failed to build request: {s}", .{@errorName(err)});
             return LlmError.OutOfMemory;
         };
-        defer self.allocator.free(request_body);
+        errdefer self.allocator.free(request_body);
 
         self.logDebug("Request body size: {d} bytes", .{request_body.len});
 
-        const endpoint = self.vtable.getEndpoint(self);
         const auth_header = self.vtable.getAuthHeader(self) catch |err| {
-            std.log.err("Failed to build auth header: {s}", .{@errorName(err)});
+            self.logDebug("Failed to build auth header: {s}", .{@errorName(err)});
+            return LlmError.OutOfMemory;
+        };
+        errdefer self.allocator.free(auth_header);
+
+        const key_header: ?std.http.Header = if (self.vtable.auth_header_name) |name|
+            .{ .name = name, .value = auth_header }
+        else
+            null;
+        const custom_headers = self.parseCustomHeaders(key_header) catch |err| {
+            self.logDebug("Failed to build custom headers: {s}", .{@errorName(err)});
             return LlmError.OutOfMemory;
         };
-        defer self.allocator.free(auth_header);
 
-        self.logDebug("Sending request to {s}", .{endpoint});
+        return .{
+            .body = request_body,
+            .auth_header = auth_header,
+            .custom_headers = custom_headers,
+            .key_in_headers = key_header != null,
+        };
+    }
+
+    /// URL to send the request to (see VTable.buildEndpoint); caller owns the returned memory
+    fn requestUrl(self: Provider) LlmError![]const u8 {
+        const url: std.mem.Allocator.Error![]const u8 = if (self.vtable.buildEndpoint) |build_endpoint|
+            build_endpoint(self)
+        else
+            self.allocator.dupe(u8, self.vtable.getEndpoint(self));
+        return url catch |err| switch (err) {
+            error.OutOfMemory => return LlmError.OutOfMemory,
+        };
+    }
+
+    /// Trim raw message content, drop reasoning blocks, apply provider post-processing and
+    /// the shared fence/quote cleanup, and return an owned copy
+    /// With `raw_output` the content is returned untouched
+    pub fn finalizeContent(self: Provider, raw: []const u8) LlmError![]const u8 {
+        if (self.raw_output) {
+            if (std.mem.trim(u8, raw, " \n\r\t").len == 0) return LlmError.EmptyContent;
+            return self.allocator.dupe(u8, raw) catch |err| switch (err) {
+                error.OutOfMemory => return LlmError.OutOfMemory,
+            };
+        }
+
+        var trimmed = commit_msg.stripReasoning(raw) catch return LlmError.ReasoningOnly;
+        if (self.vtable.postProcess) |post_process| {
+            trimmed = std.mem.trim(u8, post_process(self, trimmed), " \n\r\t");
+        }
+        trimmed = commit_msg.sanitizeMessage(trimmed);
+        if (trimmed.len == 0) return LlmError.EmptyContent;
+
+        if (self.body_wrap_width > 0) {
+            return commit_msg.formatMessage(self.allocator, trimmed, self.body_wrap_width) catch |err| switch (err) {
+                error.OutOfMemory => return LlmError.OutOfMemory,
+            };
+        }
+
+        return self.allocator.dupe(u8, trimmed) catch |err| switch (err) {
+            error.OutOfMemory => return LlmError.OutOfMemory,
+        };
+    }
+
+    /// Generate a commit message from the assembled user content (see prompt.buildUserContent)
+    /// When the model is not found, the provider's next registry model is tried (see
+    /// registry.nextModel); on API or network errors the `fallback` chain is tried in order,
+    /// with the same temperature and token callback
+    pub fn generateCommitMessage(self: Provider, user_content: []const u8, system_prompt: []const u8) LlmError![]const u8 {
+        if (self.generateOnce(user_content, system_prompt)) |message| {
+            self.logDebug("Message generated by {s} (model {s})", .{ self.name, self.config.model });
+            return message;
+        } else |err| {
+            if (err == LlmError.ModelNotFound) {
+                if (registry.nextModel(self.name, self.config.model)) |model| {
+                    self.logDebug("Model {s} not found, retrying {s} with {s}", .{ self.config.model, self.name, model });
+                    var retry = self;
+                    retry.config.model = model;
+                    const message = try retry.generateCommitMessage(user_content, system_prompt);
+                    // The deepest retry reports the model that actually answered
+                    if (self.replacement_model) |target| {
+                        if (target.* == null) target.* = model;
+                    }
+                    return message;
+                }
+            }
+
+            const next_provider = self.fallback orelse return err;
+            if (!isFallbackError(err)) return err;
+
+            self.logDebug("{s} failed ({s}), falling back to {s}", .{ self.name, @errorName(err), next_provider.name });
+            var next = next_provider.*;
+            next.temperature = self.temperature;
+            next.on_token = self.on_token;
+            next.token_ctx = self.token_ctx;
+            next.usage = self.usage;
+            next.replacement_model = null;
+            next.raw_output = self.raw_output;
+            next.body_wrap_width = self.body_wrap_width;
+            return next.generateCommitMessage(user_content, system_prompt);
+        }
+    }
+
+    /// Single attempt with this provider: when `on_token` is set and the provider can stream,
+    /// tokens are reported as they arrive; otherwise this is one blocking request
+    fn generateOnce(self: Provider, user_content: []const u8, system_prompt: []const u8) LlmError![]const u8 {
+        if (self.on_token != null) {
+            if (self.featureMode(.streaming) == .native and self.vtable.appendStreamLine != null) {
+                return self.generateStreaming(user_content, system_prompt);
+            }
+            self.logDebug("Streaming not supported by {s}, using a blocking request", .{self.name});
+        }
+
+        const request = try self.prepareRequest(user_content, system_prompt);
+        defer request.deinit(self.allocator);
+
+        const url = try self.requestUrl();
+        defer self.allocator.free(url);
 
-        const response_body = self.http.postJson(endpoint, auth_header, request_body) catch |err| {
-            std.log.err("HTTP request failed: {s}", .{@errorName(err)});
+        const endpoint = self.vtable.getEndpoint(self);
+        self.logDebug("Sending request to {s} (model {s})", .{ endpoint, self.config.model });
+        const request_start = std.time.milliTimestamp();
+
+        const response_body = self.http.postJson(url, request.auth(), request.custom_headers, request.body) catch |err| {
+            self.logDebug("HTTP request failed after {d} ms: {s}", .{ std.time.milliTimestamp() - request_start, @errorName(err) });
             return mapHttpError(err);
         };
         defer self.allocator.free(response_body);
 
+        self.logDebug("Response received in {d} ms", .{std.time.milliTimestamp() - request_start});
         self.logDebug("Raw LLM response: {s}", .{response_body});
 
-        const parsed = self.vtable.parseResponse(self, response_body);
+        const parsed = self.applyStatus(self.vtable.parseResponse(self, response_body));
+        self.logParseResult(parsed);
+        return parsed;
+    }
+
+    fn generateStreaming(self: Provider, user_content: []const u8, system_prompt: []const u8) LlmError![]const u8 {
+        var streaming = self;
+        streaming.stream = true;
+
+        const request = try streaming.prepareRequest(user_content, system_prompt);
+        defer request.deinit(self.allocator);
+
+        const url = try streaming.requestUrl();
+        defer self.allocator.free(url);
+
+        const endpoint = self.vtable.getEndpoint(self);
+        self.logDebug("Streaming request to {s} (model {s})", .{ endpoint, self.config.model });
+        const request_start = std.time.milliTimestamp();
+
+        var state = StreamState.init(self);
+        defer state.deinit();
+
+        self.http.postJsonStream(url, request.auth(), request.custom_headers, request.body, &state) catch |err| {
+            self.logDebug("HTTP request failed after {d} ms: {s}", .{ std.time.milliTimestamp() - request_start, @errorName(err) });
+            return mapHttpError(err);
+        };
+
+        self.logDebug("Stream finished in {d} ms", .{std.time.milliTimestamp() - request_start});
+        if (state.unrecognized.items.len > 0) {
+            self.logDebug("Raw non-stream response: {s}", .{state.unrecognized.items});
+        }
+
+        const parsed = self.applyStatus(state.finish());
+        self.logParseResult(parsed);
+        return parsed;
+    }
+
+    /// Replace a generic parse failure with what the HTTP status says (see statusError);
+    /// errors the provider mapped from the body are kept
+    fn applyStatus(self: Provider, parsed: LlmError![]const u8) LlmError![]const u8 {
+        return parsed catch |err| {
+            if (err != LlmError.InvalidResponse and err != LlmError.ApiError) return err;
+            const status = self.http.last_status orelse return err;
+            const mapped = statusError(status) orelse return err;
+            self.logDebug("HTTP status {d}, reporting {s}", .{ @intFromEnum(status), @errorName(mapped) });
+            return mapped;
+        };
+    }
 
+    fn logParseResult(self: Provider, parsed: LlmError![]const u8) void {
         if (parsed) |_| {
             // Success - commit message will be displayed by main.zig
         } else |err| {
             switch (err) {
                 error.EmptyContent => self.logDebug("Parsed response: (empty content)", .{}),
+                error.ReasoningOnly => self.logDebug("Parsed response: (reasoning only, no message)", .{}),
+                error.ContextLengthExceeded => self.logDebug("Parsed response: (context length exceeded)", .{}),
+                error.ModelNotFound => self.logDebug("Parsed response: (model not found)", .{}),
+                error.NotFound => self.logDebug("Parsed response: (endpoint not found)", .{}),
                 error.InvalidResponse => self.logDebug("Parsed response: (invalid response)", .{}),
                 error.InvalidApiKey => self.logDebug("Parsed response: (invalid API key)", .{}),
                 error.RateLimited => self.logDebug("Parsed response: (rate limited)", .{}),
@@ -87,11 +412,119 @@ pub const Provider = struct {
                 error.OutOfMemory => self.logDebug("Parsed response: (out of memory)", .{}),
             }
         }
+    }
+};
 
-        return parsed;
+/// Accumulates a streamed response line by line (see HttpClient.postJsonStream)
+const StreamState = struct {
+    provider: Provider,
+    /// Message content received so far
+    message: std.ArrayList(u8),
+    /// Lines that were not stream chunks, e.g. a plain JSON error body
+    unrecognized: std.ArrayList(u8),
+    err: ?LlmError = null,
+
+    fn init(provider: Provider) StreamState {
+        return .{
+            .provider = provider,
+            .message = std.ArrayList(u8).init(provider.allocator),
+            .unrecognized = std.ArrayList(u8).init(provider.allocator),
+        };
+    }
+
+    fn deinit(self: *StreamState) void {
+        self.message.deinit();
+        self.unrecognized.deinit();
+    }
+
+    pub fn onLine(self: *StreamState, line: []const u8) bool {
+        const append_line = self.provider.vtable.appendStreamLine orelse unreachable;
+        const start = self.message.items.len;
+
+        const recognized = append_line(self.provider, line, &self.message) catch |err| {
+            self.err = err;
+            return false;
+        };
+
+        if (!recognized) {
+            self.unrecognized.appendSlice(line) catch {
+                self.err = LlmError.OutOfMemory;
+                return false;
+            };
+            self.unrecognized.append('\n') catch {
+                self.err = LlmError.OutOfMemory;
+                return false;
+            };
+        } else if (self.message.items.len > start) {
+            if (self.provider.on_token) |on_token| {
+                on_token(self.provider.token_ctx, self.message.items[start..]);
+            }
+        }
+        return true;
+    }
+
+    /// Final message, or the provider's error mapping when the server answered without streaming
+    fn finish(self: *StreamState) LlmError![]const u8 {
+        if (self.err) |err| return err;
+        if (self.message.items.len == 0 and self.unrecognized.items.len > 0) {
+            return self.provider.vtable.parseResponse(self.provider, self.unrecognized.items);
+        }
+        return self.provider.finalizeContent(self.message.items);
     }
 };
 
+/// The error an HTTP status implies on its own, for bodies the provider couldn't map
+pub fn statusError(status: std.http.Status) ?LlmError {
+    return switch (status) {
+        .unauthorized, .forbidden => LlmError.InvalidApiKey,
+        .not_found => LlmError.NotFound,
+        .too_many_requests => LlmError.RateLimited,
+        else => null,
+    };
+}
+
+/// Errors worth retrying with another provider: outages, rate limits, and API failures
+/// (bad output or a local problem like out-of-memory would not improve elsewhere)
+pub fn isFallbackError(err: LlmError) bool {
+    return switch (err) {
+        LlmError.RateLimited, LlmError.ServerError, LlmError.Timeout, LlmError.ApiError, LlmError.ModelNotFound => true,
+        else => false,
+    };
+}
+
+/// Whether a provider error message reports an oversized request
+/// Providers word this differently, e.g. "maximum context length is 8192 tokens" or "prompt is too long"
+pub fn isContextLengthMessage(message: []const u8) bool {
+    const markers = [_][]const u8{ "context length", "context_length", "context window", "prompt is too long", "too many tokens" };
+    for (markers) |marker| {
+        if (std.ascii.indexOfIgnoreCase(message, marker) != null) return true;
+    }
+    return false;
+}
+
+/// Whether a provider error message reports an unknown or retired model, e.g.
+/// "model 'x' not found" or "The model `x` has been decommissioned"
+pub fn isModelNotFoundMessage(message: []const u8) bool {
+    if (std.ascii.indexOfIgnoreCase(message, "model") == null) return false;
+    const markers = [_][]const u8{ "not found", "does not exist", "decommissioned", "no longer supported" };
+    for (markers) |marker| {
+        if (std.ascii.indexOfIgnoreCase(message, marker) != null) return true;
+    }
+    return false;
+}
+
+/// Divisor applied to the diff budget for the retry after a context-length error
+pub const CONTEXT_RETRY_DIVISOR = 2;
+
+/// Run `attempt.generate(diff_budget)`; when it fails with ContextLengthExceeded and `retry`
+/// is set, try once more with the budget divided by CONTEXT_RETRY_DIVISOR
+pub fn retryOnContextOverflow(attempt: anytype, diff_budget: usize, retry: bool) LlmError![]const u8 {
+    return attempt.generate(diff_budget) catch |err| {
+        if (!retry or err != LlmError.ContextLengthExceeded) return err;
+        return attempt.generate(diff_budget / CONTEXT_RETRY_DIVISOR);
+    };
+}
+
 fn mapHttpError(err: http_client.HttpError) LlmError {
     return switch (err) {
         http_client.HttpError.Timeout => LlmError.Timeout,
@@ -135,3 +568,219 @@ fn getVtable(name: []const u8) !*const Provider.VTable {
 test "Provider vtable lookup" {
     _ = try getVtable("zai");
 }
+
+fn testProvider(name: []const u8) Provider {
+    return .{
+        .name = name,
+        .config = .{ .name = name, .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+}
+
+test "featureMode uses native support when available" {
+    try std.testing.expectEqual(FeatureMode.native, testProvider("groq").featureMode(.streaming));
+    try std.testing.expectEqual(FeatureMode.native, testProvider("ollama").featureMode(.structured_output));
+}
+
+test "featureMode falls back for unsupported capabilities" {
+    try std.testing.expectEqual(FeatureMode.fallback, testProvider("groq").featureMode(.multi_choice));
+    try std.testing.expectEqual(FeatureMode.fallback, testProvider("zai").featureMode(.structured_output));
+    try std.testing.expectEqual(FeatureMode.fallback, testProvider("unknown").featureMode(.streaming));
+}
+
+test "parseCustomHeaders adds default headers that the config doesn't replace" {
+    var provider = testProvider("openrouter");
+    const configured = [_][]const u8{"X-Title: my-fork"};
+    provider.config.headers = &configured;
+
+    const headers = try provider.parseCustomHeaders(null);
+    defer std.testing.allocator.free(headers);
+
+    try std.testing.expectEqual(@as(usize, 2), headers.len);
+    try std.testing.expectEqualStrings("HTTP-Referer", headers[0].name);
+    try std.testing.expectEqualStrings("X-Title", headers[1].name);
+    try std.testing.expectEqualStrings("my-fork", headers[1].value);
+
+    const plain = try testProvider("groq").parseCustomHeaders(null);
+    defer std.testing.allocator.free(plain);
+    try std.testing.expectEqual(@as(usize, 0), plain.len);
+
+    const keyed = try testProvider("azure").parseCustomHeaders(.{ .name = "api-key", .value = "azure-key" });
+    defer std.testing.allocator.free(keyed);
+    try std.testing.expectEqual(@as(usize, 1), keyed.len);
+    try std.testing.expectEqualStrings("api-key", keyed[0].name);
+}
+
+test "applyStatus maps unrecognized error bodies by HTTP status" {
+    var http = http_client.HttpClient.init(std.testing.allocator);
+    defer http.deinit();
+    var provider = testProvider("groq");
+    provider.http = &http;
+
+    http.last_status = .unauthorized;
+    try std.testing.expectError(LlmError.InvalidApiKey, provider.applyStatus(LlmError.InvalidResponse));
+    http.last_status = .not_found;
+    try std.testing.expectError(LlmError.NotFound, provider.applyStatus(LlmError.ApiError));
+    // A specific error from the body wins over the status
+    try std.testing.expectError(LlmError.ModelNotFound, provider.applyStatus(LlmError.ModelNotFound));
+    http.last_status = .too_many_requests;
+    try std.testing.expectError(LlmError.RateLimited, provider.applyStatus(LlmError.InvalidResponse));
+    http.last_status = .bad_request;
+    try std.testing.expectError(LlmError.ApiError, provider.applyStatus(LlmError.ApiError));
+}
+
+test "isFallbackError only covers API and network failures" {
+    try std.testing.expect(isFallbackError(LlmError.RateLimited));
+    try std.testing.expect(isFallbackError(LlmError.ServerError));
+    try std.testing.expect(isFallbackError(LlmError.Timeout));
+    try std.testing.expect(isFallbackError(LlmError.ApiError));
+    try std.testing.expect(isFallbackError(LlmError.ModelNotFound));
+    try std.testing.expect(!isFallbackError(LlmError.InvalidApiKey));
+    try std.testing.expect(!isFallbackError(LlmError.EmptyContent));
+    try std.testing.expect(!isFallbackError(LlmError.OutOfMemory));
+}
+
+/// Fails with ContextLengthExceeded until the budget drops to `fits_within`
+const FakeAttempt = struct {
+    fits_within: usize,
+    budgets: [2]usize = .{ 0, 0 },
+    calls: usize = 0,
+
+    fn generate(self: *FakeAttempt, diff_budget: usize) LlmError![]const u8 {
+        self.budgets[self.calls] = diff_budget;
+        self.calls += 1;
+        if (diff_budget > self.fits_within) return LlmError.ContextLengthExceeded;
+        return "feat: fit the diff";
+    }
+};
+
+test "retryOnContextOverflow retries once with half the budget" {
+    var attempt = FakeAttempt{ .fits_within = 60 };
+    try std.testing.expectEqualStrings("feat: fit the diff", try retryOnContextOverflow(&attempt, 100, true));
+    try std.testing.expectEqual(@as(usize, 2), attempt.calls);
+    try std.testing.expectEqual(@as(usize, 50), attempt.budgets[1]);
+
+    var still_too_big = FakeAttempt{ .fits_within = 10 };
+    try std.testing.expectError(LlmError.ContextLengthExceeded, retryOnContextOverflow(&still_too_big, 100, true));
+    try std.testing.expectEqual(@as(usize, 2), still_too_big.calls);
+
+    var disabled = FakeAttempt{ .fits_within = 60 };
+    try std.testing.expectError(LlmError.ContextLengthExceeded, retryOnContextOverflow(&disabled, 100, false));
+    try std.testing.expectEqual(@as(usize, 1), disabled.calls);
+}
+
+test "isModelNotFoundMessage matches retired and missing models" {
+    try std.testing.expect(isModelNotFoundMessage("The model `mixtral-8x7b-32768` has been decommissioned and is no longer supported."));
+    try std.testing.expect(isModelNotFoundMessage("model 'llama9' not found, try pulling it first"));
+    try std.testing.expect(isModelNotFoundMessage("The model `gpt-x` does not exist or you do not have access to it."));
+    try std.testing.expect(!isModelNotFoundMessage("File not found"));
+    try std.testing.expect(!isModelNotFoundMessage("Rate limit reached for model llama-3"));
+}
+
+test "isContextLengthMessage matches provider wordings" {
+    try std.testing.expect(isContextLengthMessage("This model's maximum context length is 8192 tokens"));
+    try std.testing.expect(isContextLengthMessage("Prompt is too long"));
+    try std.testing.expect(!isContextLengthMessage("Rate limit reached"));
+}
+
+test "regenerateTemperature increases each attempt and is capped" {
+    try std.testing.expectEqual(DEFAULT_TEMPERATURE, regenerateTemperature(0));
+
+    var previous = regenerateTemperature(0);
+    for (1..4) |attempt| {
+        const current = regenerateTemperature(@intCast(attempt));
+        try std.testing.expect(current > previous);
+        previous = current;
+    }
+
+    try std.testing.expectEqual(MAX_TEMPERATURE, regenerateTemperature(100));
+}
+
+test "finalizeContent strips fences for every provider" {
+    var provider = testProvider("groq");
+    provider.vtable = try getVtable("groq");
+
+    const message = try provider.finalizeContent("```\nfeat: add login\n```");
+    defer std.testing.allocator.free(message);
+    try std.testing.expectEqualStrings("feat: add login", message);
+
+    try std.testing.expectError(LlmError.EmptyContent, provider.finalizeContent("```\n```"));
+}
+
+test "finalizeContent drops reasoning blocks" {
+    var provider = testProvider("zai");
+    provider.vtable = try getVtable("zai");
+
+    const message = try provider.finalizeContent("<think>Mostly renames.</think>\n<|begin_of_box|>refactor: move parser<|end_of_box|>");
+    defer std.testing.allocator.free(message);
+    try std.testing.expectEqualStrings("refactor: move parser", message);
+
+    try std.testing.expectError(LlmError.ReasoningOnly, provider.finalizeContent("<think>Still thinking"));
+}
+
+test "finalizeContent with raw_output skips all cleanup" {
+    var provider = testProvider("zai");
+    provider.vtable = try getVtable("zai");
+    provider.raw_output = true;
+
+    const raw = "<think>Mostly renames.</think>\n```\n<|begin_of_box|>refactor: move parser<|end_of_box|>\n```\n";
+    const message = try provider.finalizeContent(raw);
+    defer std.testing.allocator.free(message);
+    try std.testing.expectEqualStrings(raw, message);
+
+    try std.testing.expectError(LlmError.EmptyContent, provider.finalizeContent(" \n"));
+}
+
+test "StreamState collects streamed tokens" {
+    var provider = testProvider("groq");
+    provider.vtable = try getVtable("groq");
+
+    var state = StreamState.init(provider);
+    defer state.deinit();
+
+    try std.testing.expect(state.onLine("data: {\"choices\":[{\"delta\":{\"content\":\" feat: stream\"}}]}"));
+    try std.testing.expect(state.onLine("data: {\"choices\":[{\"delta\":{\"content\":\" output\\n\"}}]}"));
+    try std.testing.expect(state.onLine("data: [DONE]"));
+
+    const message = try state.finish();
+    defer std.testing.allocator.free(message);
+    try std.testing.expectEqualStrings("feat: stream output", message);
+}
+
+test "StreamState records streamed usage for the printed summary" {
+    var usage = Usage{};
+    var provider = testProvider("groq");
+    provider.vtable = try getVtable("groq");
+    provider.usage = &usage;
+
+    var state = StreamState.init(provider);
+    defer state.deinit();
+
+    try std.testing.expect(state.onLine("data: {\"choices\":[{\"delta\":{\"content\":\"feat: count tokens\"}}]}"));
+    try std.testing.expect(state.onLine("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":812,\"completion_tokens\":9,\"total_tokens\":821}}"));
+    try std.testing.expect(state.onLine("data: [DONE]"));
+
+    const message = try state.finish();
+    defer std.testing.allocator.free(message);
+    try std.testing.expect(usage.reported());
+
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+    try usage.print(out.writer());
+    try std.testing.expectEqualStrings("Tokens: 812 prompt + 9 completion = 821\n", out.items);
+}
+
+test "StreamState falls back to error mapping for non-streamed bodies" {
+    var provider = testProvider("groq");
+    provider.vtable = try getVtable("groq");
+
+    var state = StreamState.init(provider);
+    defer state.deinit();
+
+    try std.testing.expect(state.onLine("{\"error\":{\"message\":\"Invalid API key provided\"}}"));
+    try std.testing.expectError(LlmError.InvalidApiKey, state.finish());
+}
@@ -2,11 +2,44 @@ const std = @import("std");
 const cli = @import("cli.zig");
 const config = @import("config.zig");
 const git = @import("git.zig");
+const commit_msg = @import("commit_msg.zig");
 const http_client = @import("http_client.zig");
 const llm = @import("llm.zig");
+const prompt_builder = @import("prompt.zig");
+const runner = @import("runner.zig");
+const serve = @import("serve.zig");
+const keyring = @import("keyring.zig");
+const github_actions = @import("github_actions.zig");
+const pull_request = @import("pull_request.zig");
+const model_picker = @import("model_picker.zig");
+const completion = @import("completion.zig");
+const registry = @import("providers/registry.zig");
 const colors = @import("colors.zig");
 const Color = colors.Color;
 
+/// Deterministic message used for whitespace-only changes when skip_formatting_only is set
+const FORMATTING_ONLY_MESSAGE = "style: apply formatting changes";
+
+/// How far back to scan history for conventional subjects when use_repo_examples is set
+const REPO_EXAMPLE_SCAN_COUNT = 20;
+
+/// Most earlier suggestions a regenerate asks the model to steer away from
+const MAX_REGENERATE_AVOID = 5;
+
+/// Most messages --candidates generates; each one is a separate request
+const MAX_CANDIDATES = 5;
+
+/// Largest --context-file accepted; only the first MAX_EXTRA_CONTEXT_BYTES are sent
+const MAX_CONTEXT_FILE_READ = 1024 * 1024;
+
+/// Largest --diff-file/--stdin input accepted; it is condensed to max_diff_bytes before sending
+const MAX_DIFF_INPUT = 64 * 1024 * 1024;
+
+/// Shown instead of git's terse "failed to write commit object" when signing fails
+const SIGNING_FAILED_HINT =
+    "Commit signing failed. Check that your GPG or SSH agent is running and unlocked " ++
+    "(for GPG, export GPG_TTY=$(tty)) and that user.signingkey or signing_key names a usable key.";
+
 pub fn main() !void {
     var gpa = std.heap.GeneralPurposeAllocator(.{}){};
     defer _ = gpa.deinit();
@@ -35,7 +68,59 @@ pub fn main() !void {
                 return;
             },
             error.MissingProviderValue => {
-                try stderr.print("Error: --provider requires a provider name\n", .{});
+                try stderr.print("Error: --provider, config set-key, and config delete-key require a provider name\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingModelValue => {
+                try stderr.print("Error: --model requires a model name\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingProfileValue => {
+                try stderr.print("Error: --profile and 'config profile use' require a profile name\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingConfigKey => {
+                try stderr.print("Error: config get and config unset require a key, e.g. default_provider or providers.groq.model\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingCompletionShell => {
+                try stderr.print("Error: completion requires a shell (bash, zsh, fish)\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingImportUrl => {
+                try stderr.print("Error: config import requires a URL (--url <https-url>)\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingContextFileValue => {
+                try stderr.print("Error: --context-file requires a path\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingDiffFileValue => {
+                try stderr.print("Error: --diff-file requires a path (use - for stdin)\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingRangeValue => {
+                try stderr.print("Error: --range requires a revision range, e.g. main..HEAD\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingIssueValue => {
+                try stderr.print("Error: --issue requires an issue reference, e.g. 123\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingDateValue => {
+                try stderr.print("Error: --date requires a date, e.g. \"2 hours ago\"\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingCandidatesValue, error.InvalidCandidatesValue => {
+                try stderr.print("Error: --candidates requires a positive number, e.g. 3\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingContextValue, error.InvalidContextValue => {
+                try stderr.print("Error: --context requires a number of commits, e.g. 10 (0 = none)\n", .{});
+                std.process.exit(1);
+            },
+            error.MissingProxyValue => {
+                try stderr.print("Error: --proxy requires a URL, e.g. http://proxy.corp:3128\n", .{});
                 std.process.exit(1);
             },
             else => {
@@ -47,6 +132,18 @@ pub fn main() !void {
 
     defer cli.free(&args, allocator);
 
+    if (args.amend and args.batch) {
+        try stderr.print("Error: --amend cannot be combined with --batch\n", .{});
+        std.process.exit(1);
+    }
+
+    if (args.range) |range| {
+        git.validateRange(range) catch {
+            try stderr.print("Invalid range '{s}'. Use <from>..<to>, e.g. main..HEAD.\n", .{range});
+            std.process.exit(1);
+        };
+    }
+
     // Handle debug logging of flags
     if (args.debug) {
         try printDebugInfo(&args, stderr);
@@ -59,42 +156,342 @@ pub fn main() !void {
                 .edit => try config.openInEditor(allocator),
                 .show => try cli.printConfigInfo(allocator, stdout),
                 .path => try cli.printConfigPath(allocator, stdout),
+                .profile_list => try cli.printProfiles(allocator, stdout),
+                .profile_use => {
+                    const name = args.profile.?;
+                    config.useProfile(allocator, name) catch |err| {
+                        switch (err) {
+                            error.UnknownProfile => try stderr.print("Profile '{s}' is not defined in the config file.\n", .{name}),
+                            else => try stderr.print("Failed to switch profile: {s}\n", .{@errorName(err)}),
+                        }
+                        std.process.exit(1);
+                    };
+                    try stdout.print("Active profile: {s}\n", .{name});
+                },
+                .import => try runConfigImport(allocator, args.import_url.?, stdout, stderr),
+                .get => try runConfigGet(allocator, args.config_key.?, args.profile, stdout, stderr),
+                .unset => try runConfigUnset(allocator, args.config_key.?, args.auto_accept, stdout, stderr),
+                .set_key => try runSetKey(allocator, args.provider.?, stdout, stderr),
+                .delete_key => try runDeleteKey(allocator, args.provider.?, stdout, stderr),
+                .reset_prompt => try runResetPrompt(allocator, args.auto_accept, stdout, stderr),
                 .unknown => {
-                    try stderr.print("Unknown config subcommand\nUsage: autocommit config [show|path]\n", .{});
+                    try stderr.print("Unknown config subcommand\nUsage: autocommit config [show|path|profile [use <name>]|import --url <url>]\n", .{});
                     std.process.exit(1);
                 },
             }
             return;
         },
-        .main => {
-            // Continue to main commit generation logic
+        .undo => {
+            try runUndo(allocator, args.auto_accept, stdout, stderr);
+            return;
+        },
+        .completion => {
+            try runCompletion(allocator, args.completion_target.?, stdout, stderr);
+            return;
+        },
+        .main, .serve => {
+            // Continue to provider setup and commit generation logic
         },
     }
 
-    if (!git.isRepo()) {
+    const git_version = git.checkVersion(allocator) catch |err| {
+        switch (err) {
+            error.GitNotFound => try stderr.print("git was not found on PATH. Install git and try again.\n", .{}),
+            error.GitTooOld => try stderr.print("git is too old. autocommit requires git {d}.{d} or newer.\n", .{ git.MIN_GIT_VERSION.major, git.MIN_GIT_VERSION.minor }),
+            else => try stderr.print("Failed to run 'git --version'. Check your git installation.\n", .{}),
+        }
+        std.process.exit(1);
+    };
+
+    if (args.debug) {
+        try colors.debug(stderr, "git_version={d}.{d}.{d}\n", .{ git_version.major, git_version.minor, git_version.patch });
+    }
+
+    // A supplied diff doesn't need a repository; recent commits are just left out
+    if (args.command == .main and args.diff_file == null and !git.isRepo()) {
         try stderr.print("Not a git repository. Run 'git init' first.\n", .{});
         std.process.exit(1);
     }
 
-    const cfg = config.load(allocator) catch |err| {
-        try stderr.print("Failed to load config: {s}. Run 'autocommit config' to create one.\n", .{@errorName(err)});
+    var cfg = config.loadWithProfile(allocator, args.profile) catch |err| {
+        switch (err) {
+            error.UnknownProfile => if (args.profile) |name| {
+                try stderr.print("Profile '{s}' is not defined in the config file.\n", .{name});
+            } else {
+                try stderr.print("The config file's active_profile is not defined under [[profiles]].\n", .{});
+            },
+            else => try printConfigLoadError(allocator, err, stderr),
+        }
         std.process.exit(1);
     };
     defer cfg.deinit(allocator);
 
+    if (args.explain_config) {
+        try printConfigExplanation(allocator, &cfg, &args, stdout);
+        return;
+    }
+
+    if (args.proxy) |url| try cfg.overrideProxy(allocator, url);
+
+    // A fresh or emptied config would otherwise fail below with "provider '' not configured"
+    if (!cfg.hasAnyProvider()) {
+        try stderr.print("{s}\n", .{config.NO_PROVIDERS_HINT});
+        std.process.exit(1);
+    }
+
     const provider_name = args.provider orelse cfg.default_provider;
+    if (provider_name.len == 0) {
+        try stderr.print("No default provider configured. Set one with 'autocommit config' or pass --provider <name>.\n", .{});
+        std.process.exit(1);
+    }
 
     const provider_cfg = cfg.getProvider(provider_name) catch |err| {
         switch (err) {
             error.UnknownProvider => {
-                try stderr.print("Unknown provider: {s}\n", .{provider_name});
+                try stderr.print("Provider '{s}' is not configured. Configured providers:", .{provider_name});
+                for (cfg.providers) |configured| {
+                    try stderr.print(" {s}", .{configured.name});
+                }
+                try stderr.print("\n", .{});
                 std.process.exit(1);
             },
         }
     };
 
+    // --model overrides the configured model for this run only
+    const model = args.model orelse provider_cfg.model;
+
+    if (provider_cfg.missingAzureResource()) {
+        try stderr.print("Provider {s} needs azure_resource, the <resource> in https://<resource>.openai.azure.com. Set it in your config (autocommit config).\n", .{provider_name});
+        std.process.exit(1);
+    }
+
     if (args.debug) {
-        try colors.debug(stderr, "provider={s}, model={s}\n", .{ provider_name, provider_cfg.model });
+        try colors.debug(stderr, "provider={s}, model={s}\n", .{ provider_name, model });
+    }
+
+    // The key is resolved only once a path is about to call the LLM, so a run that stops early
+    // (nothing staged, an empty diff) never reads key files or runs an api_key_command
+    const setup = ProviderSetup{
+        .allocator = allocator,
+        .cfg = &cfg,
+        .args = &args,
+        .name = provider_name,
+        .provider_cfg = provider_cfg,
+        .model = model,
+        .debug_log = if (args.debug) debug_log else null,
+        .debug_ctx = if (args.debug) @ptrCast(@constCast(&stderr_file)) else null,
+    };
+
+    // Workflow commands go to stderr so captured stdout stays just the message
+    const annotate = args.github_annotation or github_actions.isActions(allocator);
+
+    const extra_context = if (args.context_file) |path|
+        std.fs.cwd().readFileAlloc(allocator, path, MAX_CONTEXT_FILE_READ) catch |err| {
+            try stderr.print("Failed to read context file {s}: {s}\n", .{ path, @errorName(err) });
+            std.process.exit(1);
+        }
+    else
+        try allocator.dupe(u8, "");
+    defer allocator.free(extra_context);
+
+    // A --diff-file diff need not belong to this branch
+    const pr_context = if (cfg.use_pr_context and args.diff_file == null) pullRequestContext(allocator, &cfg, git_version, args.debug, stderr) else null;
+    defer if (pr_context) |text| allocator.free(text);
+
+    const context_format = cfg.contextFormat();
+    const recent_context: usize = args.context_commits orelse cfg.recent_commits;
+    // Fetched even with no context: the duplicate-subject check compares against them
+    const scan_count: usize = if (cfg.use_repo_examples) REPO_EXAMPLE_SCAN_COUNT else prompt_builder.RECENT_COMMITS_CONTEXT;
+    const recent_count = @max(recent_context, scan_count);
+    var recent_commits = git.getRecentCommitsCached(allocator, recent_count, context_format == .full, cfg.recent_commits_cache_seconds) catch git.RecentCommits.empty(allocator);
+    defer recent_commits.deinit();
+    const context_count = if (provider_cfg.include_recent_commits) @min(recent_commits.commits.len, recent_context) else 0;
+    const context_commits = recent_commits.commits[0..context_count];
+
+    const repo_examples = try prompt_builder.selectRepoExamples(allocator, if (cfg.use_repo_examples) recent_commits.commits else &.{});
+    defer allocator.free(repo_examples);
+
+    const system_prompt = try prompt_builder.buildSystemPrompt(allocator, &cfg, .{ .repo_examples = repo_examples });
+    defer allocator.free(system_prompt);
+
+    // One process, provider, and HTTP client answer every request from an editor backend
+    if (args.command == .serve) {
+        const session = try setup.start(stderr);
+        defer session.destroy();
+
+        var generator = serve.ProviderGenerator{
+            .allocator = allocator,
+            .provider = session.provider,
+            .system_prompt = system_prompt,
+            .max_diff_bytes = cfg.max_diff_bytes,
+            .include_recent_commits = provider_cfg.include_recent_commits,
+        };
+        var stdin_buffer = std.io.bufferedReader(std.io.getStdIn().reader());
+        try serve.run(allocator, &generator, stdin_buffer.reader(), stdout);
+        return;
+    }
+
+    if (args.preview) {
+        // Starts the provider only once there are unstaged changes to describe
+        const Generator = struct {
+            allocator: std.mem.Allocator,
+            setup: *const ProviderSetup,
+            context: prompt_builder.Context,
+            system_prompt: []const u8,
+            max_diff_bytes: usize,
+            debug: bool,
+            stderr: @TypeOf(stderr),
+
+            pub fn message(self: @This(), diff: []const u8) ![]const u8 {
+                const session = try self.setup.start(self.stderr);
+                defer session.destroy();
+                return generateOrExit(self.allocator, session.provider, diff, self.context, self.system_prompt, self.max_diff_bytes, self.debug, self.stderr);
+            }
+        };
+
+        const maybe_message = previewMessage(allocator, Generator{
+            .allocator = allocator,
+            .setup = &setup,
+            .context = .{ .recent_commits = context_commits, .context_format = context_format, .extra_context = extra_context, .pr_context = pr_context orelse "" },
+            .system_prompt = system_prompt,
+            .max_diff_bytes = cfg.max_diff_bytes,
+            .debug = args.debug,
+            .stderr = stderr,
+        }) catch |err| switch (err) {
+            error.UnstagedDiffFailed => {
+                try stderr.print("Failed to get unstaged diff\n", .{});
+                std.process.exit(1);
+            },
+            else => |other| return other,
+        };
+        const preview_message = maybe_message orelse {
+            try stdout.print("No unstaged changes to preview.\n", .{});
+            return;
+        };
+        defer allocator.free(preview_message);
+
+        const printed = commit_msg.printedMessage(preview_message, args.first_line_only);
+        try stdout.print("\n{s}Preview commit message (nothing staged or committed):{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, printed, Color.reset });
+        if (annotate) try github_actions.writeNotice(stderr, github_actions.NOTICE_TITLE, printed);
+        return;
+    }
+
+    // A precomputed diff skips the index entirely; like --dry-run, only the message is printed
+    if (args.diff_file) |path| {
+        const input_diff = readDiffInput(allocator, path) catch |err| {
+            try stderr.print("Failed to read diff from {s}: {s}\n", .{ if (std.mem.eql(u8, path, "-")) "stdin" else path, @errorName(err) });
+            std.process.exit(1);
+        };
+        defer allocator.free(input_diff);
+
+        if (std.mem.trim(u8, input_diff, " \n\r\t").len == 0) {
+            try stderr.print("The supplied diff is empty.\n", .{});
+            std.process.exit(1);
+        }
+
+        const session = try setup.start(stderr);
+        defer session.destroy();
+
+        const message = try generateOrExit(allocator, session.provider, input_diff, .{
+            .recent_commits = context_commits,
+            .context_format = context_format,
+            .extra_context = extra_context,
+        }, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+        defer allocator.free(message);
+
+        const printed = commit_msg.printedMessage(message, args.first_line_only);
+        try stdout.print("{s}\n", .{printed});
+        if (annotate) try github_actions.writeNotice(stderr, github_actions.NOTICE_TITLE, printed);
+        return;
+    }
+
+    // A range is summarized as one message, e.g. to reword commits before squashing them
+    if (args.range) |range| {
+        const range_diff = git.getRangeDiff(allocator, range) catch {
+            try stderr.print("Failed to get the diff for range {s}\n", .{range});
+            std.process.exit(1);
+        };
+        defer allocator.free(range_diff);
+
+        if (std.mem.trim(u8, range_diff, " \n\r\t").len == 0) {
+            try stderr.print("The range {s} has no changes.\n", .{range});
+            std.process.exit(1);
+        }
+
+        var range_commits = git.getRangeCommits(allocator, range) catch git.RecentCommits.empty(allocator);
+        defer range_commits.deinit();
+
+        const session = try setup.start(stderr);
+        defer session.destroy();
+
+        const message = try generateOrExit(allocator, session.provider, range_diff, .{
+            .recent_commits = context_commits,
+            .context_format = context_format,
+            .range_commits = range_commits.commits,
+            .extra_context = extra_context,
+            .pr_context = pr_context orelse "",
+        }, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+        defer allocator.free(message);
+
+        const printed = commit_msg.printedMessage(message, args.first_line_only);
+        try stdout.print("{s}\n", .{printed});
+        if (annotate) try github_actions.writeNotice(stderr, github_actions.NOTICE_TITLE, printed);
+        return;
+    }
+
+    // Only the message goes to stdout so scripts can capture it: msg=$(autocommit --dry-run)
+    if (args.dry_run) {
+        if (args.auto_add) {
+            git.addAll(allocator) catch {
+                try stderr.print("Failed to add files\n", .{});
+                std.process.exit(1);
+            };
+        }
+
+        const staged_diff = try git.getStagedDiff(allocator);
+        defer allocator.free(staged_diff);
+
+        if (staged_diff.len == 0) {
+            try stderr.print("No staged changes to commit.\n", .{});
+            std.process.exit(1);
+        }
+
+        var dry_run_renames = git.getRenames(allocator) catch {
+            try stderr.print("Failed to detect renamed files\n", .{});
+            std.process.exit(1);
+        };
+        defer dry_run_renames.deinit();
+
+        const dry_run_stat = stagedDiffStat(allocator, &cfg, staged_diff);
+        defer if (dry_run_stat) |stat| allocator.free(stat);
+
+        const session = try setup.start(stderr);
+        defer session.destroy();
+
+        const message = try generateOrExit(allocator, session.provider, staged_diff, .{
+            .diff_stat = dry_run_stat orelse "",
+            .renames = &dry_run_renames,
+            .recent_commits = context_commits,
+            .context_format = context_format,
+            .extra_context = extra_context,
+            .pr_context = pr_context orelse "",
+        }, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+        defer allocator.free(message);
+
+        const templated_message = try applySubjectTemplate(allocator, cfg.subject_template, message);
+        defer allocator.free(templated_message);
+
+        const printed = commit_msg.printedMessage(templated_message, args.first_line_only);
+        try stdout.print("{s}\n", .{printed});
+        if (annotate) try github_actions.writeNotice(stderr, github_actions.NOTICE_TITLE, printed);
+        return;
+    }
+
+    // Prompts would wait on a pipe that may never deliver input (e.g. in CI)
+    if (!args.auto_accept and !std.io.getStdIn().isTty()) {
+        try stderr.print("stdin is not a terminal, so autocommit can't ask for confirmation. Pass --yes (-y) to commit without prompting, or --dry-run to only print the message.\n", .{});
+        std.process.exit(1);
     }
 
     try stdout.print("\n", .{});
@@ -110,7 +507,8 @@ pub fn main() !void {
         std.process.exit(1);
     };
 
-    if (!has_changes) {
+    // Amending can rewrite just the message, so no new changes are needed
+    if (!has_changes and !args.amend) {
         std.process.exit(0);
     }
 
@@ -149,80 +547,366 @@ pub fn main() !void {
         }
     }
 
-    if (status.stagedCount() == 0) {
+    if (status.stagedCount() == 0 and !args.amend) {
         try stdout.print("\nNo staged changes to commit.\n", .{});
         std.process.exit(0);
     }
 
-    var http = http_client.HttpClient.init(allocator);
-    defer http.deinit();
+    const amend_message = if (args.amend)
+        git.getCommitMessage(allocator, "HEAD") catch {
+            try stderr.print("Nothing to amend: the repository has no commits yet.\n", .{});
+            std.process.exit(1);
+        }
+    else
+        try allocator.dupe(u8, "");
+    defer allocator.free(amend_message);
+
+    if (args.amend and (git.isCommitPushed(allocator, "HEAD") catch false)) {
+        try stderr.print("{s}Warning: HEAD is already on a remote branch; amending it rewrites published history.{s}\n", .{ Color.yellow, Color.reset });
+    }
 
-    var provider = llm.createProvider(
-        allocator,
-        provider_name,
-        provider_cfg.*,
-        &http,
-        if (args.debug) debug_log else null,
-        if (args.debug) @ptrCast(@constCast(&stderr_file)) else null,
+    if (cfg.confirm_lines_threshold > 0 and !args.auto_accept) {
+        const stat = git.getShortStat(allocator) catch git.ShortStat{};
+        if (stat.totalLines() > cfg.confirm_lines_threshold) {
+            try stderr.print("\n{s}Warning: staged changes touch {d} lines across {d} file(s) (threshold {d}). Consider smaller commits.{s}\n", .{ Color.yellow, stat.totalLines(), stat.files, cfg.confirm_lines_threshold, Color.reset });
+            const should_continue = try confirmYesNo(stdout, stderr, "Continue anyway?", false);
+            if (!should_continue) {
+                try stdout.print("\n{s}Aborted, no commit made.{s}\n", .{ Color.yellow, Color.reset });
+                std.process.exit(0);
+            }
+        }
+    }
+
+    const check = runner.runPreCommitCheck(
+        runner.ShellRunner{ .allocator = allocator, .timeout_ms = @as(u64, cfg.pre_commit_timeout_seconds) * std.time.ms_per_s },
+        cfg.pre_commit_command,
+        args.skip_checks,
     ) catch |err| {
-        try stderr.print("Failed to create provider: {s}\n", .{@errorName(err)});
+        try stderr.print("Failed to run pre-commit command '{s}': {s}\n", .{ cfg.pre_commit_command, @errorName(err) });
         std.process.exit(1);
     };
-    defer llm.destroyProvider(&provider, allocator);
+    switch (check) {
+        .not_configured => {},
+        .skipped => try stderr.print("{s}Skipping pre-commit check (--skip-checks){s}\n", .{ Color.yellow, Color.reset }),
+        .passed => try stdout.print("{s}Pre-commit check passed: {s}{s}\n", .{ Color.green, cfg.pre_commit_command, Color.reset }),
+        .failed => |result| {
+            defer result.deinit();
+            try stderr.print("\n{s}", .{result.output});
+            if (result.timed_out) {
+                try stderr.print("{s}Pre-commit check timed out after {d}s: {s}{s}\n", .{ Color.red, cfg.pre_commit_timeout_seconds, cfg.pre_commit_command, Color.reset });
+            } else {
+                try stderr.print("{s}Pre-commit check failed: {s}{s}\n", .{ Color.red, cfg.pre_commit_command, Color.reset });
+            }
+            try stderr.print("Aborted, no commit made. Use --skip-checks to commit anyway.\n", .{});
+            std.process.exit(1);
+        },
+    }
+
+    const cleanup: ?git.CleanupMode = if (cfg.cleanup.len == 0) null else std.meta.stringToEnum(git.CleanupMode, cfg.cleanup) orelse blk: {
+        try stderr.print("{s}Warning: unknown cleanup mode '{s}', using git's default{s}\n", .{ Color.yellow, cfg.cleanup, Color.reset });
+        break :blk null;
+    };
+
+    // Appended by us rather than the model so they are always well-formed
+    const trailers = commit_msg.Trailers{ .co_authors = cfg.co_authors, .issue = args.issue };
+
+    const sign_flag = if (args.sign or cfg.sign_commits) try git.signFlag(allocator, cfg.signing_key) else null;
+    defer if (sign_flag) |flag| allocator.free(flag);
+
+    const date_flag = try commitDateFlag(allocator, args.date, cfg.commit_timezone, stderr);
+    defer if (date_flag) |flag| allocator.free(flag);
+
+    const commit_options = git.CommitOptions{ .amend = args.amend, .no_verify = args.no_verify, .cleanup = cleanup, .gpg_sign = sign_flag, .date = date_flag };
+
+    const session = try setup.start(stderr);
+    defer session.destroy();
+    const provider = &session.provider;
+
+    if (args.batch) {
+        runBatch(allocator, provider.*, &status, .{
+            .recent_commits = context_commits,
+            .context_format = context_format,
+            .extra_context = extra_context,
+            .pr_context = pr_context orelse "",
+        }, system_prompt, cfg.max_diff_bytes, commit_options, trailers, cfg.subject_template, &args, stdout, stderr) catch |err| {
+            if (err == error.SigningFailed) try stderr.print("{s}\n", .{SIGNING_FAILED_HINT});
+            // A generation failure has already said why
+            if (err != error.GenerationFailed) try stderr.print("Batch commit failed: {s}\n", .{@errorName(err)});
+            try stderr.print("Groups committed so far are kept; the rest of your staged changes are staged as before.\n", .{});
+            std.process.exit(1);
+        };
+        return;
+    }
+
+    // Taken before generation so a commit can tell if the index changed meanwhile (e.g. a concurrent `git add`)
+    var staged_tree: ?[]const u8 = git.stagedTreeHash(allocator) catch null;
+    defer if (staged_tree) |tree| allocator.free(tree);
 
-    const diff = try git.getStagedDiff(allocator);
+    var diff = if (args.amend) try git.getAmendDiff(allocator) else try git.getStagedDiff(allocator);
     defer allocator.free(diff);
 
+    var renames = git.getRenames(allocator) catch {
+        try stderr.print("Failed to detect renamed files\n", .{});
+        std.process.exit(1);
+    };
+    defer renames.deinit();
+
+    if (args.debug and renames.entries.len > 0) {
+        try colors.debug(stderr, "renames={d}, rename_dominated={}\n", .{ renames.entries.len, renames.isRenameDominated() });
+    }
+
+    const formatting_only = !args.amend and cfg.skip_formatting_only and (git.isWhitespaceOnlyChange(allocator, diff) catch false);
     if (args.debug) {
-        try stdout.print("\n", .{});
-        try colors.debug(stderr, "Diff size: {d} bytes\n", .{diff.len});
+        try colors.debug(stderr, "formatting_only={}\n", .{formatting_only});
     }
 
-    const max_diff_size = 100 * 1024;
-    const truncated_diff = try git.truncateDiff(allocator, diff, max_diff_size);
-    defer allocator.free(truncated_diff);
+    // The --stat summary describes the index, which is not the amended commit's diff
+    var diff_stat = if (args.amend) null else stagedDiffStat(allocator, &cfg, diff);
+    defer if (diff_stat) |stat| allocator.free(stat);
 
-    // Generate commit message (debug logging handled internally by llm module when debug is enabled)
-    const commit_message = provider.generateCommitMessage(truncated_diff, cfg.system_prompt) catch |err| {
-        const error_message = switch (err) {
-            llm.LlmError.InvalidApiKey => "Invalid API key. Check your config file.",
-            llm.LlmError.RateLimited => "Rate limit exceeded. Please try again later.",
-            llm.LlmError.ServerError => "Server error. Please try again later.",
-            llm.LlmError.Timeout => "Request timed out. Check your internet connection.",
-            llm.LlmError.InvalidResponse => "Invalid response from API.",
-            llm.LlmError.EmptyContent => "LLM returned empty message.",
-            llm.LlmError.ApiError => "API error occurred.",
-            llm.LlmError.OutOfMemory => "Out of memory.",
-        };
-        try stderr.print("Error: {s}\n", .{error_message});
-        std.process.exit(1);
+    var generation_context = prompt_builder.Context{
+        .diff_stat = diff_stat orelse "",
+        .renames = &renames,
+        .recent_commits = context_commits,
+        .context_format = context_format,
+        .extra_context = extra_context,
+        .pr_context = pr_context orelse "",
+        .amend_message = amend_message,
     };
 
-    try stdout.print("\n{s}Generated commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
+    var commit_message = if (formatting_only)
+        try allocator.dupe(u8, FORMATTING_ONLY_MESSAGE)
+    else
+        try generateOrExit(allocator, provider.*, diff, generation_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+
+    // The commit being amended is the newest recent commit; keeping its subject is fine
+    const duplicate_candidates = if (args.amend and recent_commits.commits.len > 0) recent_commits.commits[1..] else recent_commits.commits;
+    if (cfg.reject_duplicate_subject and !formatting_only and prompt_builder.isDuplicateSubject(commit_message, duplicate_candidates)) {
+        try stderr.print("{s}Generated subject duplicates a recent commit, regenerating...{s}\n", .{ Color.yellow, Color.reset });
+
+        const duplicate_subjects = [_][]const u8{commit_msg.subjectLine(commit_message)};
+        var retry_context = generation_context;
+        retry_context.avoid_subjects = &duplicate_subjects;
+
+        const regenerated = try generateOrExit(allocator, provider.*, diff, retry_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+        allocator.free(commit_message);
+        commit_message = regenerated;
+    }
+
+    if (cfg.validate_conventional and !formatting_only) {
+        const max_subject = if (cfg.max_subject_length > 0) cfg.max_subject_length else commit_msg.DEFAULT_MAX_SUBJECT_LENGTH;
+        if (commit_msg.validateConventional(commit_message, &commit_msg.DEFAULT_TYPES, max_subject)) |_| {} else |validation_err| {
+            const reason = commit_msg.describeValidationError(validation_err);
+            try stderr.print("{s}Generated message is not a valid conventional commit ({s}), regenerating...{s}\n", .{ Color.yellow, reason, Color.reset });
+
+            var strict_context = generation_context;
+            strict_context.format_feedback = reason;
+
+            const regenerated = try generateOrExit(allocator, provider.*, diff, strict_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+            allocator.free(commit_message);
+            commit_message = regenerated;
+
+            if (commit_msg.validateConventional(commit_message, &commit_msg.DEFAULT_TYPES, max_subject)) |_| {} else |retry_err| {
+                try stderr.print("{s}Warning: message is still not a valid conventional commit ({s}). Review it before committing.{s}\n", .{ Color.yellow, commit_msg.describeValidationError(retry_err), Color.reset });
+            }
+        }
+    }
+
+    // The imperative check only knows English verb endings
+    if (cfg.enforce_imperative and !formatting_only and prompt_builder.languageName(cfg.language) == null) {
+        if (commit_msg.nonImperativeVerb(commit_msg.subjectLine(commit_message))) |verb| {
+            try stderr.print("{s}Subject starts with \"{s}\", which is not the imperative mood, regenerating...{s}\n", .{ Color.yellow, verb, Color.reset });
+
+            var mood_context = generation_context;
+            mood_context.mood_feedback = verb;
+
+            const regenerated = try generateOrExit(allocator, provider.*, diff, mood_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+            allocator.free(commit_message);
+            commit_message = regenerated;
+
+            if (commit_msg.nonImperativeVerb(commit_msg.subjectLine(commit_message))) |retry_verb| {
+                try stderr.print("{s}Warning: subject still starts with \"{s}\". Consider editing it to the imperative mood (e.g. \"add\" rather than \"added\").{s}\n", .{ Color.yellow, retry_verb, Color.reset });
+            }
+        }
+    }
+
+    if (!formatting_only) {
+        const linter = runner.ShellRunner{ .allocator = allocator, .timeout_ms = @as(u64, cfg.pre_commit_timeout_seconds) * std.time.ms_per_s };
+        const lint = runner.runLinter(linter, cfg.linter_command, commit_message) catch |err| {
+            try stderr.print("Failed to run linter_command: {s}\n", .{@errorName(err)});
+            std.process.exit(1);
+        };
+        switch (lint) {
+            .failed => |result| {
+                defer result.deinit();
+                const reason = if (result.timed_out) " (timed out)" else "";
+                try stderr.print("{s}Linter rejected \"{s}\"{s}:{s}\n{s}\n", .{ Color.yellow, commit_msg.subjectLine(commit_message), reason, Color.reset, result.output });
+
+                const regenerate = args.auto_accept or try confirmYesNo(stdout, stderr, "\nRegenerate with the linter's feedback?", true);
+                if (regenerate) {
+                    var lint_context = generation_context;
+                    lint_context.lint_feedback = result.output;
+
+                    const regenerated = try generateOrExit(allocator, provider.*, diff, lint_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+                    allocator.free(commit_message);
+                    commit_message = regenerated;
+
+                    const recheck = runner.runLinter(linter, cfg.linter_command, commit_message) catch null;
+                    if (recheck) |outcome| switch (outcome) {
+                        .failed => |retry_result| {
+                            defer retry_result.deinit();
+                            try stderr.print("{s}Warning: the regenerated message still fails the linter. Review it before committing.{s}\n", .{ Color.yellow, Color.reset });
+                        },
+                        else => {},
+                    };
+                }
+            },
+            else => {},
+        }
+    }
+
+    const candidate_count = @min(args.candidates orelse cfg.candidates, MAX_CANDIDATES);
+    if (candidate_count > 1 and !args.auto_accept and !formatting_only) {
+        commit_message = try chooseCandidate(allocator, provider, diff, generation_context, system_prompt, commit_message, candidate_count, cfg.max_diff_bytes, args.debug, stdout, stderr);
+        try stdout.print("\n{s}Commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
+    } else {
+        try stdout.print("\n{s}Generated commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
+    }
 
     if (!args.auto_accept) {
-        var commit_prompt_buf: [64]u8 = undefined;
-        const commit_prompt = try std.fmt.bufPrint(&commit_prompt_buf, "\n{s}Proceed with commit?{s}", .{ Color.bold, Color.reset });
-        const should_commit = try confirmYesNo(stdout, stderr, commit_prompt, false);
-        if (!should_commit) {
+        var regenerate_count: u32 = 0;
+        // Subjects already shown, so a regenerate asks for a different phrasing
+        var shown_subjects = std.ArrayList([]const u8).init(allocator);
+        defer {
+            for (shown_subjects.items) |subject| allocator.free(subject);
+            shown_subjects.deinit();
+        }
+        while (true) {
+            const action = try promptCommitAction(stdout, stderr);
+            if (action == .commit) {
+                if (!args.allow_secrets and try reportSecret(stderr, commit_message)) continue;
+                if (!try stagedChangesMoved(allocator, staged_tree, stderr)) break;
+                if (!try confirmYesNo(stdout, stderr, "Regenerate from the current staged changes? (n commits this message anyway)", true)) break;
+
+                // Describe what will actually be committed now
+                allocator.free(staged_tree.?);
+                staged_tree = git.stagedTreeHash(allocator) catch null;
+                allocator.free(diff);
+                diff = if (args.amend) try git.getAmendDiff(allocator) else try git.getStagedDiff(allocator);
+                if (diff_stat) |stat| allocator.free(stat);
+                diff_stat = if (args.amend) null else stagedDiffStat(allocator, &cfg, diff);
+                generation_context.diff_stat = diff_stat orelse "";
+                renames.deinit();
+                renames = git.getRenames(allocator) catch {
+                    try stderr.print("Failed to detect renamed files\n", .{});
+                    std.process.exit(1);
+                };
+
+                const regenerated = try generateOrExit(allocator, provider.*, diff, generation_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
+                allocator.free(commit_message);
+                commit_message = regenerated;
+
+                try stdout.print("\n{s}Generated commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
+                continue;
+            }
+            if (action == .cancel) {
+                allocator.free(commit_message);
+                try stdout.print("\n{s}Aborted, no commit made.{s}\n", .{ Color.yellow, Color.reset });
+                std.process.exit(0);
+            }
+            if (action == .edit) {
+                const edited = try editMessage(allocator, commit_message, stdout, stderr);
+                if (edited) |message| {
+                    allocator.free(commit_message);
+                    commit_message = message;
+                }
+
+                try stdout.print("\n{s}Commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
+                continue;
+            }
+
+            if (action == .switch_model) {
+                if (!try switchModel(allocator, &cfg, provider, &session.switched, stdout, stderr)) continue;
+                // A different model starts again from the default temperature
+                regenerate_count = 0;
+                provider.temperature = llm.DEFAULT_TEMPERATURE;
+            } else {
+                // Each regenerate explores a little more so attempts don't come back near-identical
+                regenerate_count += 1;
+                provider.temperature = llm.regenerateTemperature(regenerate_count);
+                if (args.debug) {
+                    try colors.debug(stderr, "regenerate attempt={d}, temperature={d:.2}\n", .{ regenerate_count, provider.temperature });
+                }
+            }
+
+            const shown = try allocator.dupe(u8, commit_msg.subjectLine(commit_message));
+            shown_subjects.append(shown) catch |err| {
+                allocator.free(shown);
+                return err;
+            };
+            var regenerate_context = generation_context;
+            regenerate_context.avoid_subjects = shown_subjects.items[shown_subjects.items.len -| MAX_REGENERATE_AVOID..];
+
+            const regenerated = try generateOrExit(allocator, provider.*, diff, regenerate_context, system_prompt, cfg.max_diff_bytes, args.debug, stderr);
             allocator.free(commit_message);
-            try stdout.print("\n{s}Aborted, no commit made.{s}\n", .{ Color.yellow, Color.reset });
-            std.process.exit(0);
+            commit_message = regenerated;
+
+            try stdout.print("\n{s}Generated commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, commit_message, Color.reset });
         }
+        provider.temperature = llm.DEFAULT_TEMPERATURE;
     } else {
+        if (!args.allow_secrets and try reportSecret(stderr, commit_message)) std.process.exit(1);
+        if (try stagedChangesMoved(allocator, staged_tree, stderr)) {
+            try stderr.print("Aborted, no commit made. Run autocommit again to describe the current changes.\n", .{});
+            std.process.exit(1);
+        }
         try stdout.print("\n{s}Auto-accept enabled, committing...{s}\n", .{ Color.yellow, Color.reset });
     }
 
-    try stdout.print("\n{s}Committing...{s}\n", .{ Color.green, Color.reset });
-    try git.commit(allocator, commit_message);
+    // The staged --shortstat doesn't describe an amended commit, so the template is skipped there
+    const templated_message = if (args.amend) try allocator.dupe(u8, commit_message) else try applySubjectTemplate(allocator, cfg.subject_template, commit_message);
+    defer allocator.free(templated_message);
+
+    const final_message = try commit_msg.appendTrailers(allocator, templated_message, trailers);
+    defer allocator.free(final_message);
+
+    const encoded_message = try encodeForRepo(allocator, final_message, args.debug, stderr);
+    defer allocator.free(encoded_message);
+
+    try stdout.print("\n{s}{s}...{s}\n", .{ Color.green, if (args.amend) "Amending" else "Committing", Color.reset });
+    git.commit(allocator, encoded_message, commit_options) catch |err| {
+        switch (err) {
+            error.SigningFailed => try stderr.print("{s}\n", .{SIGNING_FAILED_HINT}),
+            error.EmptyMessage => try stderr.print("Commit failed: the commit message is empty.\n", .{}),
+            else => try stderr.print("Commit failed: {s}\n", .{@errorName(err)}),
+        }
+        std.process.exit(1);
+    };
     try stdout.print("{s}Committed successfully!{s}\n", .{ Color.green, Color.reset });
 
-    var should_push = args.auto_push;
+    // Guard against hooks or message cleanup silently rewriting the subject
+    if (git.getCommitSubject(allocator, "HEAD")) |committed_subject| {
+        defer allocator.free(committed_subject);
+        if (!git.subjectMatches(encoded_message, committed_subject)) {
+            try stderr.print("{s}Warning: committed subject differs from generated message:{s} {s}\n", .{ Color.yellow, Color.reset, committed_subject });
+        }
+    } else |_| {}
+
+    if (cfg.record_notes) {
+        recordNote(allocator, provider.name, provider.config.model, formatting_only) catch |err| {
+            if (args.debug) {
+                try colors.debug(stderr, "Failed to record git note: {s}\n", .{@errorName(err)});
+            }
+        };
+    }
+
+    var should_push = args.auto_push or cfg.auto_push;
     if (args.debug) {
-        try colors.debug(stderr, "auto_push flag={}, should_push={}\n", .{ args.auto_push, should_push });
+        try colors.debug(stderr, "auto_push flag={}, config={}, should_push={}\n", .{ args.auto_push, cfg.auto_push, should_push });
     }
 
-    if (!should_push) {
+    // --accept is a one-shot run: it pushes only when asked to (--push or auto_push)
+    if (!should_push and !args.auto_accept) {
         var push_prompt_buf: [64]u8 = undefined;
         const push_prompt = try std.fmt.bufPrint(&push_prompt_buf, "\n{s}Push to remote?{s}", .{ Color.bold, Color.reset });
         should_push = try confirmYesNo(stdout, stderr, push_prompt, true);
@@ -234,9 +918,14 @@ pub fn main() !void {
         try stdout.print("{s}Pushing...{s}\n", .{ Color.green, Color.reset });
         if (git.push(allocator)) {
             try stdout.print("{s}Pushed successfully!{s}\n", .{ Color.green, Color.reset });
-        } else |err| {
-            try stderr.print("{s}Warning: Push failed: {s}{s}\n", .{ Color.yellow, @errorName(err), Color.reset });
+        } else |err| switch (err) {
             // Don't exit - commit succeeded, just push failed
+            error.NoUpstream => {
+                const branch = git.getCurrentBranch(allocator, git_version) catch try allocator.dupe(u8, "<branch>");
+                defer allocator.free(branch);
+                try stderr.print("{s}Warning: Push failed: the current branch has no upstream. Run 'git push -u origin {s}'.{s}\n", .{ Color.yellow, branch, Color.reset });
+            },
+            else => try stderr.print("{s}Warning: Push failed: {s}{s}\n", .{ Color.yellow, @errorName(err), Color.reset }),
         }
     } else if (args.debug) {
         try colors.debug(stderr, "Push skipped\n", .{});
@@ -245,33 +934,1210 @@ pub fn main() !void {
     allocator.free(commit_message);
 }
 
+const FakePreviewGenerator = struct {
+    allocator: std.mem.Allocator,
+    seen: *bool,
+
+    pub fn message(self: FakePreviewGenerator, diff: []const u8) ![]const u8 {
+        self.seen.* = std.mem.indexOf(u8, diff, "+hello again") != null;
+        return self.allocator.dupe(u8, "docs: update notes");
+    }
+};
+
+test "previewMessage leaves the index and HEAD unchanged" {
+    var tmp = std.testing.tmpDir(.{});
+    defer tmp.cleanup();
+    var original_cwd = try std.fs.cwd().openDir(".", .{});
+    defer original_cwd.close();
+    try tmp.dir.setAsCwd();
+    defer original_cwd.setAsCwd() catch {};
+
+    const setup = [_][]const []const u8{
+        &.{ "git", "init", "-q" },
+        &.{ "git", "config", "user.email", "test@example.com" },
+        &.{ "git", "config", "user.name", "Test" },
+        &.{ "git", "config", "commit.gpgsign", "false" },
+    };
+    for (setup) |argv| {
+        const result = try std.process.Child.run(.{ .allocator = std.testing.allocator, .argv = argv });
+        std.testing.allocator.free(result.stdout);
+        std.testing.allocator.free(result.stderr);
+    }
+    try tmp.dir.writeFile(.{ .sub_path = "notes.txt", .data = "hello\n" });
+    try git.addAll(std.testing.allocator);
+    try git.commit(std.testing.allocator, "docs: add notes", .{});
+    try tmp.dir.writeFile(.{ .sub_path = "notes.txt", .data = "hello again\n" });
+
+    const head_before = try git.headSha(std.testing.allocator);
+    defer std.testing.allocator.free(head_before);
+    const tree_before = try git.stagedTreeHash(std.testing.allocator);
+    defer std.testing.allocator.free(tree_before);
+
+    var seen = false;
+    const message = (try previewMessage(std.testing.allocator, FakePreviewGenerator{ .allocator = std.testing.allocator, .seen = &seen })).?;
+    defer std.testing.allocator.free(message);
+    try std.testing.expect(seen);
+    try std.testing.expectEqualStrings("docs: update notes", message);
+
+    const head_after = try git.headSha(std.testing.allocator);
+    defer std.testing.allocator.free(head_after);
+    try std.testing.expectEqualStrings(head_before, head_after);
+    try std.testing.expect(!try git.stagedTreeChanged(std.testing.allocator, tree_before));
+}
+
 test {
     _ = @import("cli.zig");
+    _ = @import("commit_msg.zig");
+    _ = @import("completion.zig");
     _ = @import("config.zig");
+    _ = @import("config_edit.zig");
     _ = @import("git.zig");
+    _ = @import("github_actions.zig");
     _ = @import("http_client.zig");
+    _ = @import("keyring.zig");
     _ = @import("llm.zig");
+    _ = @import("model_picker.zig");
+    _ = @import("prompt.zig");
+    _ = @import("pull_request.zig");
+    _ = @import("runner.zig");
+    _ = @import("serve.zig");
+    _ = @import("providers/ollama.zig");
+    _ = @import("providers/openai_compat.zig");
+    _ = @import("providers/openrouter.zig");
+    _ = @import("providers/gemini.zig");
+    _ = @import("providers/azure.zig");
+    _ = @import("providers/registry.zig");
+    _ = @import("providers/zai.zig");
 }
 
-fn printDebugInfo(args: *const cli.Args, stderr: anytype) !void {
-    try colors.debug(stderr, "Command={s}\n", .{@tagName(args.command)});
-    if (args.command == .config) {
-        try colors.debug(stderr, "ConfigSubcommand={s}\n", .{@tagName(args.config_sub)});
-    }
-    try colors.debug(stderr, "auto_add={}\n", .{args.auto_add});
-    try colors.debug(stderr, "auto_push={}\n", .{args.auto_push});
-    try colors.debug(stderr, "auto_accept={}\n", .{args.auto_accept});
-    if (args.provider) |p| {
-        try colors.debug(stderr, "provider={s}\n", .{p});
+/// Print a completion script, or the provider names the scripts complete `--provider` with
+fn runCompletion(allocator: std.mem.Allocator, target: []const u8, stdout: anytype, stderr: anytype) !void {
+    if (std.mem.eql(u8, target, "providers")) {
+        const cfg = config.load(allocator) catch {
+            try completion.writeProviderNames(stdout, &.{});
+            return;
+        };
+        defer cfg.deinit(allocator);
+        try completion.writeProviderNames(stdout, cfg.providers);
+        return;
     }
-}
 
-fn refreshStatus(allocator: std.mem.Allocator, status: *git.GitStatus, writer: anytype) !bool {
+    const shell = std.meta.stringToEnum(completion.Shell, target) orelse {
+        try stderr.print("Unsupported shell '{s}'. Use bash, zsh, or fish.\n", .{target});
+        std.process.exit(1);
+    };
+    try completion.writeScript(stdout, shell);
+}
+
+/// Uncommit HEAD with `git reset --soft HEAD~1`, refusing once the commit has been pushed
+fn runUndo(allocator: std.mem.Allocator, skip_confirm: bool, stdout: anytype, stderr: anytype) !void {
+    if (!git.isRepo()) {
+        try stderr.print("Not a git repository.\n", .{});
+        std.process.exit(1);
+    }
+
+    const subject = git.getCommitSubject(allocator, "HEAD") catch {
+        try stderr.print("No commit to undo.\n", .{});
+        std.process.exit(1);
+    };
+    defer allocator.free(subject);
+
+    const pushed = git.isCommitPushed(allocator, "HEAD") catch {
+        try stderr.print("Failed to check whether HEAD has been pushed\n", .{});
+        std.process.exit(1);
+    };
+    if (pushed) {
+        try stderr.print("{s}HEAD is already on a remote branch; undoing it would rewrite published history. Use 'git revert HEAD' instead.{s}\n", .{ Color.red, Color.reset });
+        std.process.exit(1);
+    }
+
+    if (!skip_confirm) {
+        try stdout.print("Last commit: {s}{s}{s}\n", .{ Color.cyan, subject, Color.reset });
+        if (!try confirmDestructive(stdout, stderr, "Undo it and keep the changes staged?")) {
+            try stdout.print("{s}Nothing changed.{s}\n", .{ Color.yellow, Color.reset });
+            return;
+        }
+    }
+
+    git.softResetLast(allocator) catch |err| {
+        switch (err) {
+            error.NoParentCommit => try stderr.print("Cannot undo the root commit.\n", .{}),
+            else => try stderr.print("Failed to undo commit: {s}\n", .{@errorName(err)}),
+        }
+        std.process.exit(1);
+    };
+    try stdout.print("{s}Undid commit:{s} {s} (changes kept staged)\n", .{ Color.green, Color.reset, subject });
+}
+
+/// What main needs to build the run's provider once a path is about to call the LLM
+const ProviderSetup = struct {
+    allocator: std.mem.Allocator,
+    cfg: *const config.Config,
+    args: *const cli.Args,
+    name: []const u8,
+    provider_cfg: *const config.ProviderConfig,
+    model: []const u8,
+    debug_log: ?llm.DebugLogFn,
+    debug_ctx: ?*anyopaque,
+
+    /// Resolve the API key and create the provider with its HTTP client and fallbacks
+    /// Prints why and exits when the key or transport can't be set up
+    fn start(self: ProviderSetup, stderr: anytype) !*ProviderSession {
+        const allocator = self.allocator;
+        const cfg = self.cfg;
+        const provider_cfg = self.provider_cfg;
+
+        const api_key = config.resolveApiKey(allocator, provider_cfg) catch |err| {
+            switch (err) {
+                error.ApiKeyNotSet => try stderr.print("No API key set for provider {s}. Run 'autocommit config' to set one.\n", .{self.name}),
+                error.ApiKeyFileUnreadable => try stderr.print("Failed to read API key file: {s}\n", .{provider_cfg.api_key_file orelse ""}),
+                error.ApiKeyCommandFailed => try stderr.print("API key command failed: {s}\n", .{provider_cfg.api_key_command orelse ""}),
+                else => try stderr.print("Failed to resolve API key: {s}\n", .{@errorName(err)}),
+            }
+            std.process.exit(1);
+        };
+        errdefer allocator.free(api_key);
+
+        const session = try allocator.create(ProviderSession);
+        errdefer allocator.destroy(session);
+        session.* = .{
+            .allocator = allocator,
+            .api_key = api_key,
+            .http = http_client.HttpClient.init(allocator),
+            .provider = undefined,
+            .fallbacks = undefined,
+            .switched = FallbackProviders.init(allocator),
+        };
+        errdefer session.http.deinit();
+
+        const http = &session.http;
+        http.anonymize = cfg.anonymize;
+        http.max_retries = cfg.max_retries;
+        http.timeout_seconds = provider_cfg.timeoutOrDefault(cfg.timeout_seconds);
+        http.configureTransport(.{
+            .proxy = provider_cfg.proxyOrDefault(cfg.proxy),
+            .ca_file = provider_cfg.ca_file,
+        }) catch |err| {
+            try stderr.print("Failed to configure proxy/TLS for provider {s}: {s}\n", .{ self.name, @errorName(err) });
+            std.process.exit(1);
+        };
+
+        var resolved_provider_cfg = provider_cfg.*;
+        resolved_provider_cfg.api_key = api_key;
+        resolved_provider_cfg.model = self.model;
+
+        session.provider = llm.createProvider(allocator, self.name, resolved_provider_cfg, http, self.debug_log, self.debug_ctx) catch |err| {
+            try stderr.print("Failed to create provider: {s}\n", .{@errorName(err)});
+            std.process.exit(1);
+        };
+        errdefer llm.destroyProvider(&session.provider, allocator);
+
+        session.fallbacks = try createFallbacks(allocator, cfg, self.name, self.debug_log, self.debug_ctx, stderr);
+
+        const provider = &session.provider;
+        provider.fallback = session.fallbacks.link();
+        provider.context_overflow_retry = cfg.context_overflow_retry;
+        provider.raw_output = self.args.raw;
+        provider.body_wrap_width = cfg.max_body_line_length;
+        provider.replacement_model = &session.replacement_model;
+        provider.usage = &session.usage;
+
+        // Only stream to a terminal; piped output gets the final message alone
+        const args = self.args;
+        if (cfg.stream and args.command == .main and !args.dry_run and args.diff_file == null and args.range == null and std.io.getStdOut().isTty()) {
+            provider.on_token = printToken;
+        }
+        return session;
+    }
+};
+
+/// The run's provider and the key, HTTP client, and fallbacks it points into
+/// Heap-allocated so those pointers stay valid for the whole run
+const ProviderSession = struct {
+    allocator: std.mem.Allocator,
+    api_key: []const u8,
+    http: http_client.HttpClient,
+    provider: llm.Provider,
+    fallbacks: FallbackProviders,
+    /// Keys and HTTP clients of models switched to at the commit prompt
+    switched: FallbackProviders,
+    replacement_model: ?[]const u8 = null,
+    /// Token counts of the latest response, printed after each generated message
+    usage: llm.Usage = .{},
+
+    fn destroy(self: *ProviderSession) void {
+        const allocator = self.allocator;
+        self.switched.deinit();
+        self.fallbacks.deinit();
+        llm.destroyProvider(&self.provider, allocator);
+        self.http.deinit();
+        allocator.free(self.api_key);
+        allocator.destroy(self);
+    }
+};
+
+/// Providers created besides the primary one (`fallback_providers`, or a model switched to at the
+/// commit prompt), each with its own resolved key and HTTP client
+const FallbackProviders = struct {
+    allocator: std.mem.Allocator,
+    providers: std.ArrayList(llm.Provider),
+    http_clients: std.ArrayList(*http_client.HttpClient),
+    api_keys: std.ArrayList([]const u8),
+
+    fn init(allocator: std.mem.Allocator) FallbackProviders {
+        return .{
+            .allocator = allocator,
+            .providers = std.ArrayList(llm.Provider).init(allocator),
+            .http_clients = std.ArrayList(*http_client.HttpClient).init(allocator),
+            .api_keys = std.ArrayList([]const u8).init(allocator),
+        };
+    }
+
+    fn deinit(self: *FallbackProviders) void {
+        for (self.providers.items) |*fallback| llm.destroyProvider(fallback, self.allocator);
+        for (self.http_clients.items) |http| {
+            http.deinit();
+            self.allocator.destroy(http);
+        }
+        for (self.api_keys.items) |key| self.allocator.free(key);
+        self.providers.deinit();
+        self.http_clients.deinit();
+        self.api_keys.deinit();
+    }
+
+    /// Link each provider to the next and return the head of the chain
+    /// Call once all providers are added so the pointers stay valid
+    fn link(self: *FallbackProviders) ?*const llm.Provider {
+        const items = self.providers.items;
+        if (items.len == 0) return null;
+        for (items[0 .. items.len - 1], items[1..]) |*current, *next| {
+            current.fallback = next;
+        }
+        return &items[0];
+    }
+};
+
+/// Explain a failed config load: a missing file needs creating, a broken one needs fixing
+fn printConfigLoadError(allocator: std.mem.Allocator, err: anyerror, stderr: anytype) !void {
+    const path = config.getConfigPath(allocator) catch null;
+    defer if (path) |p| allocator.free(p);
+    const shown_path = path orelse "the config path";
+
+    switch (config.classifyLoadError(err)) {
+        .missing => try stderr.print("No config file yet. Run 'autocommit config' to create one.\n", .{}),
+        .unreadable => try stderr.print("Could not read {s}: {s}. Check its permissions.\n", .{ shown_path, @errorName(err) }),
+        .invalid => try stderr.print(
+            "{s} is not a valid config ({s}).\nRun 'autocommit config' to fix it in your editor, or move it aside and run 'autocommit config' to create a fresh one.\n",
+            .{ shown_path, @errorName(err) },
+        ),
+        .other => try stderr.print("Failed to load config: {s}\n", .{@errorName(err)}),
+    }
+}
+
+/// Build the fallback chain, skipping (with a warning) providers that are not usable
+fn createFallbacks(
+    allocator: std.mem.Allocator,
+    cfg: *const config.Config,
+    primary_name: []const u8,
+    debug_log: ?llm.DebugLogFn,
+    debug_ctx: ?*anyopaque,
+    stderr: anytype,
+) !FallbackProviders {
+    var fallbacks = FallbackProviders.init(allocator);
+    errdefer fallbacks.deinit();
+
+    for (cfg.fallback_providers) |name| {
+        if (std.mem.eql(u8, name, primary_name)) continue;
+
+        const fallback_cfg = cfg.getProvider(name) catch {
+            try stderr.print("{s}Warning: fallback provider '{s}' is not configured, skipping{s}\n", .{ Color.yellow, name, Color.reset });
+            continue;
+        };
+
+        const api_key = config.resolveApiKey(allocator, fallback_cfg) catch |err| {
+            try stderr.print("{s}Warning: fallback provider '{s}' has no usable API key ({s}), skipping{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+            continue;
+        };
+        fallbacks.api_keys.append(api_key) catch |err| {
+            allocator.free(api_key);
+            return err;
+        };
+
+        const http = try allocator.create(http_client.HttpClient);
+        http.* = http_client.HttpClient.init(allocator);
+        fallbacks.http_clients.append(http) catch |err| {
+            http.deinit();
+            allocator.destroy(http);
+            return err;
+        };
+        http.anonymize = cfg.anonymize;
+        http.max_retries = cfg.max_retries;
+        http.timeout_seconds = fallback_cfg.timeoutOrDefault(cfg.timeout_seconds);
+        http.configureTransport(.{
+            .proxy = fallback_cfg.proxyOrDefault(cfg.proxy),
+            .ca_file = fallback_cfg.ca_file,
+        }) catch |err| {
+            try stderr.print("{s}Warning: fallback provider '{s}' proxy/TLS setup failed ({s}), skipping{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+            continue;
+        };
+
+        var resolved_cfg = fallback_cfg.*;
+        resolved_cfg.api_key = api_key;
+
+        var fallback = llm.createProvider(allocator, name, resolved_cfg, http, debug_log, debug_ctx) catch |err| {
+            try stderr.print("{s}Warning: fallback provider '{s}' is not supported ({s}), skipping{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+            continue;
+        };
+        fallbacks.providers.append(fallback) catch |err| {
+            llm.destroyProvider(&fallback, allocator);
+            return err;
+        };
+    }
+
+    return fallbacks;
+}
+
+/// Let the user pick another configured provider/model and swap it in for `provider`
+/// Streaming, fallbacks, and output settings carry over; `switched` owns the new key and HTTP client
+/// Returns false when the current model is kept
+fn switchModel(
+    allocator: std.mem.Allocator,
+    cfg: *const config.Config,
+    provider: *llm.Provider,
+    switched: *FallbackProviders,
+    stdout: anytype,
+    stderr: anytype,
+) !bool {
+    const choices = try model_picker.collectChoices(allocator, cfg.providers);
+    defer allocator.free(choices);
+
+    const stdin = std.io.getStdIn().reader();
+    const choice = (try model_picker.prompt(stdin, stdout, choices, provider.name, provider.config.model)) orelse return false;
+    const name = choice.provider.name;
+
+    const api_key = config.resolveApiKey(allocator, choice.provider) catch |err| {
+        try stderr.print("{s}Provider '{s}' has no usable API key ({s}); keeping the current model{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+        return false;
+    };
+    switched.api_keys.append(api_key) catch |err| {
+        allocator.free(api_key);
+        return err;
+    };
+
+    const http = try allocator.create(http_client.HttpClient);
+    http.* = http_client.HttpClient.init(allocator);
+    switched.http_clients.append(http) catch |err| {
+        http.deinit();
+        allocator.destroy(http);
+        return err;
+    };
+    http.anonymize = cfg.anonymize;
+    http.max_retries = cfg.max_retries;
+    http.timeout_seconds = choice.provider.timeoutOrDefault(cfg.timeout_seconds);
+    http.configureTransport(.{
+        .proxy = choice.provider.proxyOrDefault(cfg.proxy),
+        .ca_file = choice.provider.ca_file,
+    }) catch |err| {
+        try stderr.print("{s}Proxy/TLS setup for provider '{s}' failed ({s}); keeping the current model{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+        return false;
+    };
+
+    var resolved_cfg = choice.provider.*;
+    resolved_cfg.api_key = api_key;
+    resolved_cfg.model = choice.model;
+
+    var next = llm.createProvider(allocator, name, resolved_cfg, http, provider.debug_log, provider.debug_ctx) catch |err| {
+        try stderr.print("{s}Provider '{s}' is not supported ({s}); keeping the current model{s}\n", .{ Color.yellow, name, @errorName(err), Color.reset });
+        return false;
+    };
+    next.stream = provider.stream;
+    next.on_token = provider.on_token;
+    next.token_ctx = provider.token_ctx;
+    next.fallback = provider.fallback;
+    next.context_overflow_retry = provider.context_overflow_retry;
+    next.usage = provider.usage;
+    next.replacement_model = provider.replacement_model;
+    next.raw_output = provider.raw_output;
+    next.body_wrap_width = provider.body_wrap_width;
+
+    llm.destroyProvider(provider, allocator);
+    provider.* = next;
+    try stdout.print("{s}Switched to {s} / {s}{s}\n", .{ Color.green, name, choice.model, Color.reset });
+    return true;
+}
+
+/// Print a single resolved setting for scripts
+fn runConfigGet(allocator: std.mem.Allocator, key: []const u8, profile: ?[]const u8, stdout: anytype, stderr: anytype) !void {
+    const cfg = config.loadWithProfile(allocator, profile) catch |err| {
+        try printConfigLoadError(allocator, err, stderr);
+        std.process.exit(1);
+    };
+    defer cfg.deinit(allocator);
+
+    const value = config.getSetting(allocator, &cfg, key) catch |err| {
+        switch (err) {
+            error.UnknownKey => try stderr.print("Unknown config key '{s}'.\n", .{key}),
+            error.KeyNotSet => try stderr.print("'{s}' is not set.\n", .{key}),
+            else => try stderr.print("Failed to read '{s}': {s}\n", .{ key, @errorName(err) }),
+        }
+        std.process.exit(1);
+    };
+    defer allocator.free(value);
+
+    try stdout.print("{s}\n", .{value});
+}
+
+/// Fetch a team config fragment and merge it into the local config file
+fn runConfigImport(allocator: std.mem.Allocator, url: []const u8, stdout: anytype, stderr: anytype) !void {
+    if (!std.mem.startsWith(u8, url, "https://")) {
+        try stderr.print("Only https:// URLs can be imported.\n", .{});
+        std.process.exit(1);
+    }
+
+    var http = http_client.HttpClient.init(allocator);
+    defer http.deinit();
+    try http.configureTransport(.{});
+
+    const fragment = http.get(url) catch |err| {
+        try stderr.print("Failed to fetch {s}: {s}\n", .{ url, @errorName(err) });
+        std.process.exit(1);
+    };
+    defer allocator.free(fragment);
+
+    config.importTeamFragment(allocator, fragment) catch |err| {
+        switch (err) {
+            error.FragmentContainsApiKey => try stderr.print("Refusing to import: the fragment contains API key settings. Keys are never imported from a URL.\n", .{}),
+            error.FragmentContainsEndpoint => try stderr.print("Refusing to import: the fragment sets a provider endpoint. Endpoints decide where your key and diffs are sent, so they are never imported from a URL.\n", .{}),
+            error.UnknownProvider => try stderr.print("Refusing to import: the fragment names an unknown provider.\n", .{}),
+            error.InvalidFragment => try stderr.print("Refusing to import: the fragment is not valid TOML or would break the config.\n", .{}),
+            error.EmptyFragment => try stderr.print("Nothing to import: the fragment has no supported settings.\n", .{}),
+            error.FileNotFound => try stderr.print("No config file yet. Run 'autocommit config' to create one first.\n", .{}),
+            else => try stderr.print("Failed to import config: {s}\n", .{@errorName(err)}),
+        }
+        std.process.exit(1);
+    };
+
+    try stdout.print("Imported team settings from {s}\n", .{url});
+}
+
+/// Store a provider's API key in the OS keyring and point api_key_command at it
+/// Without a keyring, the key is written to the config file in plaintext with a warning
+fn runSetKey(allocator: std.mem.Allocator, provider_name: []const u8, stdout: anytype, stderr: anytype) !void {
+    if (registry.getByName(provider_name) == null) {
+        try stderr.print("Unknown provider '{s}'.\n", .{provider_name});
+        std.process.exit(1);
+    }
+
+    const key = try readSecret(allocator, provider_name, stdout);
+    defer allocator.free(key);
+    if (key.len == 0) {
+        try stderr.print("No API key entered.\n", .{});
+        std.process.exit(1);
+    }
+
+    const backend = keyring.Backend.detect(allocator) orelse {
+        try stderr.print("{s}No system keyring found (macOS Keychain or secret-tool); storing the key in plaintext in the config file.{s}\n", .{ Color.yellow, Color.reset });
+        config.editProviders(allocator, &.{.{ .provider = provider_name, .key = "api_key", .value = key }}) catch |err| {
+            try stderr.print("Failed to update config: {s}\n", .{@errorName(err)});
+            std.process.exit(1);
+        };
+        return;
+    };
+
+    backend.store(allocator, provider_name, key) catch |err| {
+        try stderr.print("Failed to store the key in the keyring: {s}\n", .{@errorName(err)});
+        std.process.exit(1);
+    };
+
+    const lookup = try backend.lookupCommand(allocator, provider_name);
+    defer allocator.free(lookup);
+    // The plaintext key is dropped so the keyring reference is used
+    config.editProviders(allocator, &.{
+        .{ .provider = provider_name, .key = "api_key", .value = null },
+        .{ .provider = provider_name, .key = "api_key_command", .value = lookup },
+    }) catch |err| {
+        try stderr.print("Stored the key, but failed to update config: {s}\n", .{@errorName(err)});
+        std.process.exit(1);
+    };
+
+    try stdout.print("Stored the {s} API key in the system keyring.\n", .{provider_name});
+}
+
+/// Remove a provider's key from the OS keyring and drop the config reference to it
+fn runDeleteKey(allocator: std.mem.Allocator, provider_name: []const u8, stdout: anytype, stderr: anytype) !void {
+    const backend = keyring.Backend.detect(allocator) orelse {
+        try stderr.print("No system keyring found (macOS Keychain or secret-tool).\n", .{});
+        std.process.exit(1);
+    };
+
+    backend.delete(allocator, provider_name) catch {
+        try stderr.print("No {s} API key found in the keyring.\n", .{provider_name});
+        std.process.exit(1);
+    };
+
+    const cfg = config.loadWithProfile(allocator, config.DEFAULT_PROFILE) catch |err| {
+        try stderr.print("Failed to load config: {s}\n", .{@errorName(err)});
+        std.process.exit(1);
+    };
+    defer cfg.deinit(allocator);
+
+    const lookup = try backend.lookupCommand(allocator, provider_name);
+    defer allocator.free(lookup);
+
+    // Leave a hand-written api_key_command alone
+    const provider_cfg = cfg.getProvider(provider_name) catch null;
+    const references_keyring = if (provider_cfg) |p| p.api_key_command != null and std.mem.eql(u8, p.api_key_command.?, lookup) else false;
+    if (references_keyring) {
+        config.editProviders(allocator, &.{.{ .provider = provider_name, .key = "api_key_command", .value = null }}) catch |err| {
+            try stderr.print("Removed the key, but failed to update config: {s}\n", .{@errorName(err)});
+            std.process.exit(1);
+        };
+    }
+
+    try stdout.print("Removed the {s} API key from the system keyring.\n", .{provider_name});
+}
+
+/// Remove a setting for `config unset <key>`; removing a whole provider asks first and reports the new default
+fn runConfigUnset(allocator: std.mem.Allocator, key: []const u8, skip_confirm: bool, stdout: anytype, stderr: anytype) !void {
+    const removes_provider = std.mem.startsWith(u8, key, "providers.") and std.mem.indexOfScalar(u8, key["providers.".len..], '.') == null;
+    if (removes_provider and !skip_confirm) {
+        const question = try std.fmt.allocPrint(allocator, "Delete provider '{s}' and all its settings?", .{key["providers.".len..]});
+        defer allocator.free(question);
+        if (!try confirmDestructive(stdout, stderr, question)) {
+            try stdout.print("Aborted, {s} unchanged.\n", .{key});
+            return;
+        }
+    }
+
+    config.unsetSetting(allocator, key) catch |err| {
+        switch (err) {
+            error.KeyNotSet => try stderr.print("'{s}' is not set in the config file.\n", .{key}),
+            error.RequiredSetting => try stderr.print("Cannot unset '{s}': the config would no longer load without it.\n", .{key}),
+            else => try stderr.print("Failed to unset '{s}': {s}\n", .{ key, @errorName(err) }),
+        }
+        std.process.exit(1);
+    };
+    try stdout.print("Removed {s}\n", .{key});
+
+    if (!removes_provider) return;
+    const cfg = config.load(allocator) catch return;
+    defer cfg.deinit(allocator);
+    if (cfg.default_provider.len > 0) {
+        try stdout.print("Default provider: {s}\n", .{cfg.default_provider});
+    } else {
+        try stdout.print("No default provider is set; pass --provider or add one to the config.\n", .{});
+    }
+}
+
+/// Restore the shipped system prompt after confirmation, keeping a backup of the config
+/// so the previous prompt can be copied back
+fn runResetPrompt(allocator: std.mem.Allocator, skip_confirm: bool, stdout: anytype, stderr: anytype) !void {
+    if (!skip_confirm and !try confirmDestructive(stdout, stderr, "Replace your system_prompt with the default?")) {
+        try stdout.print("Aborted, system_prompt unchanged.\n", .{});
+        return;
+    }
+
+    const backup_path = config.resetSystemPrompt(allocator) catch |err| {
+        try stderr.print("Failed to reset system_prompt: {s}\n", .{@errorName(err)});
+        std.process.exit(1);
+    };
+    defer allocator.free(backup_path);
+
+    try stdout.print("system_prompt reset to the default. The previous config is saved as {s}; copy it back to undo.\n", .{backup_path});
+}
+
+/// Read one line from stdin, hiding the input when it is a terminal
+fn readSecret(allocator: std.mem.Allocator, provider_name: []const u8, stdout: anytype) ![]const u8 {
+    const stdin_file = std.io.getStdIn();
+    const original = if (stdin_file.isTty()) std.posix.tcgetattr(stdin_file.handle) catch null else null;
+    if (original) |termios| {
+        try stdout.print("API key for {s}: ", .{provider_name});
+        var hidden = termios;
+        hidden.lflag.ECHO = false;
+        std.posix.tcsetattr(stdin_file.handle, .NOW, hidden) catch {};
+    }
+    defer if (original) |termios| {
+        std.posix.tcsetattr(stdin_file.handle, .NOW, termios) catch {};
+        stdout.print("\n", .{}) catch {};
+    };
+
+    const line = try stdin_file.reader().readUntilDelimiterOrEofAlloc(allocator, '\n', 64 * 1024) orelse return allocator.dupe(u8, "");
+    defer allocator.free(line);
+    return allocator.dupe(u8, std.mem.trim(u8, line, " \t\r\n"));
+}
+
+/// Print each resolved setting with the layer that provided it (--explain-config)
+fn printConfigExplanation(allocator: std.mem.Allocator, cfg: *const config.Config, args: *const cli.Args, stdout: anytype) !void {
+    var env_map = try std.process.getEnvMap(allocator);
+    defer env_map.deinit();
+
+    const settings = try config.explainConfig(allocator, cfg, .{
+        .provider = args.provider,
+        .model = args.model,
+        .profile = args.profile,
+        .auto_push = args.auto_push,
+        .proxy = args.proxy,
+        .recent_commits = args.context_commits,
+    }, &env_map);
+    defer config.freeExplanation(allocator, settings);
+
+    try stdout.print("{s}Resolved configuration:{s}\n", .{ Color.bold, Color.reset });
+    for (settings) |setting| {
+        const color = if (setting.source == .default) Color.gray else Color.cyan;
+        try stdout.print("  {s} = {s}  {s}({s}){s}\n", .{ setting.name, setting.value, color, setting.source.label(), Color.reset });
+    }
+}
+
+/// Read a diff from `path`, or from stdin when `path` is "-"
+/// Caller owns the returned memory
+fn readDiffInput(allocator: std.mem.Allocator, path: []const u8) ![]const u8 {
+    if (std.mem.eql(u8, path, "-")) {
+        return std.io.getStdIn().readToEndAlloc(allocator, MAX_DIFF_INPUT);
+    }
+    return std.fs.cwd().readFileAlloc(allocator, path, MAX_DIFF_INPUT);
+}
+
+fn printDebugInfo(args: *const cli.Args, stderr: anytype) !void {
+    try colors.debug(stderr, "Command={s}\n", .{@tagName(args.command)});
+    if (args.command == .config) {
+        try colors.debug(stderr, "ConfigSubcommand={s}\n", .{@tagName(args.config_sub)});
+    }
+    try colors.debug(stderr, "auto_add={}\n", .{args.auto_add});
+    try colors.debug(stderr, "auto_push={}\n", .{args.auto_push});
+    try colors.debug(stderr, "auto_accept={}\n", .{args.auto_accept});
+    try colors.debug(stderr, "preview={}\n", .{args.preview});
+    try colors.debug(stderr, "dry_run={}\n", .{args.dry_run});
+    try colors.debug(stderr, "batch={}\n", .{args.batch});
+    try colors.debug(stderr, "skip_checks={}\n", .{args.skip_checks});
+    try colors.debug(stderr, "amend={}\n", .{args.amend});
+    try colors.debug(stderr, "no_verify={}\n", .{args.no_verify});
+    try colors.debug(stderr, "github_annotation={}\n", .{args.github_annotation});
+    if (args.provider) |p| {
+        try colors.debug(stderr, "provider={s}\n", .{p});
+    }
+    if (args.model) |m| {
+        try colors.debug(stderr, "model={s}\n", .{m});
+    }
+    if (args.profile) |p| {
+        try colors.debug(stderr, "profile={s}\n", .{p});
+    }
+    if (args.context_file) |path| {
+        try colors.debug(stderr, "context_file={s}\n", .{path});
+    }
+    if (args.diff_file) |path| {
+        try colors.debug(stderr, "diff_file={s}\n", .{path});
+    }
+    if (args.range) |range| {
+        try colors.debug(stderr, "range={s}\n", .{range});
+    }
+}
+
+/// Message for the unstaged changes, or null when there are none
+/// Only reads the working tree: nothing is staged or committed, so the index and HEAD stay put
+/// Caller owns the returned memory
+fn previewMessage(allocator: std.mem.Allocator, generator: anytype) !?[]const u8 {
+    const unstaged_diff = git.getUnstagedDiff(allocator) catch return error.UnstagedDiffFailed;
+    defer allocator.free(unstaged_diff);
+
+    if (unstaged_diff.len == 0) return null;
+    return try generator.message(unstaged_diff);
+}
+
+/// Truncate the diff and generate a commit message, printing a friendly error and exiting on failure
+/// Caller owns the returned memory
+fn generateOrExit(
+    allocator: std.mem.Allocator,
+    provider: llm.Provider,
+    diff: []const u8,
+    context: prompt_builder.Context,
+    system_prompt: []const u8,
+    max_diff_bytes: usize,
+    debug: bool,
+    stderr: anytype,
+) ![]const u8 {
+    return generateMessage(allocator, provider, diff, context, system_prompt, max_diff_bytes, debug, stderr) catch |err| switch (err) {
+        error.GenerationFailed => std.process.exit(1),
+        else => |other| return other,
+    };
+}
+
+/// Like generateOrExit, but returns error.GenerationFailed (after printing why) instead of exiting
+/// Caller owns the returned memory
+fn generateMessage(
+    allocator: std.mem.Allocator,
+    provider: llm.Provider,
+    diff: []const u8,
+    context: prompt_builder.Context,
+    system_prompt: []const u8,
+    max_diff_bytes: usize,
+    debug: bool,
+    stderr: anytype,
+) ![]const u8 {
+    if (debug) {
+        try colors.debug(stderr, "Diff size: {d} bytes\n", .{diff.len});
+    }
+
+    // Extra context shares the size budget with the diff
+    const diff_budget = max_diff_bytes -| context.cappedExtraContext().len;
+    var attempt = BudgetedAttempt{
+        .allocator = allocator,
+        .provider = provider,
+        .diff = diff,
+        .context = context,
+        .system_prompt = system_prompt,
+    };
+
+    if (provider.usage) |usage| usage.* = .{};
+
+    if (provider.on_token != null) {
+        try std.io.getStdOut().writer().print("\n{s}", .{Color.gray});
+    }
+    defer if (provider.on_token != null) {
+        std.io.getStdOut().writer().print("{s}\n", .{Color.reset}) catch {};
+    };
+
+    // Debug logging handled internally by llm module when debug is enabled
+    const message = llm.retryOnContextOverflow(&attempt, diff_budget, provider.context_overflow_retry) catch |err| {
+        var message_buffer: [256]u8 = undefined;
+        const error_message = switch (err) {
+            llm.LlmError.InvalidApiKey => std.fmt.bufPrint(&message_buffer, "Authentication failed for provider {s}. Check its API key with 'autocommit config show', or store a new one with 'autocommit config set-key {s}'.", .{ provider.name, provider.name }) catch "Authentication failed. Check your API key.",
+            llm.LlmError.RateLimited => std.fmt.bufPrint(&message_buffer, "Rate limited by {s}. Wait a moment and try again, or add fallback_providers to your config.", .{provider.name}) catch "Rate limit exceeded. Please try again later.",
+            llm.LlmError.ServerError => "Server error. Please try again later.",
+            llm.LlmError.Timeout => std.fmt.bufPrint(&message_buffer, "Request timed out after {d}s. Try a faster model or raise timeout_seconds.", .{provider.http.timeout_seconds}) catch "Request timed out.",
+            llm.LlmError.InvalidResponse => "Invalid response from API.",
+            llm.LlmError.EmptyContent => "LLM returned empty message.",
+            llm.LlmError.ReasoningOnly => "The model returned only its reasoning (<think> block) and no commit message. Try again or use a non-reasoning model.",
+            llm.LlmError.ContextLengthExceeded => "The diff is too large for the model's context window. Lower max_diff_bytes or use a model with a larger context.",
+            llm.LlmError.ModelNotFound => "The configured model was not found or has been retired, and no fallback model worked. Update the provider's model in your config.",
+            llm.LlmError.NotFound => std.fmt.bufPrint(&message_buffer, "Provider {s} answered 404 Not Found. Check its endpoint and model with 'autocommit config show'.", .{provider.name}) catch "The provider answered 404 Not Found. Check the endpoint and model.",
+            llm.LlmError.ApiError => "API error occurred.",
+            llm.LlmError.OutOfMemory => "Out of memory.",
+        };
+        try stderr.print("Error: {s}\n", .{error_message});
+        const from_response = switch (err) {
+            llm.LlmError.InvalidApiKey, llm.LlmError.RateLimited, llm.LlmError.NotFound, llm.LlmError.ApiError, llm.LlmError.InvalidResponse => true,
+            else => false,
+        };
+        if (from_response and !debug) {
+            try stderr.print("Run again with --debug to see the provider's full response.\n", .{});
+        }
+        return error.GenerationFailed;
+    };
+
+    if (provider.replacement_model) |target| {
+        if (target.*) |model| {
+            try stderr.print("{s}Warning: model '{s}' is not available from {s}; used '{s}' instead. Update the model in your config (autocommit config).{s}\n", .{ Color.yellow, provider.config.model, provider.name, model, Color.reset });
+            target.* = null;
+        }
+    }
+
+    // On stderr, so a captured --dry-run message stays clean
+    if (provider.usage) |usage| {
+        if (usage.reported()) {
+            try stderr.print("{s}", .{Color.gray});
+            try usage.print(stderr);
+            try stderr.print("{s}", .{Color.reset});
+        }
+    }
+    return message;
+}
+
+/// One generation with the diff condensed to a byte budget (see llm.retryOnContextOverflow)
+const BudgetedAttempt = struct {
+    allocator: std.mem.Allocator,
+    provider: llm.Provider,
+    diff: []const u8,
+    context: prompt_builder.Context,
+    system_prompt: []const u8,
+    attempted: bool = false,
+
+    fn generate(self: *BudgetedAttempt, diff_budget: usize) llm.LlmError![]const u8 {
+        const stderr = std.io.getStdErr().writer();
+        if (self.attempted) {
+            stderr.print("{s}Context window exceeded; retrying with a {d}-byte diff budget{s}\n", .{ Color.yellow, diff_budget, Color.reset }) catch {};
+        }
+        self.attempted = true;
+
+        const truncated_diff = try git.truncateDiff(self.allocator, self.diff, diff_budget);
+        defer self.allocator.free(truncated_diff);
+
+        if (self.diff.len > diff_budget) {
+            stderr.print("{s}", .{Color.yellow}) catch {};
+            prompt_builder.writeTruncationWarning(stderr, self.diff.len, truncated_diff.len) catch {};
+            stderr.print("{s}", .{Color.reset}) catch {};
+        }
+
+        const user_content = try prompt_builder.buildUserContent(self.allocator, truncated_diff, self.context);
+        defer self.allocator.free(user_content);
+
+        return self.provider.generateCommitMessage(user_content, self.system_prompt);
+    }
+};
+
+/// Experimental: commit staged changes as one commit per top-level directory
+/// Each group is committed from its own staged changes only (see git.commitGroups), so unstaged
+/// edits stay out. Declined groups stay staged, and a failure puts the index back as it was.
+fn runBatch(
+    allocator: std.mem.Allocator,
+    provider: llm.Provider,
+    status: *git.GitStatus,
+    context: prompt_builder.Context,
+    system_prompt: []const u8,
+    max_diff_bytes: usize,
+    commit_options: git.CommitOptions,
+    trailers: commit_msg.Trailers,
+    subject_template: []const u8,
+    args: *const cli.Args,
+    stdout: anytype,
+    stderr: anytype,
+) !void {
+    var paths = std.ArrayList([]const u8).init(allocator);
+    defer paths.deinit();
+
+    var iter = status.stagedIterator();
+    while (iter.next()) |entry| {
+        try paths.append(entry.path);
+        if (entry.state.original_path) |original| {
+            try paths.append(original);
+        }
+    }
+    std.mem.sort([]const u8, paths.items, {}, lessThanPath);
+
+    var groups = try git.groupByTopLevel(allocator, paths.items);
+    defer groups.deinit();
+
+    try stdout.print("\n{s}Batch mode (experimental): {d} group(s){s}\n", .{ Color.bold, groups.map.count(), Color.reset });
+
+    // Shows each group and decides its message; git.commitGroups does the staging and committing
+    const Out = @TypeOf(stdout);
+    const Err = @TypeOf(stderr);
+    const Driver = struct {
+        allocator: std.mem.Allocator,
+        provider: llm.Provider,
+        context: prompt_builder.Context,
+        system_prompt: []const u8,
+        max_diff_bytes: usize,
+        trailers: commit_msg.Trailers,
+        subject_template: []const u8,
+        args: *const cli.Args,
+        stdout: Out,
+        stderr: Err,
+
+        pub fn message(self: @This(), name: []const u8, group_paths: []const []const u8, diff: []const u8) !?[]const u8 {
+            try self.stdout.print("\n{s}Group {s}:{s}\n", .{ Color.bold, name, Color.reset });
+            for (group_paths) |path| {
+                try self.stdout.print("  {s}\n", .{path});
+            }
+
+            if (diff.len == 0) {
+                try self.stdout.print("{s}No diff for this group, skipping.{s}\n", .{ Color.yellow, Color.reset });
+                return null;
+            }
+
+            const generated = try generateMessage(self.allocator, self.provider, diff, self.context, self.system_prompt, self.max_diff_bytes, self.args.debug, self.stderr);
+            defer self.allocator.free(generated);
+
+            try self.stdout.print("\n{s}Generated commit message:{s}\n{s}{s}{s}\n", .{ Color.bold, Color.reset, Color.cyan, generated, Color.reset });
+
+            const has_secret = !self.args.allow_secrets and try reportSecret(self.stderr, generated);
+            const should_commit = if (has_secret)
+                false
+            else if (self.args.auto_accept)
+                true
+            else
+                try confirmYesNo(self.stdout, self.stderr, "\nCommit this group?", false);
+            if (!should_commit) return null;
+
+            const templated_message = try applySubjectTemplate(self.allocator, self.subject_template, generated);
+            defer self.allocator.free(templated_message);
+            return try commit_msg.appendTrailers(self.allocator, templated_message, self.trailers);
+        }
+    };
+
+    const result = try git.commitGroups(allocator, git.RepoIndex{ .allocator = allocator, .commit_options = commit_options }, &groups, Driver{
+        .allocator = allocator,
+        .provider = provider,
+        .context = context,
+        .system_prompt = system_prompt,
+        .max_diff_bytes = max_diff_bytes,
+        .trailers = trailers,
+        .subject_template = subject_template,
+        .args = args,
+        .stdout = stdout,
+        .stderr = stderr,
+    });
+
+    try stdout.print("\n{s}Committed {d} group(s).{s}\n", .{ Color.green, result.committed, Color.reset });
+    if (result.skipped > 0) {
+        try stdout.print("{s}{d} file(s) from skipped groups left staged.{s}\n", .{ Color.yellow, result.skipped, Color.reset });
+    }
+}
+
+fn lessThanPath(_: void, a: []const u8, b: []const u8) bool {
+    return std.mem.lessThan(u8, a, b);
+}
+
+/// Transcode the message to the repository's i18n.commitEncoding when it is not UTF-8
+/// Unsupported encodings are reported and the message is committed as UTF-8
+/// Caller owns the returned memory
+fn encodeForRepo(allocator: std.mem.Allocator, message: []const u8, debug: bool, stderr: anytype) ![]const u8 {
+    const encoding_name = (git.getCommitEncoding(allocator) catch null) orelse return allocator.dupe(u8, message);
+    defer allocator.free(encoding_name);
+
+    const encoding = commit_msg.parseEncoding(encoding_name) orelse {
+        try stderr.print("{s}Warning: unsupported i18n.commitEncoding '{s}', committing as UTF-8{s}\n", .{ Color.yellow, encoding_name, Color.reset });
+        return allocator.dupe(u8, message);
+    };
+
+    if (debug) {
+        try colors.debug(stderr, "commit_encoding={s}\n", .{@tagName(encoding)});
+    }
+
+    return commit_msg.encodeMessage(allocator, message, encoding) catch |err| switch (err) {
+        error.InvalidUtf8 => return allocator.dupe(u8, message),
+        else => return err,
+    };
+}
+
+/// Staged `--stat` summary when diff_mode asks for one; null when not wanted or git fails
+/// Caller owns the returned memory
+fn stagedDiffStat(allocator: std.mem.Allocator, cfg: *const config.Config, diff: []const u8) ?[]const u8 {
+    if (!cfg.wantsDiffStat(diff.len)) return null;
+    return git.getStagedDiffStat(allocator) catch null;
+}
+
+/// Edit `message` in $GIT_EDITOR/$EDITOR, typing it inline instead when no editor can be run
+/// Returns null when the result is empty (keep the current message). Caller owns the returned memory
+fn editMessage(allocator: std.mem.Allocator, message: []const u8, stdout: anytype, stderr: anytype) !?[]const u8 {
+    const editor = try config.getMessageEditor(allocator);
+    defer allocator.free(editor);
+
+    const content = try std.mem.concat(allocator, u8, &.{ message, commit_msg.EDITOR_HELP });
+    defer allocator.free(content);
+
+    var session = EditorSession{ .allocator = allocator, .editor = editor };
+    commit_msg.withTempMessageFile(allocator, content, &session, EditorSession.run) catch |err| {
+        try stderr.print("{s}Could not run editor '{s}' ({s}).{s}\n", .{ Color.yellow, editor, @errorName(err), Color.reset });
+        try stdout.print("\nType the new message. End with a line containing only \"{s}\" or Ctrl-D; enter nothing to keep the current one.\n", .{commit_msg.EDIT_TERMINATOR});
+        return commit_msg.readEditedMessage(allocator, std.io.getStdIn().reader());
+    };
+    return session.edited;
+}
+
+/// Opens the temp message file in the editor and reads back the result (see editMessage)
+const EditorSession = struct {
+    allocator: std.mem.Allocator,
+    editor: []const u8,
+    edited: ?[]const u8 = null,
+
+    fn run(self: *EditorSession, path: []const u8) anyerror!void {
+        try config.runEditor(self.allocator, self.editor, path);
+        const content = try std.fs.cwd().readFileAlloc(self.allocator, path, 1024 * 1024);
+        defer self.allocator.free(content);
+        self.edited = try commit_msg.stripCommentLines(self.allocator, content);
+    }
+};
+
+/// `--date=<when>` for git commit from --date and commit_timezone; null when neither is set
+/// Exits on a date or timezone autocommit can't resolve
+fn commitDateFlag(allocator: std.mem.Allocator, date: ?[]const u8, timezone: []const u8, stderr: anytype) !?[]const u8 {
+    if (date == null and timezone.len == 0) return null;
+
+    // With only a timezone, the commit is dated now in that zone
+    const resolved = commit_msg.resolveDate(allocator, date orelse "now", timezone, std.time.timestamp()) catch |err| {
+        switch (err) {
+            error.InvalidTimezone => try stderr.print("Invalid commit_timezone '{s}'. Use UTC or an offset such as +05:30.\n", .{timezone}),
+            error.InvalidDate => try stderr.print("Invalid --date '{s}'. Use now, yesterday, \"<n> <unit>s ago\", or a date git accepts.\n", .{date orelse ""}),
+            else => return err,
+        }
+        std.process.exit(1);
+    };
+    defer allocator.free(resolved);
+    return try std.fmt.allocPrint(allocator, "--date={s}", .{resolved});
+}
+
+/// Render subject_template with the staged `--shortstat` totals; an empty template keeps the message
+/// Caller owns the returned memory
+fn applySubjectTemplate(allocator: std.mem.Allocator, template: []const u8, message: []const u8) ![]const u8 {
+    if (template.len == 0) return allocator.dupe(u8, message);
+    const stat = git.getShortStat(allocator) catch git.ShortStat{};
+    return commit_msg.renderSubjectTemplate(allocator, message, template, .{
+        .files_changed = stat.files,
+        .insertions = stat.insertions,
+        .deletions = stat.deletions,
+    });
+}
+
+/// Title and description of the open GitHub pull request for the current branch
+/// Best effort: any failure (no remote, offline, rate limited) just leaves the context out
+fn pullRequestContext(allocator: std.mem.Allocator, cfg: *const config.Config, git_version: git.GitVersion, debug: bool, stderr: anytype) ?[]const u8 {
+    const remote_url = git.getRemoteUrl(allocator, "origin") catch return null;
+    defer allocator.free(remote_url);
+    const branch = git.getCurrentBranch(allocator, git_version) catch return null;
+    defer allocator.free(branch);
+    if (branch.len == 0 or std.mem.eql(u8, branch, "HEAD")) return null;
+
+    const token = pull_request.tokenFromEnv(allocator);
+    defer if (token) |value| allocator.free(value);
+
+    var http = http_client.HttpClient.init(allocator);
+    defer http.deinit();
+    http.anonymize = cfg.anonymize;
+    http.timeout_seconds = cfg.timeout_seconds;
+    http.configureTransport(.{ .proxy = cfg.proxy }) catch return null;
+
+    const context = pull_request.fetchContext(allocator, &http, remote_url, branch, token) catch |err| {
+        if (debug) colors.debug(stderr, "pr_context unavailable: {s}\n", .{@errorName(err)}) catch {};
+        return null;
+    };
+    if (debug) colors.debug(stderr, "pr_context={s}\n", .{if (context != null) "found" else "none"}) catch {};
+    return context;
+}
+
+/// Warn when the message looks like it leaks a credential; returns true if one was found
+fn reportSecret(stderr: anytype, message: []const u8) !bool {
+    const secret = commit_msg.findSecret(message) orelse return false;
+    try stderr.print("{s}The message appears to contain a {s} (line {d}); refusing to commit it. Edit or regenerate the message, or pass --allow-secrets.{s}\n", .{
+        Color.red,
+        secret.kind,
+        std.mem.count(u8, message[0..secret.offset], "\n") + 1,
+        Color.reset,
+    });
+    return true;
+}
+
+/// Stream callback: echo message tokens to stdout as they arrive
+fn printToken(_: ?*anyopaque, token: []const u8) void {
+    std.io.getStdOut().writer().writeAll(token) catch {};
+}
+
+/// Best-effort provenance note on HEAD; never fails the commit
+fn recordNote(allocator: std.mem.Allocator, provider_name: []const u8, model: []const u8, deterministic: bool) !void {
+    const note = try std.fmt.allocPrint(allocator, "autocommit: provider={s} model={s} deterministic={}", .{ provider_name, model, deterministic });
+    defer allocator.free(note);
+    try git.addNote(allocator, "HEAD", note);
+}
+
+fn refreshStatus(allocator: std.mem.Allocator, status: *git.GitStatus, writer: anytype) !bool {
     status.deinit();
     status.* = try git.getStatus(allocator);
     return git.printGitStatus(writer, status);
 }
 
+const CommitAction = enum {
+    commit,
+    regenerate,
+    edit,
+    switch_model,
+    cancel,
+};
+
+/// Ask whether to commit, regenerate, edit, switch model, or cancel
+/// Empty, y, Y commit; r, R regenerate; e, E edit; m, M switch model; anything else (or EOF) cancels
+fn promptCommitAction(stdout: anytype, stderr: anytype) !CommitAction {
+    try stdout.print("\n{s}Proceed with commit?{s} [{s}Y/n/r/e/m{s}] (r = regenerate, e = edit, m = change model) ", .{ Color.bold, Color.reset, Color.green, Color.reset });
+
+    var input_buffer: [10]u8 = undefined;
+    const stdin = std.io.getStdIn().reader();
+    const input = stdin.readUntilDelimiterOrEof(&input_buffer, '\n') catch |err| {
+        try stderr.print("Error reading input: {s}\n", .{@errorName(err)});
+        return .cancel;
+    };
+
+    const line = input orelse return .cancel;
+    const choice = std.mem.trim(u8, line, " \r\t");
+    if (choice.len == 0 or std.mem.eql(u8, choice, "y") or std.mem.eql(u8, choice, "Y")) return .commit;
+    if (std.mem.eql(u8, choice, "r") or std.mem.eql(u8, choice, "R")) return .regenerate;
+    if (std.mem.eql(u8, choice, "e") or std.mem.eql(u8, choice, "E")) return .edit;
+    if (std.mem.eql(u8, choice, "m") or std.mem.eql(u8, choice, "M")) return .switch_model;
+    return .cancel;
+}
+
+/// Generate `count` messages in total, starting with `first`, and ask which one to use
+/// No provider returns several choices per request (registry multi_choice), so each further
+/// candidate is its own request at a higher temperature that avoids the subjects before it
+/// Takes ownership of `first`; the returned message is owned by the caller and the rest are freed
+fn chooseCandidate(
+    allocator: std.mem.Allocator,
+    provider: *llm.Provider,
+    diff: []const u8,
+    context: prompt_builder.Context,
+    system_prompt: []const u8,
+    first: []const u8,
+    count: u32,
+    max_diff_bytes: usize,
+    debug: bool,
+    stdout: anytype,
+    stderr: anytype,
+) ![]const u8 {
+    var candidates = std.ArrayList([]const u8).init(allocator);
+    defer {
+        for (candidates.items) |message| allocator.free(message);
+        candidates.deinit();
+    }
+    candidates.append(first) catch |err| {
+        allocator.free(first);
+        return err;
+    };
+
+    var subjects = std.ArrayList([]const u8).init(allocator);
+    defer subjects.deinit();
+
+    // Streaming several candidates would run them together on the terminal
+    const on_token = provider.on_token;
+    provider.on_token = null;
+    defer {
+        provider.on_token = on_token;
+        provider.temperature = llm.DEFAULT_TEMPERATURE;
+    }
+
+    try stderr.print("{s}Generating {d} more candidates...{s}\n", .{ Color.gray, count - 1, Color.reset });
+    for (1..count) |attempt| {
+        try subjects.append(commit_msg.subjectLine(candidates.items[attempt - 1]));
+        var candidate_context = context;
+        candidate_context.avoid_subjects = subjects.items;
+        provider.temperature = llm.regenerateTemperature(@intCast(attempt));
+
+        const message = try generateOrExit(allocator, provider.*, diff, candidate_context, system_prompt, max_diff_bytes, debug, stderr);
+        candidates.append(message) catch |err| {
+            allocator.free(message);
+            return err;
+        };
+    }
+
+    try stdout.print("\n{s}Candidate commit messages:{s}\n", .{ Color.bold, Color.reset });
+    for (candidates.items, 1..) |message, number| {
+        try stdout.print("\n{s}{d}){s} {s}{s}{s}\n", .{ Color.bold, number, Color.reset, Color.cyan, message, Color.reset });
+    }
+
+    const stdin = std.io.getStdIn().reader();
+    const index = while (true) {
+        try stdout.print("\n{s}Use which message?{s} [{s}1-{d}{s}] (Enter = 1) ", .{ Color.bold, Color.reset, Color.green, candidates.items.len, Color.reset });
+
+        var input_buffer: [10]u8 = undefined;
+        const input = stdin.readUntilDelimiterOrEof(&input_buffer, '\n') catch |err| {
+            try stderr.print("Error reading input: {s}\n", .{@errorName(err)});
+            break 0;
+        };
+        const line = input orelse break 0;
+        if (std.mem.trim(u8, line, " \r\t").len == 0) break 0;
+        if (model_picker.parseSelection(line, candidates.items.len)) |selected| break selected;
+    };
+
+    return candidates.orderedRemove(index);
+}
+
+/// Warn and return true when the index no longer matches `captured` (see git.stagedTreeHash)
+/// A hash that couldn't be taken or re-read counts as unchanged
+fn stagedChangesMoved(allocator: std.mem.Allocator, captured: ?[]const u8, stderr: anytype) !bool {
+    const tree = captured orelse return false;
+    const changed = git.stagedTreeChanged(allocator, tree) catch false;
+    if (changed) {
+        try stderr.print("{s}The staged changes were modified after the message was generated, so it may not describe them.{s}\n", .{ Color.yellow, Color.reset });
+    }
+    return changed;
+}
+
 /// Generic Y/n confirmation prompt
 /// Returns true for yes (empty, y, Y), false for no (n, N, error), and `default_on_eof` on EOF
 fn confirmYesNo(
@@ -296,3 +2162,20 @@ fn confirmYesNo(
 
     return default_on_eof;
 }
+
+/// y/N confirmation for destructive actions: only y or Y proceeds, so Enter, EOF, or a read
+/// error all keep things as they are
+fn confirmDestructive(stdout: anytype, stderr: anytype, prompt: []const u8) !bool {
+    try stdout.print("{s} [y/{s}N{s}] ", .{ prompt, Color.red, Color.reset });
+
+    var input_buffer: [10]u8 = undefined;
+    const stdin = std.io.getStdIn().reader();
+    const input = stdin.readUntilDelimiterOrEof(&input_buffer, '\n') catch |err| {
+        try stderr.print("Error reading input: {s}\n", .{@errorName(err)});
+        return false;
+    };
+
+    const line = input orelse return false;
+    const choice = std.mem.trim(u8, line, " \r\t");
+    return std.mem.eql(u8, choice, "y") or std.mem.eql(u8, choice, "Y");
+}
@@ -0,0 +1,110 @@
+const std = @import("std");
+const config = @import("config.zig");
+const registry = @import("providers/registry.zig");
+
+/// A provider/model pair offered when switching models at the commit prompt
+pub const Choice = struct {
+    provider: *const config.ProviderConfig,
+    model: []const u8,
+};
+
+/// Each configured provider with its configured model first, then the registry models it doesn't use
+/// Providers the registry doesn't list models for offer only their configured model
+/// Caller owns the returned slice; entries borrow from `providers` and the registry
+pub fn collectChoices(allocator: std.mem.Allocator, providers: []const config.ProviderConfig) ![]Choice {
+    var choices = std.ArrayList(Choice).init(allocator);
+    errdefer choices.deinit();
+
+    for (providers) |*provider| {
+        try choices.append(.{ .provider = provider, .model = provider.model });
+        const metadata = registry.getByName(provider.name) orelse continue;
+        for (metadata.models) |model| {
+            if (std.mem.eql(u8, model, provider.model)) continue;
+            try choices.append(.{ .provider = provider, .model = model });
+        }
+    }
+    return choices.toOwnedSlice();
+}
+
+/// Index of a 1-based menu entry; null for empty, non-numeric, or out of range input
+pub fn parseSelection(input: []const u8, count: usize) ?usize {
+    const trimmed = std.mem.trim(u8, input, " \r\t");
+    const number = std.fmt.parseInt(usize, trimmed, 10) catch return null;
+    if (number == 0 or number > count) return null;
+    return number - 1;
+}
+
+/// List the choices, marking the one in use, and read a selection
+/// Returns null to keep the current model (empty or invalid input, or EOF)
+pub fn prompt(
+    reader: anytype,
+    writer: anytype,
+    choices: []const Choice,
+    current_provider: []const u8,
+    current_model: []const u8,
+) !?Choice {
+    try writer.writeAll("\nSwitch model:\n");
+    for (choices, 1..) |choice, number| {
+        const current = std.mem.eql(u8, choice.provider.name, current_provider) and std.mem.eql(u8, choice.model, current_model);
+        try writer.print("  {d}) {s} / {s}{s}\n", .{ number, choice.provider.name, choice.model, if (current) " (current)" else "" });
+    }
+    try writer.writeAll("Select a model (Enter to keep the current one): ");
+
+    var input_buffer: [16]u8 = undefined;
+    const line = (reader.readUntilDelimiterOrEof(&input_buffer, '\n') catch return null) orelse return null;
+    const index = parseSelection(line, choices.len) orelse return null;
+    return choices[index];
+}
+
+// Test section
+const test_providers = [_]config.ProviderConfig{
+    .{ .name = "groq", .model = "llama-3.3-70b-versatile" },
+    .{ .name = "gateway", .model = "house-model" },
+};
+
+test "collectChoices lists the configured model first, then the registry's others" {
+    const choices = try collectChoices(std.testing.allocator, &test_providers);
+    defer std.testing.allocator.free(choices);
+
+    const groq_models = registry.getByName("groq").?.models;
+    // Every registry model once, plus the gateway's single configured model
+    try std.testing.expectEqual(groq_models.len + 1, choices.len);
+    try std.testing.expectEqualStrings("llama-3.3-70b-versatile", choices[0].model);
+    for (choices[1 .. choices.len - 1]) |choice| {
+        try std.testing.expectEqualStrings("groq", choice.provider.name);
+        try std.testing.expect(!std.mem.eql(u8, choice.model, "llama-3.3-70b-versatile"));
+    }
+    try std.testing.expectEqualStrings("gateway", choices[choices.len - 1].provider.name);
+    try std.testing.expectEqualStrings("house-model", choices[choices.len - 1].model);
+}
+
+test "parseSelection accepts 1-based entries in range" {
+    try std.testing.expectEqual(@as(?usize, 0), parseSelection("1", 3));
+    try std.testing.expectEqual(@as(?usize, 2), parseSelection(" 3\r", 3));
+    try std.testing.expect(parseSelection("0", 3) == null);
+    try std.testing.expect(parseSelection("4", 3) == null);
+    try std.testing.expect(parseSelection("", 3) == null);
+    try std.testing.expect(parseSelection("groq", 3) == null);
+}
+
+test "prompt marks the current model and returns the selected choice" {
+    const choices = [_]Choice{
+        .{ .provider = &test_providers[0], .model = "llama-3.3-70b-versatile" },
+        .{ .provider = &test_providers[1], .model = "house-model" },
+    };
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    var input = std.io.fixedBufferStream("2\n");
+    const selected = (try prompt(input.reader(), output.writer(), &choices, "groq", "llama-3.3-70b-versatile")).?;
+    try std.testing.expectEqualStrings("gateway", selected.provider.name);
+    try std.testing.expectEqualStrings("house-model", selected.model);
+    try std.testing.expect(std.mem.indexOf(u8, output.items, "  1) groq / llama-3.3-70b-versatile (current)\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, output.items, "  2) gateway / house-model\n") != null);
+
+    var empty = std.io.fixedBufferStream("\n");
+    try std.testing.expect(try prompt(empty.reader(), output.writer(), &choices, "groq", "llama-3.3-70b-versatile") == null);
+
+    var eof = std.io.fixedBufferStream("");
+    try std.testing.expect(try prompt(eof.reader(), output.writer(), &choices, "groq", "llama-3.3-70b-versatile") == null);
+}
@@ -0,0 +1,586 @@
+const std = @import("std");
+const git = @import("git.zig");
+const config = @import("config.zig");
+const commit_msg = @import("commit_msg.zig");
+
+/// Maximum number of repository commits used as style examples
+pub const MAX_REPO_EXAMPLES = 5;
+
+/// Default number of recent commits included as context (the recent_commits option)
+pub const RECENT_COMMITS_CONTEXT = 5;
+
+/// Maximum bytes of --context-file content sent to the LLM
+pub const MAX_EXTRA_CONTEXT_BYTES = 8 * 1024;
+
+/// Maximum bytes of linter output fed back to the LLM
+const MAX_LINT_FEEDBACK_BYTES = 4 * 1024;
+
+/// Extra context sent to the LLM alongside the diff
+pub const Context = struct {
+    renames: ?*const git.Renames = null,
+    recent_commits: []const git.CommitInfo = &.{},
+    context_format: config.ContextFormat = .subjects,
+    /// `git diff --stat` summary sent ahead of the diff (see config.DiffMode)
+    diff_stat: []const u8 = "",
+    /// Commits whose combined diff is being summarized (--range), oldest first
+    range_commits: []const git.CommitInfo = &.{},
+    /// Message of the commit being rewritten with --amend, as a starting point
+    amend_message: []const u8 = "",
+    /// Subjects the model must not repeat (e.g. a rejected duplicate)
+    avoid_subjects: []const []const u8 = &.{},
+    /// Free-form context from --context-file (e.g. a ticket description)
+    extra_context: []const u8 = "",
+    /// Title and description of the branch's open pull request (see config use_pr_context)
+    pr_context: []const u8 = "",
+    /// Why the previous message failed format validation, asking for a stricter retry
+    format_feedback: []const u8 = "",
+    /// Non-imperative first word of the previous subject (e.g. "added"), asking for a corrected retry
+    mood_feedback: []const u8 = "",
+    /// Output of linter_command for the previous message, asking for a retry that satisfies it
+    lint_feedback: []const u8 = "",
+
+    /// Extra context as it is sent, capped at MAX_EXTRA_CONTEXT_BYTES
+    pub fn cappedExtraContext(self: Context) []const u8 {
+        const trimmed = std.mem.trim(u8, self.extra_context, " \n\r\t");
+        return trimmed[0..@min(trimmed.len, MAX_EXTRA_CONTEXT_BYTES)];
+    }
+};
+
+/// Build the user message sent to the LLM from the diff and any extra context
+/// Caller owns the returned memory and must free it
+pub fn buildUserContent(allocator: std.mem.Allocator, diff: []const u8, context: Context) ![]const u8 {
+    var content = std.ArrayList(u8).init(allocator);
+    errdefer content.deinit();
+    const writer = content.writer();
+
+    if (context.renames) |renames| {
+        if (renames.entries.len > 0) {
+            try writer.writeAll("Renamed files:\n");
+            for (renames.entries) |entry| {
+                try writer.print("- {s} -> {s}\n", .{ entry.from, entry.to });
+            }
+            if (renames.isRenameDominated()) {
+                try writer.writeAll("This change is mostly file renames/moves. Prefer a refactor or chore type, e.g. \"refactor: move X to Y\".\n");
+            }
+            try writer.writeAll("\n");
+        }
+    }
+
+    const diff_stat = std.mem.trim(u8, context.diff_stat, "\n");
+    if (diff_stat.len > 0) {
+        try writer.print("Changed files (git diff --stat):\n{s}\n\n", .{diff_stat});
+    }
+
+    if (context.recent_commits.len > 0) {
+        try writer.writeAll("Recent commits (for style reference only):\n");
+        for (context.recent_commits) |commit_info| {
+            try writer.print("- {s}\n", .{commit_info.subject});
+            if (context.context_format == .full and commit_info.body.len > 0) {
+                var body_lines = std.mem.splitScalar(u8, commit_info.body, '\n');
+                while (body_lines.next()) |line| {
+                    try writer.print("  {s}\n", .{line});
+                }
+            }
+        }
+        try writer.writeAll("\n");
+    }
+
+    if (context.amend_message.len > 0) {
+        try writer.print("This amends a commit whose current message is below; keep what still applies and update it to describe the full diff:\n{s}\n\n", .{context.amend_message});
+    }
+
+    if (context.range_commits.len > 0) {
+        try writer.writeAll("This diff combines these commits; summarize them as one change:\n");
+        for (context.range_commits) |commit_info| {
+            try writer.print("- {s}\n", .{commit_info.subject});
+        }
+        try writer.writeAll("\n");
+    }
+
+    if (context.avoid_subjects.len > 0) {
+        try writer.writeAll("Do not reuse these subjects; write a distinct message that describes this diff:\n");
+        for (context.avoid_subjects) |subject| {
+            try writer.print("- {s}\n", .{subject});
+        }
+        try writer.writeAll("\n");
+    }
+
+    if (context.format_feedback.len > 0) {
+        try writer.print("Your previous message was rejected because {s}. The first line must be exactly <type>(<scope>): <subject> using an allowed type, kept short.\n\n", .{context.format_feedback});
+    }
+
+    if (context.mood_feedback.len > 0) {
+        try writer.print("Your previous subject started with \"{s}\". Write the subject in the imperative mood, e.g. \"add\" rather than \"added\" or \"adds\".\n\n", .{context.mood_feedback});
+    }
+
+    const lint_feedback = std.mem.trim(u8, context.lint_feedback, " \n\r\t");
+    if (lint_feedback.len > 0) {
+        try writer.print("The commit linter rejected your previous message with:\n{s}\nWrite a message that passes it.\n\n", .{lint_feedback[0..@min(lint_feedback.len, MAX_LINT_FEEDBACK_BYTES)]});
+    }
+
+    const extra_context = context.cappedExtraContext();
+    if (extra_context.len > 0) {
+        try writer.print("Additional context:\n{s}\n\n", .{extra_context});
+    }
+
+    const pr_context = std.mem.trim(u8, context.pr_context, " \n\r\t");
+    if (pr_context.len > 0) {
+        try writer.print("Pull request for this branch (keep the message consistent with its intent):\n{s}\n\n", .{pr_context});
+    }
+
+    try writer.print("Git diff:\n{s}", .{diff});
+
+    return content.toOwnedSlice();
+}
+
+/// Rough token estimate for diff-like text (about 4 bytes per token)
+pub fn estimateTokens(byte_len: usize) usize {
+    return (byte_len + 3) / 4;
+}
+
+/// Tell the user the message was generated from a truncated diff
+pub fn writeTruncationWarning(writer: anytype, original_bytes: usize, sent_bytes: usize) !void {
+    try writer.print("Warning: diff truncated to fit max_diff_bytes (~{d} of ~{d} tokens sent); the message is based on partial changes.\n", .{
+        estimateTokens(sent_bytes),
+        estimateTokens(original_bytes),
+    });
+}
+
+/// Check if the generated subject exactly matches one of the recent commit subjects
+pub fn isDuplicateSubject(message: []const u8, commits: []const git.CommitInfo) bool {
+    const subject = commit_msg.subjectLine(message);
+    if (subject.len == 0) return false;
+    for (commits) |commit_info| {
+        if (std.mem.eql(u8, subject, commit_info.subject)) return true;
+    }
+    return false;
+}
+
+/// Extra, per-run content appended to the system prompt
+pub const SystemPromptExtras = struct {
+    /// Conventional subjects from the repository's own history
+    repo_examples: []const []const u8 = &.{},
+};
+
+/// Select up to MAX_REPO_EXAMPLES conventional-format subjects to use as prompt examples
+/// Caller owns the returned slice; items borrow from `commits`
+pub fn selectRepoExamples(allocator: std.mem.Allocator, commits: []const git.CommitInfo) ![]const []const u8 {
+    var examples = std.ArrayList([]const u8).init(allocator);
+    errdefer examples.deinit();
+
+    for (commits) |commit_info| {
+        if (examples.items.len >= MAX_REPO_EXAMPLES) break;
+        if (commit_msg.isConventionalSubject(commit_info.subject)) {
+            try examples.append(commit_info.subject);
+        }
+    }
+
+    return examples.toOwnedSlice();
+}
+
+/// Subject guidance used with include_body when subject_prompt is empty
+pub const DEFAULT_SUBJECT_PROMPT =
+    \\- Summarize what the change does in the imperative mood, with no trailing period
+;
+
+/// Body and footer guidance used with include_body when body_prompt is empty
+pub const DEFAULT_BODY_PROMPT =
+    \\- Always add a body after a blank line, explaining why the change was made
+    \\- Add footers after another blank line when they apply, e.g. "BREAKING CHANGE: <description>" or "Closes #123"
+    \\- Only reference issues that appear in the diff or context; never invent issue numbers
+;
+
+/// Write a titled prompt section, one "      - " item per non-empty line of `fragment`
+/// Lines may already start with "- "; plain lines become items too
+fn writePromptSection(writer: anytype, title: []const u8, fragment: []const u8) !void {
+    try writer.print("\n\n  {s}:\n", .{title});
+    var lines = std.mem.splitScalar(u8, fragment, '\n');
+    while (lines.next()) |line| {
+        const trimmed = std.mem.trim(u8, line, " \t\r");
+        if (trimmed.len == 0) continue;
+        const item = if (std.mem.startsWith(u8, trimmed, "- ")) trimmed[2..] else trimmed;
+        try writer.print("      - {s}\n", .{item});
+    }
+}
+
+/// Names for common language codes, used in the language instruction
+const LANGUAGE_NAMES = [_]struct { []const u8, []const u8 }{
+    .{ "de", "German" },
+    .{ "es", "Spanish" },
+    .{ "fr", "French" },
+    .{ "it", "Italian" },
+    .{ "ja", "Japanese" },
+    .{ "ko", "Korean" },
+    .{ "nl", "Dutch" },
+    .{ "pl", "Polish" },
+    .{ "pt", "Portuguese" },
+    .{ "ru", "Russian" },
+    .{ "sv", "Swedish" },
+    .{ "tr", "Turkish" },
+    .{ "uk", "Ukrainian" },
+    .{ "zh", "Chinese" },
+};
+
+/// Language named by the `language` option, or null for English (the prompt's own language)
+/// Known codes map to a name; anything else (e.g. "Brazilian Portuguese") is used as written
+pub fn languageName(language: []const u8) ?[]const u8 {
+    const trimmed = std.mem.trim(u8, language, " \t");
+    if (trimmed.len == 0 or std.ascii.eqlIgnoreCase(trimmed, "english")) return null;
+
+    // Region subtags such as en-GB or pt_BR select the same language
+    const code_end = std.mem.indexOfAny(u8, trimmed, "-_") orelse trimmed.len;
+    const code = trimmed[0..code_end];
+    if (std.ascii.eqlIgnoreCase(code, "en")) return null;
+    for (LANGUAGE_NAMES) |entry| {
+        if (std.ascii.eqlIgnoreCase(code, entry[0])) return entry[1];
+    }
+    return trimmed;
+}
+
+/// Assemble the system prompt from the configured prompt, message limits, and extras
+/// Caller owns the returned memory and must free it
+pub fn buildSystemPrompt(allocator: std.mem.Allocator, cfg: *const config.Config, extras: SystemPromptExtras) ![]const u8 {
+    var system_prompt = std.ArrayList(u8).init(allocator);
+    errdefer system_prompt.deinit();
+    const writer = system_prompt.writer();
+
+    try writer.writeAll(cfg.system_prompt);
+
+    if (extras.repo_examples.len > 0) {
+        try writer.writeAll("\n\n  Examples from this repository (match their style):\n");
+        for (extras.repo_examples) |example| {
+            try writer.print("      - {s}\n", .{example});
+        }
+    }
+
+    // Subject and body guidance are separate so either can be tuned on its own
+    if (cfg.include_body) {
+        try writePromptSection(writer, "Subject", if (cfg.subject_prompt.len > 0) cfg.subject_prompt else DEFAULT_SUBJECT_PROMPT);
+        try writePromptSection(writer, "Body and footers", if (cfg.body_prompt.len > 0) cfg.body_prompt else DEFAULT_BODY_PROMPT);
+    }
+
+    if (languageName(cfg.language)) |language| {
+        try writer.print(
+            \\
+            \\
+            \\  Language:
+            \\      - Write the subject and body in {s}
+            \\      - Keep the type (feat, fix, docs, ...) and footer keywords such as "BREAKING CHANGE:" in English
+            \\
+        , .{language});
+    }
+
+    if (cfg.max_subject_length > 0 or cfg.max_body_line_length > 0) {
+        try writer.writeAll("\n\n  Length limits:\n");
+        if (cfg.max_subject_length > 0) {
+            try writer.print("      - Subject must be at most {d} characters\n", .{cfg.max_subject_length});
+        }
+        if (cfg.max_body_line_length > 0) {
+            try writer.print("      - Body lines must be at most {d} characters\n", .{cfg.max_body_line_length});
+        }
+    }
+
+    return system_prompt.toOwnedSlice();
+}
+
+test "buildUserContent lists recent commit subjects" {
+    const commits = [_]git.CommitInfo{
+        .{ .subject = "feat: add login", .body = "- Add form" },
+        .{ .subject = "fix: typo" },
+    };
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .recent_commits = &commits });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "Recent commits") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "- feat: add login\n- fix: typo\n") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "Add form") == null);
+}
+
+test "buildUserContent full context format includes bodies" {
+    const commits = [_]git.CommitInfo{
+        .{ .subject = "feat: add login", .body = "- Add form\n- Add validation" },
+        .{ .subject = "fix: typo" },
+    };
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .recent_commits = &commits, .context_format = .full });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "- feat: add login\n  - Add form\n  - Add validation\n- fix: typo\n") != null);
+}
+
+test "isDuplicateSubject matches recent subjects exactly" {
+    const commits = [_]git.CommitInfo{
+        .{ .subject = "feat: add login" },
+        .{ .subject = "fix: typo" },
+    };
+
+    try std.testing.expect(isDuplicateSubject("feat: add login", &commits));
+    try std.testing.expect(isDuplicateSubject("fix: typo\n\n- body", &commits));
+    try std.testing.expect(!isDuplicateSubject("feat: add logout", &commits));
+    try std.testing.expect(!isDuplicateSubject("feat: add login", &.{}));
+}
+
+test "buildUserContent asks for a distinct subject on regenerate" {
+    const avoid = [_][]const u8{"feat: add login"};
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .avoid_subjects = &avoid });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "Do not reuse these subjects") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "- feat: add login\n") != null);
+}
+
+fn testConfig() config.Config {
+    return .{
+        .default_provider = "groq",
+        .system_prompt = "Base prompt",
+        .providers = &.{},
+    };
+}
+
+// Test section
+test "buildUserContent includes additional context" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .extra_context = "Ticket: add retry support\n" });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expectEqualStrings("Additional context:\nTicket: add retry support\n\nGit diff:\ndiff", content);
+}
+
+test "buildUserContent includes the pull request context" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .pr_context = "#42 Add retry support\n\nRetries 5xx errors." });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expectEqualStrings("Pull request for this branch (keep the message consistent with its intent):\n#42 Add retry support\n\nRetries 5xx errors.\n\nGit diff:\ndiff", content);
+}
+
+test "buildUserContent caps additional context" {
+    const large = [_]u8{'x'} ** (MAX_EXTRA_CONTEXT_BYTES + 100);
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .extra_context = &large });
+    defer std.testing.allocator.free(content);
+
+    const header = "Additional context:\n";
+    try std.testing.expectEqual(header.len + MAX_EXTRA_CONTEXT_BYTES + "\n\nGit diff:\ndiff".len, content.len);
+}
+
+test "estimateTokens rounds up" {
+    try std.testing.expectEqual(@as(usize, 0), estimateTokens(0));
+    try std.testing.expectEqual(@as(usize, 1), estimateTokens(3));
+    try std.testing.expectEqual(@as(usize, 25_600), estimateTokens(100 * 1024));
+}
+
+test "writeTruncationWarning reports token estimates" {
+    var buf: [256]u8 = undefined;
+    var stream = std.io.fixedBufferStream(&buf);
+    try writeTruncationWarning(stream.writer(), 400_000, 100_000);
+
+    const output = stream.getWritten();
+    try std.testing.expect(std.mem.startsWith(u8, output, "Warning: diff truncated"));
+    try std.testing.expect(std.mem.indexOf(u8, output, "~25000 of ~100000 tokens sent") != null);
+}
+
+test "buildUserContent explains a format rejection" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .format_feedback = "the subject line is too long" });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.startsWith(u8, content, "Your previous message was rejected because the subject line is too long."));
+    try std.testing.expect(std.mem.endsWith(u8, content, "Git diff:\ndiff"));
+}
+
+test "buildUserContent asks for the imperative mood" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .mood_feedback = "added" });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.startsWith(u8, content, "Your previous subject started with \"added\"."));
+    try std.testing.expect(std.mem.endsWith(u8, content, "Git diff:\ndiff"));
+}
+
+test "buildUserContent feeds linter errors back" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .lint_feedback = "✖ subject may not be empty [subject-empty]\n" });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.startsWith(u8, content, "The commit linter rejected your previous message with:\n✖ subject may not be empty [subject-empty]\nWrite a message that passes it."));
+    try std.testing.expect(std.mem.endsWith(u8, content, "Git diff:\ndiff"));
+}
+
+test "buildUserContent lists the commits of a range" {
+    const commits = [_]git.CommitInfo{
+        .{ .subject = "wip" },
+        .{ .subject = "fix tests" },
+    };
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .range_commits = &commits });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.startsWith(u8, content, "This diff combines these commits; summarize them as one change:\n- wip\n- fix tests\n\n"));
+}
+
+test "buildUserContent includes the message being amended" {
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .amend_message = "feat: add login\n\n- Add form" });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "current message is below") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "feat: add login\n\n- Add form\n\nGit diff:\ndiff") != null);
+}
+
+test "buildUserContent includes the diff stat summary" {
+    const stat = " src/main.zig | 12 ++++++++----\n 1 file changed, 8 insertions(+), 4 deletions(-)\n";
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .diff_stat = stat });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expectEqualStrings("Changed files (git diff --stat):\n src/main.zig | 12 ++++++++----\n 1 file changed, 8 insertions(+), 4 deletions(-)\n\nGit diff:\ndiff", content);
+}
+
+test "buildUserContent with diff only" {
+    const content = try buildUserContent(std.testing.allocator, "diff --git a/x b/x", .{});
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expectEqualStrings("Git diff:\ndiff --git a/x b/x", content);
+}
+
+test "buildUserContent includes renames and refactor hint" {
+    var renames = try git.Renames.parseNameStatus(std.testing.allocator, "R100\tsrc/old.zig\tsrc/new.zig\n");
+    defer renames.deinit();
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .renames = &renames });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "- src/old.zig -> src/new.zig") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "refactor: move X to Y") != null);
+    try std.testing.expect(std.mem.endsWith(u8, content, "Git diff:\ndiff"));
+}
+
+test "buildUserContent omits hint when renames are a minority" {
+    var renames = try git.Renames.parseNameStatus(std.testing.allocator, "R100\ta.zig\tb.zig\nM\tc.zig\nM\td.zig\n");
+    defer renames.deinit();
+
+    const content = try buildUserContent(std.testing.allocator, "diff", .{ .renames = &renames });
+    defer std.testing.allocator.free(content);
+
+    try std.testing.expect(std.mem.indexOf(u8, content, "- a.zig -> b.zig") != null);
+    try std.testing.expect(std.mem.indexOf(u8, content, "refactor: move X to Y") == null);
+}
+
+test "buildSystemPrompt without limits returns base prompt" {
+    const cfg = testConfig();
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expectEqualStrings("Base prompt", system_prompt);
+}
+
+test "buildSystemPrompt injects configured limits" {
+    var cfg = testConfig();
+    cfg.max_subject_length = 50;
+    cfg.max_body_line_length = 72;
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expect(std.mem.startsWith(u8, system_prompt, "Base prompt"));
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "Subject must be at most 50 characters") != null);
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "Body lines must be at most 72 characters") != null);
+}
+
+test "buildSystemPrompt asks for body and footers when include_body is set" {
+    var cfg = testConfig();
+    cfg.include_body = true;
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expect(std.mem.startsWith(u8, system_prompt, "Base prompt\n\n  Subject:\n      - Summarize what the change does"));
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "\n\n  Body and footers:\n      - Always add a body") != null);
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "BREAKING CHANGE:") != null);
+}
+
+test "buildSystemPrompt composes custom subject and body prompts" {
+    var cfg = testConfig();
+    cfg.include_body = true;
+    cfg.subject_prompt = "Name the affected component first";
+    cfg.body_prompt = "- Explain the why, not the what\n\n  - Mention follow-up work\n";
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expectEqualStrings(
+        "Base prompt\n\n  Subject:\n      - Name the affected component first\n" ++
+            "\n\n  Body and footers:\n      - Explain the why, not the what\n      - Mention follow-up work\n",
+        system_prompt,
+    );
+
+    // Without include_body neither fragment is sent
+    cfg.include_body = false;
+    const subject_only = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(subject_only);
+    try std.testing.expectEqualStrings("Base prompt", subject_only);
+}
+
+test "buildSystemPrompt asks for the configured language" {
+    var cfg = testConfig();
+    cfg.language = "es";
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expect(std.mem.startsWith(u8, system_prompt, "Base prompt\n\n  Language:\n      - Write the subject and body in Spanish\n"));
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "(feat, fix, docs, ...)") != null);
+}
+
+test "languageName maps codes and treats English as the default" {
+    try std.testing.expect(languageName("en") == null);
+    try std.testing.expect(languageName("en-GB") == null);
+    try std.testing.expect(languageName("English") == null);
+    try std.testing.expect(languageName("") == null);
+    try std.testing.expectEqualStrings("Portuguese", languageName("pt_BR").?);
+    try std.testing.expectEqualStrings("German", languageName("DE").?);
+    try std.testing.expectEqualStrings("Brazilian Portuguese", languageName(" Brazilian Portuguese ").?);
+}
+
+test "buildSystemPrompt injects subject limit only" {
+    var cfg = testConfig();
+    cfg.max_subject_length = 60;
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{});
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "Subject must be at most 60 characters") != null);
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "Body lines") == null);
+}
+
+test "selectRepoExamples keeps only conventional subjects" {
+    const commits = [_]git.CommitInfo{
+        .{ .subject = "feat(api): add pagination" },
+        .{ .subject = "WIP" },
+        .{ .subject = "Merge branch 'main'" },
+        .{ .subject = "fix: handle empty diff" },
+    };
+
+    const examples = try selectRepoExamples(std.testing.allocator, &commits);
+    defer std.testing.allocator.free(examples);
+
+    try std.testing.expectEqual(@as(usize, 2), examples.len);
+    try std.testing.expectEqualStrings("feat(api): add pagination", examples[0]);
+    try std.testing.expectEqualStrings("fix: handle empty diff", examples[1]);
+}
+
+test "selectRepoExamples caps the number of examples" {
+    const commits = [_]git.CommitInfo{.{ .subject = "chore: tidy" }} ** (MAX_REPO_EXAMPLES + 3);
+
+    const examples = try selectRepoExamples(std.testing.allocator, &commits);
+    defer std.testing.allocator.free(examples);
+
+    try std.testing.expectEqual(@as(usize, MAX_REPO_EXAMPLES), examples.len);
+}
+
+test "buildSystemPrompt injects repository examples" {
+    const cfg = testConfig();
+    const examples = [_][]const u8{ "feat(api): add pagination", "fix: handle empty diff" };
+
+    const system_prompt = try buildSystemPrompt(std.testing.allocator, &cfg, .{ .repo_examples = &examples });
+    defer std.testing.allocator.free(system_prompt);
+
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "Examples from this repository") != null);
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "- feat(api): add pagination") != null);
+    try std.testing.expect(std.mem.indexOf(u8, system_prompt, "- fix: handle empty diff") != null);
+}
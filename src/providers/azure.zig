@@ -0,0 +1,102 @@
+const std = @import("std");
+const llm = @import("../llm.zig");
+const openai_compat = @import("openai_compat.zig");
+
+/// api-version sent when azure_api_version is not set
+pub const DEFAULT_API_VERSION = "2024-06-01";
+
+pub const metadata = .{
+    .name = "azure",
+    .display_name = "Azure OpenAI",
+    .default_model = "gpt-4o-mini",
+    .models = &[_][]const u8{"gpt-4o-mini"},
+    // {resource} and {deployment} come from azure_resource and azure_deployment
+    .endpoint = "https://{resource}.openai.azure.com/openai/deployments/{deployment}/chat/completions",
+    .api_key_placeholder = "paste-key-here",
+    .requires_api_key = true,
+    .capabilities = .{ .streaming = true, .tools = true },
+    .system_prompt_placement = .system_role,
+};
+
+pub const vtable = blk: {
+    var table = openai_compat.makeVTable();
+    table.getAuthHeader = getAuthHeader;
+    table.buildEndpoint = buildEndpoint;
+    table.auth_header_name = "api-key";
+    break :blk table;
+};
+
+/// The configured endpoint with {resource} and {deployment} filled in and the api-version
+/// appended (unless the endpoint already carries one)
+/// The deployment defaults to the model, which is how most Azure deployments are named
+pub fn buildEndpoint(provider: llm.Provider) ![]const u8 {
+    const allocator = provider.allocator;
+    const settings = provider.config;
+    const deployment = if (settings.azure_deployment.len > 0) settings.azure_deployment else settings.model;
+    const api_version = if (settings.azure_api_version.len > 0) settings.azure_api_version else DEFAULT_API_VERSION;
+
+    const with_resource = try std.mem.replaceOwned(u8, allocator, settings.endpointOrDefault(), "{resource}", settings.azure_resource);
+    defer allocator.free(with_resource);
+    const url = try std.mem.replaceOwned(u8, allocator, with_resource, "{deployment}", deployment);
+
+    if (std.mem.indexOf(u8, url, "api-version=") != null) return url;
+    defer allocator.free(url);
+    const separator: u8 = if (std.mem.indexOfScalar(u8, url, '?') != null) '&' else '?';
+    return std.fmt.allocPrint(allocator, "{s}{c}api-version={s}", .{ url, separator, api_version });
+}
+
+/// The bare key, sent in the api-key header rather than as a bearer token
+pub fn getAuthHeader(provider: llm.Provider) ![]const u8 {
+    return provider.allocator.dupe(u8, provider.config.api_key);
+}
+
+// Test section
+fn testProvider() llm.Provider {
+    return .{
+        .name = "azure",
+        .config = .{
+            .name = "azure",
+            .api_key = "azure-key",
+            .model = "gpt-4o-mini",
+            .endpoint = metadata.endpoint,
+            .azure_resource = "contoso",
+        },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+}
+
+test "buildEndpoint fills in the resource, deployment, and api-version" {
+    var provider = testProvider();
+
+    const url = try buildEndpoint(provider);
+    defer std.testing.allocator.free(url);
+    try std.testing.expectEqualStrings("https://contoso.openai.azure.com/openai/deployments/gpt-4o-mini/chat/completions?api-version=2024-06-01", url);
+
+    provider.config.azure_deployment = "commit-writer";
+    provider.config.azure_api_version = "2024-10-21";
+    const named = try buildEndpoint(provider);
+    defer std.testing.allocator.free(named);
+    try std.testing.expectEqualStrings("https://contoso.openai.azure.com/openai/deployments/commit-writer/chat/completions?api-version=2024-10-21", named);
+
+    provider.config.endpoint = "https://gateway.corp/openai/deployments/gpt-4o/chat/completions?api-version=2024-02-01";
+    const custom = try buildEndpoint(provider);
+    defer std.testing.allocator.free(custom);
+    try std.testing.expectEqualStrings(provider.config.endpoint, custom);
+}
+
+test "getAuthHeader sends the bare key" {
+    const header = try getAuthHeader(testProvider());
+    defer std.testing.allocator.free(header);
+    try std.testing.expectEqualStrings("azure-key", header);
+}
+
+test "parseResponse maps Azure error codes" {
+    const provider = testProvider();
+    try std.testing.expectError(llm.LlmError.InvalidApiKey, openai_compat.parseResponse(provider, "{\"error\":{\"code\":\"401\",\"message\":\"Access denied due to invalid subscription key or wrong API endpoint.\"}}"));
+    try std.testing.expectError(llm.LlmError.RateLimited, openai_compat.parseResponse(provider, "{\"error\":{\"code\":\"429\",\"message\":\"Requests to the ChatCompletions_Create Operation have exceeded call rate limit.\"}}"));
+    try std.testing.expectError(llm.LlmError.ModelNotFound, openai_compat.parseResponse(provider, "{\"error\":{\"code\":\"DeploymentNotFound\",\"message\":\"The API deployment for this resource does not exist.\"}}"));
+}
@@ -0,0 +1,296 @@
+const std = @import("std");
+const llm = @import("../llm.zig");
+const openai_compat = @import("openai_compat.zig");
+
+pub const metadata = .{
+    .name = "gemini",
+    .display_name = "Google Gemini",
+    .default_model = "gemini-1.5-flash",
+    .models = &[_][]const u8{ "gemini-1.5-flash", "gemini-1.5-pro" },
+    // {model} is filled in per request; the key travels as ?key= rather than a bearer header
+    .endpoint = "https://generativelanguage.googleapis.com/v1beta/models/{model}:generateContent",
+    .api_key_placeholder = "paste-key-here",
+    .requires_api_key = true,
+    .capabilities = .{ .streaming = true },
+    .system_prompt_placement = .top_level,
+};
+
+pub const vtable = llm.Provider.VTable{
+    .buildRequest = buildRequest,
+    .parseResponse = parseResponse,
+    .getEndpoint = openai_compat.getEndpoint,
+    .getAuthHeader = getAuthHeader,
+    .appendStreamLine = appendStreamLine,
+    .buildEndpoint = buildEndpoint,
+};
+
+const Part = struct {
+    text: []const u8 = "",
+    /// Set on thinking-model parts that carry reasoning rather than the answer
+    thought: ?bool = null,
+};
+
+const Content = struct {
+    role: ?[]const u8 = null,
+    parts: []const Part = &.{},
+};
+
+const GenerationConfig = struct {
+    temperature: f32,
+    maxOutputTokens: u32,
+};
+
+const GenerateRequest = struct {
+    systemInstruction: ?Content = null,
+    contents: []const Content,
+    generationConfig: GenerationConfig,
+};
+
+const Candidate = struct {
+    content: ?Content = null,
+    finishReason: ?[]const u8 = null,
+};
+
+const UsageMetadata = struct {
+    promptTokenCount: u64 = 0,
+    candidatesTokenCount: u64 = 0,
+    totalTokenCount: u64 = 0,
+};
+
+const ErrorBody = struct {
+    code: i64 = 0,
+    message: []const u8 = "",
+    status: []const u8 = "",
+};
+
+/// A generateContent response, or one chunk of a streamed one
+const GenerateResponse = struct {
+    candidates: []const Candidate = &.{},
+    usageMetadata: ?UsageMetadata = null,
+    @"error": ?ErrorBody = null,
+};
+
+/// Build a generateContent request; the system prompt becomes systemInstruction unless
+/// the placement asks for it to be prepended to the user message
+pub fn buildRequest(provider: llm.Provider, user_content: []const u8, prompt: []const u8) ![]const u8 {
+    const allocator = provider.allocator;
+    const prefix_prompt = provider.config.systemPromptPlacement() == .user_prefix;
+
+    const merged = if (prefix_prompt) try std.mem.concat(allocator, u8, &.{ prompt, "\n\n", user_content }) else null;
+    defer if (merged) |text| allocator.free(text);
+
+    const system_parts = [_]Part{.{ .text = prompt }};
+    const user_parts = [_]Part{.{ .text = merged orelse user_content }};
+    const contents = [_]Content{.{ .role = "user", .parts = &user_parts }};
+
+    const request = GenerateRequest{
+        .systemInstruction = if (prefix_prompt) null else .{ .parts = &system_parts },
+        .contents = &contents,
+        .generationConfig = .{ .temperature = provider.temperature, .maxOutputTokens = 1000 },
+    };
+
+    return std.json.stringifyAlloc(allocator, request, .{
+        .emit_null_optional_fields = false,
+    });
+}
+
+/// The configured endpoint with {model} filled in, switched to the SSE method when streaming,
+/// and the API key appended as a query parameter
+pub fn buildEndpoint(provider: llm.Provider) ![]const u8 {
+    const allocator = provider.allocator;
+
+    const with_model = try std.mem.replaceOwned(u8, allocator, provider.config.endpointOrDefault(), "{model}", provider.config.model);
+    defer allocator.free(with_model);
+
+    const url = if (provider.stream)
+        try std.mem.replaceOwned(u8, allocator, with_model, ":generateContent", ":streamGenerateContent?alt=sse")
+    else
+        try allocator.dupe(u8, with_model);
+    defer allocator.free(url);
+
+    const separator: u8 = if (std.mem.indexOfScalar(u8, url, '?') != null) '&' else '?';
+    return std.fmt.allocPrint(allocator, "{s}{c}key={s}", .{ url, separator, provider.config.api_key });
+}
+
+/// The key goes in the URL (see buildEndpoint), so no Authorization header is sent
+pub fn getAuthHeader(provider: llm.Provider) ![]const u8 {
+    return provider.allocator.dupe(u8, "");
+}
+
+pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError![]const u8 {
+    var parsed = std.json.parseFromSlice(GenerateResponse, provider.allocator, response, .{
+        .ignore_unknown_fields = true,
+    }) catch return llm.LlmError.InvalidResponse;
+    defer parsed.deinit();
+
+    const reply = parsed.value;
+    if (reply.@"error") |api_error| return mapError(api_error);
+
+    recordUsageMetadata(provider, reply);
+
+    // A prompt blocked by safety filters comes back with promptFeedback and no candidates
+    if (reply.candidates.len == 0) return llm.LlmError.EmptyContent;
+
+    var text = std.ArrayList(u8).init(provider.allocator);
+    defer text.deinit();
+    try appendCandidateText(reply.candidates[0], &text);
+
+    return provider.finalizeContent(text.items);
+}
+
+/// Append the text from one SSE line (`data: {...}`) of a streamGenerateContent?alt=sse response
+pub fn appendStreamLine(provider: llm.Provider, line: []const u8, out: *std.ArrayList(u8)) llm.LlmError!bool {
+    const trimmed_line = std.mem.trim(u8, line, " \r\t");
+    if (trimmed_line.len == 0 or trimmed_line[0] == ':') return true;
+
+    const prefix = "data:";
+    if (!std.mem.startsWith(u8, trimmed_line, prefix)) return false;
+
+    const data = std.mem.trim(u8, trimmed_line[prefix.len..], " ");
+    if (data.len == 0) return true;
+
+    var parsed = std.json.parseFromSlice(GenerateResponse, provider.allocator, data, .{
+        .ignore_unknown_fields = true,
+    }) catch return llm.LlmError.InvalidResponse;
+    defer parsed.deinit();
+
+    const chunk = parsed.value;
+    if (chunk.@"error") |api_error| return mapError(api_error);
+    // Each chunk carries the running counts, so the last one recorded is the total
+    recordUsageMetadata(provider, chunk);
+    if (chunk.candidates.len == 0) return true;
+
+    try appendCandidateText(chunk.candidates[0], out);
+    return true;
+}
+
+fn recordUsageMetadata(provider: llm.Provider, reply: GenerateResponse) void {
+    const usage = reply.usageMetadata orelse return;
+    provider.recordUsage(.{
+        .prompt_tokens = usage.promptTokenCount,
+        .completion_tokens = usage.candidatesTokenCount,
+        .total_tokens = if (usage.totalTokenCount > 0) usage.totalTokenCount else usage.promptTokenCount + usage.candidatesTokenCount,
+    });
+}
+
+/// Gemini may split a reply across parts; thought parts are reasoning, not the message
+fn appendCandidateText(candidate: Candidate, out: *std.ArrayList(u8)) llm.LlmError!void {
+    const content = candidate.content orelse return;
+    for (content.parts) |part| {
+        if (part.thought orelse false) continue;
+        out.appendSlice(part.text) catch return llm.LlmError.OutOfMemory;
+    }
+}
+
+/// Map a Google API error ({"code": 400, "message": ..., "status": "INVALID_ARGUMENT"})
+fn mapError(api_error: ErrorBody) llm.LlmError {
+    if (api_error.code == 429 or std.mem.eql(u8, api_error.status, "RESOURCE_EXHAUSTED")) {
+        return llm.LlmError.RateLimited;
+    }
+    // An invalid key is reported as a 400 INVALID_ARGUMENT, so the message is checked too
+    if (api_error.code == 401 or api_error.code == 403 or
+        std.mem.eql(u8, api_error.status, "UNAUTHENTICATED") or
+        std.mem.eql(u8, api_error.status, "PERMISSION_DENIED") or
+        std.ascii.indexOfIgnoreCase(api_error.message, "API key not valid") != null)
+    {
+        return llm.LlmError.InvalidApiKey;
+    }
+    // e.g. "The input token count (1200000) exceeds the maximum number of tokens allowed (1048576)."
+    if (llm.isContextLengthMessage(api_error.message) or
+        std.ascii.indexOfIgnoreCase(api_error.message, "exceeds the maximum number of tokens") != null)
+    {
+        return llm.LlmError.ContextLengthExceeded;
+    }
+    if (api_error.code == 404 or llm.isModelNotFoundMessage(api_error.message)) {
+        return llm.LlmError.ModelNotFound;
+    }
+    if (api_error.code >= 500) return llm.LlmError.ServerError;
+    return llm.LlmError.ApiError;
+}
+
+// Test section
+fn testProvider() llm.Provider {
+    return .{
+        .name = "gemini",
+        .config = .{ .name = "gemini", .api_key = "AIza-test", .model = "gemini-1.5-flash", .endpoint = metadata.endpoint },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+}
+
+test "buildRequest sends contents and a systemInstruction" {
+    const body = try buildRequest(testProvider(), "Git diff:\ndiff", "prompt");
+    defer std.testing.allocator.free(body);
+
+    try std.testing.expect(std.mem.startsWith(u8, body, "{\"systemInstruction\":{\"parts\":[{\"text\":\"prompt\"}]},\"contents\":[{\"role\":\"user\",\"parts\":[{\"text\":\"Git diff:\\ndiff\"}]}]"));
+    try std.testing.expect(std.mem.indexOf(u8, body, "\"maxOutputTokens\":1000") != null);
+
+    var provider = testProvider();
+    provider.config.system_prompt_placement = "user_prefix";
+    const merged = try buildRequest(provider, "diff", "prompt");
+    defer std.testing.allocator.free(merged);
+
+    try std.testing.expect(std.mem.indexOf(u8, merged, "systemInstruction") == null);
+    try std.testing.expect(std.mem.indexOf(u8, merged, "\"parts\":[{\"text\":\"prompt\\n\\ndiff\"}]") != null);
+}
+
+test "buildEndpoint fills in the model and passes the key as a query parameter" {
+    var provider = testProvider();
+
+    const url = try buildEndpoint(provider);
+    defer std.testing.allocator.free(url);
+    try std.testing.expectEqualStrings("https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent?key=AIza-test", url);
+
+    provider.stream = true;
+    provider.config.model = "gemini-1.5-pro";
+    const stream_url = try buildEndpoint(provider);
+    defer std.testing.allocator.free(stream_url);
+    try std.testing.expectEqualStrings("https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-pro:streamGenerateContent?alt=sse&key=AIza-test", stream_url);
+
+    const header = try getAuthHeader(provider);
+    defer std.testing.allocator.free(header);
+    try std.testing.expectEqualStrings("", header);
+}
+
+test "parseResponse joins candidate parts and records usage" {
+    var usage = llm.Usage{};
+    var provider = testProvider();
+    provider.usage = &usage;
+
+    const response =
+        \\{"candidates":[{"content":{"role":"model","parts":[{"text":"Weighing the scope","thought":true},{"text":"feat: add "},{"text":"gemini provider\n"}]},"finishReason":"STOP"}],
+        \\ "usageMetadata":{"promptTokenCount":540,"candidatesTokenCount":8,"totalTokenCount":548},"modelVersion":"gemini-1.5-flash"}
+    ;
+    const message = try parseResponse(provider, response);
+    defer std.testing.allocator.free(message);
+
+    try std.testing.expectEqualStrings("feat: add gemini provider", message);
+    try std.testing.expectEqual(@as(u64, 540), usage.prompt_tokens);
+    try std.testing.expectEqual(@as(u64, 548), usage.total_tokens);
+}
+
+test "parseResponse maps Google API errors" {
+    const provider = testProvider();
+    try std.testing.expectError(llm.LlmError.InvalidApiKey, parseResponse(provider, "{\"error\":{\"code\":400,\"message\":\"API key not valid. Please pass a valid API key.\",\"status\":\"INVALID_ARGUMENT\"}}"));
+    try std.testing.expectError(llm.LlmError.RateLimited, parseResponse(provider, "{\"error\":{\"code\":429,\"message\":\"Resource has been exhausted (e.g. check quota).\",\"status\":\"RESOURCE_EXHAUSTED\"}}"));
+    try std.testing.expectError(llm.LlmError.ModelNotFound, parseResponse(provider, "{\"error\":{\"code\":404,\"message\":\"models/gemini-0.9 is not found for API version v1beta\",\"status\":\"NOT_FOUND\"}}"));
+    try std.testing.expectError(llm.LlmError.ContextLengthExceeded, parseResponse(provider, "{\"error\":{\"code\":400,\"message\":\"The input token count (1200000) exceeds the maximum number of tokens allowed (1048576).\",\"status\":\"INVALID_ARGUMENT\"}}"));
+    try std.testing.expectError(llm.LlmError.EmptyContent, parseResponse(provider, "{\"promptFeedback\":{\"blockReason\":\"SAFETY\"}}"));
+    try std.testing.expectError(llm.LlmError.InvalidResponse, parseResponse(provider, "not json"));
+}
+
+test "appendStreamLine accumulates SSE chunks" {
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+
+    try std.testing.expect(try appendStreamLine(testProvider(), "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"fix: \"}]}}]}", &out));
+    try std.testing.expect(try appendStreamLine(testProvider(), "", &out));
+    try std.testing.expect(try appendStreamLine(testProvider(), "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"stream gemini\"}]},\"finishReason\":\"STOP\"}]}", &out));
+
+    try std.testing.expectEqualStrings("fix: stream gemini", out.items);
+    try std.testing.expect(!try appendStreamLine(testProvider(), "{\"error\":{\"code\":400}}", &out));
+    try std.testing.expectError(llm.LlmError.RateLimited, appendStreamLine(testProvider(), "data: {\"error\":{\"code\":429,\"status\":\"RESOURCE_EXHAUSTED\"}}", &out));
+}
@@ -4,8 +4,12 @@ pub const metadata = .{
     .name = "groq",
     .display_name = "Groq",
     .default_model = "llama-3.1-8b-instant",
+    .models = &[_][]const u8{ "llama-3.1-8b-instant", "llama-3.3-70b-versatile" },
     .endpoint = "https://api.groq.com/openai/v1/chat/completions",
     .api_key_placeholder = "paste-key-here",
+    .requires_api_key = true,
+    .capabilities = .{ .streaming = true, .structured_output = true, .tools = true },
+    .system_prompt_placement = .system_role,
 };
 
 pub const vtable = openai_compat.makeVTable();
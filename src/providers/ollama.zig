@@ -0,0 +1,219 @@
+const std = @import("std");
+const llm = @import("../llm.zig");
+const openai_compat = @import("openai_compat.zig");
+
+pub const metadata = .{
+    .name = "ollama",
+    .display_name = "Ollama (local)",
+    .default_model = "llama3.2",
+    .models = &[_][]const u8{"llama3.2"},
+    .endpoint = "http://localhost:11434/api/chat",
+    .api_key_placeholder = "",
+    .requires_api_key = false,
+    .capabilities = .{ .streaming = true, .structured_output = true, .tools = true },
+    .system_prompt_placement = .system_role,
+};
+
+pub const vtable = llm.Provider.VTable{
+    .buildRequest = buildRequest,
+    .parseResponse = parseResponse,
+    .getEndpoint = openai_compat.getEndpoint,
+    .getAuthHeader = getAuthHeader,
+    .appendStreamLine = appendStreamLine,
+};
+
+/// Build an /api/chat request; unless streaming, the reply arrives as a single JSON object
+pub fn buildRequest(provider: llm.Provider, user_content: []const u8, prompt: []const u8) ![]const u8 {
+    const chat = try openai_compat.ChatMessages.init(provider.allocator, provider.config.systemPromptPlacement(), user_content, prompt);
+    defer chat.deinit(provider.allocator);
+
+    const request = .{
+        .model = provider.config.model,
+        .system = chat.system,
+        .messages = chat.items(),
+        .stream = provider.stream,
+        .options = .{ .temperature = provider.temperature },
+    };
+
+    return std.json.stringifyAlloc(provider.allocator, request, .{
+        .emit_null_optional_fields = false,
+    });
+}
+
+pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError![]const u8 {
+    var parsed = std.json.parseFromSlice(std.json.Value, provider.allocator, response, .{}) catch {
+        return llm.LlmError.InvalidResponse;
+    };
+    defer parsed.deinit();
+
+    const root = parsed.value;
+    if (root != .object) return llm.LlmError.InvalidResponse;
+
+    // Ollama reports failures as {"error": "model 'x' not found, try pulling it first"}
+    if (root.object.get("error")) |error_value| {
+        if (error_value == .string and llm.isContextLengthMessage(error_value.string)) {
+            return llm.LlmError.ContextLengthExceeded;
+        }
+        if (error_value == .string and llm.isModelNotFoundMessage(error_value.string)) {
+            return llm.LlmError.ModelNotFound;
+        }
+        return llm.LlmError.ApiError;
+    }
+
+    recordEvalCounts(provider, root.object);
+
+    const message = root.object.get("message") orelse return llm.LlmError.InvalidResponse;
+    if (message != .object) return llm.LlmError.InvalidResponse;
+
+    const content = message.object.get("content") orelse return llm.LlmError.InvalidResponse;
+    if (content != .string) return llm.LlmError.InvalidResponse;
+
+    return provider.finalizeContent(content.string);
+}
+
+/// Ollama reports token counts as prompt_eval_count/eval_count rather than a usage object,
+/// on the reply or on the final (`"done": true`) line of a stream
+fn recordEvalCounts(provider: llm.Provider, root: std.json.ObjectMap) void {
+    if (root.get("eval_count") == null) return;
+    const prompt_tokens = llm.Usage.field(root, "prompt_eval_count");
+    const completion_tokens = llm.Usage.field(root, "eval_count");
+    provider.recordUsage(.{
+        .prompt_tokens = prompt_tokens,
+        .completion_tokens = completion_tokens,
+        .total_tokens = prompt_tokens + completion_tokens,
+    });
+}
+
+/// Append the content from one JSON line of a streamed /api/chat response
+pub fn appendStreamLine(provider: llm.Provider, line: []const u8, out: *std.ArrayList(u8)) llm.LlmError!bool {
+    const trimmed_line = std.mem.trim(u8, line, " \r\t");
+    if (trimmed_line.len == 0) return true;
+
+    var parsed = std.json.parseFromSlice(std.json.Value, provider.allocator, trimmed_line, .{}) catch return false;
+    defer parsed.deinit();
+
+    const root = parsed.value;
+    if (root != .object) return false;
+    if (root.object.get("error")) |error_value| {
+        if (error_value == .string and llm.isModelNotFoundMessage(error_value.string)) return llm.LlmError.ModelNotFound;
+        return llm.LlmError.ApiError;
+    }
+
+    recordEvalCounts(provider, root.object);
+
+    const message = root.object.get("message") orelse return false;
+    if (message != .object) return false;
+    const content = message.object.get("content") orelse return true;
+    if (content != .string) return true;
+
+    out.appendSlice(content.string) catch return llm.LlmError.OutOfMemory;
+    return true;
+}
+
+/// No key is needed locally; send one only if configured (e.g. Ollama behind an authenticating proxy)
+pub fn getAuthHeader(provider: llm.Provider) ![]const u8 {
+    if (provider.config.api_key.len == 0) return provider.allocator.dupe(u8, "");
+    return openai_compat.getAuthHeader(provider);
+}
+
+// Test section
+fn testProvider() llm.Provider {
+    return .{
+        .name = "ollama",
+        .config = .{ .name = "ollama", .model = "llama3.2", .endpoint = metadata.endpoint },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+}
+
+test "buildRequest disables streaming" {
+    const body = try buildRequest(testProvider(), "Git diff:\ndiff", "prompt");
+    defer std.testing.allocator.free(body);
+
+    try std.testing.expect(std.mem.indexOf(u8, body, "\"stream\":false") != null);
+    try std.testing.expect(std.mem.indexOf(u8, body, "\"model\":\"llama3.2\"") != null);
+}
+
+test "buildRequest can prepend the system prompt to the user message" {
+    var provider = testProvider();
+    provider.config.system_prompt_placement = "user_prefix";
+
+    const body = try buildRequest(provider, "Git diff:\ndiff", "prompt");
+    defer std.testing.allocator.free(body);
+
+    try std.testing.expect(std.mem.indexOf(u8, body, "\"messages\":[{\"role\":\"user\",\"content\":\"prompt\\n\\nGit diff:\\ndiff\"}]") != null);
+    try std.testing.expect(std.mem.indexOf(u8, body, "\"system\"") == null);
+}
+
+test "parseResponse reads message content" {
+    const response =
+        \\{"model":"llama3.2","message":{"role":"assistant","content":"  feat: add ollama provider\n"},"done":true}
+    ;
+
+    const message = try parseResponse(testProvider(), response);
+    defer std.testing.allocator.free(message);
+
+    try std.testing.expectEqualStrings("feat: add ollama provider", message);
+}
+
+test "parseResponse records eval counts as usage" {
+    var usage = llm.Usage{};
+    var provider = testProvider();
+    provider.usage = &usage;
+
+    const response =
+        \\{"message":{"content":"fix: typo"},"done":true,"prompt_eval_count":640,"eval_count":12}
+    ;
+    const message = try parseResponse(provider, response);
+    defer std.testing.allocator.free(message);
+
+    try std.testing.expectEqual(@as(u64, 640), usage.prompt_tokens);
+    try std.testing.expectEqual(@as(u64, 652), usage.total_tokens);
+}
+
+test "parseResponse maps errors" {
+    try std.testing.expectError(llm.LlmError.ModelNotFound, parseResponse(testProvider(), "{\"error\":\"model 'x' not found, try pulling it first\"}"));
+    try std.testing.expectError(llm.LlmError.ApiError, parseResponse(testProvider(), "{\"error\":\"unexpected server error\"}"));
+    try std.testing.expectError(llm.LlmError.ContextLengthExceeded, parseResponse(testProvider(), "{\"error\":\"prompt is too long for the context window\"}"));
+    try std.testing.expectError(llm.LlmError.EmptyContent, parseResponse(testProvider(), "{\"message\":{\"content\":\"\"}}"));
+    try std.testing.expectError(llm.LlmError.InvalidResponse, parseResponse(testProvider(), "not json"));
+}
+
+test "getAuthHeader is empty without an API key" {
+    const header = try getAuthHeader(testProvider());
+    defer std.testing.allocator.free(header);
+
+    try std.testing.expectEqualStrings("", header);
+}
+
+test "appendStreamLine accumulates JSON-lines chunks" {
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+
+    try std.testing.expect(try appendStreamLine(testProvider(), "{\"message\":{\"role\":\"assistant\",\"content\":\"fix: \"},\"done\":false}", &out));
+    try std.testing.expect(try appendStreamLine(testProvider(), "{\"message\":{\"role\":\"assistant\",\"content\":\"local model\"},\"done\":false}", &out));
+    try std.testing.expect(try appendStreamLine(testProvider(), "{\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true}", &out));
+
+    try std.testing.expectEqualStrings("fix: local model", out.items);
+    try std.testing.expectError(llm.LlmError.ModelNotFound, appendStreamLine(testProvider(), "{\"error\":\"model not found\"}", &out));
+    try std.testing.expectError(llm.LlmError.ApiError, appendStreamLine(testProvider(), "{\"error\":\"boom\"}", &out));
+}
+
+test "appendStreamLine records eval counts from the final line" {
+    var usage = llm.Usage{};
+    var provider = testProvider();
+    provider.usage = &usage;
+
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+
+    try std.testing.expect(try appendStreamLine(provider, "{\"message\":{\"role\":\"assistant\",\"content\":\"fix: typo\"},\"done\":false}", &out));
+    try std.testing.expect(!usage.reported());
+    try std.testing.expect(try appendStreamLine(provider, "{\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"prompt_eval_count\":640,\"eval_count\":12}", &out));
+
+    try std.testing.expectEqual(@as(u64, 640), usage.prompt_tokens);
+    try std.testing.expectEqual(@as(u64, 652), usage.total_tokens);
+}
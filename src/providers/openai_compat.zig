@@ -1,27 +1,66 @@
 const std = @import("std");
 const llm = @import("../llm.zig");
+const registry = @import("registry.zig");
 
 pub const Message = struct {
     role: []const u8,
     content: []const u8,
 };
 
-pub fn buildRequest(provider: llm.Provider, diff: []const u8, prompt: []const u8) ![]const u8 {
-    const allocator = provider.allocator;
+/// Chat messages with the system prompt placed per the provider's SystemPromptPlacement
+pub const ChatMessages = struct {
+    buf: [2]Message = undefined,
+    len: usize = 0,
+    /// The top-level "system" field; null unless the placement is top_level
+    system: ?[]const u8 = null,
+    /// Prompt and user content joined for user_prefix; owned
+    merged: ?[]const u8 = null,
+
+    pub fn init(allocator: std.mem.Allocator, placement: registry.SystemPromptPlacement, user_content: []const u8, prompt: []const u8) !ChatMessages {
+        var chat = ChatMessages{};
+        switch (placement) {
+            .system_role => {
+                chat.buf[0] = .{ .role = "system", .content = prompt };
+                chat.buf[1] = .{ .role = "user", .content = user_content };
+                chat.len = 2;
+            },
+            .top_level => {
+                chat.system = prompt;
+                chat.buf[0] = .{ .role = "user", .content = user_content };
+                chat.len = 1;
+            },
+            .user_prefix => {
+                const merged = try std.mem.concat(allocator, u8, &.{ prompt, "\n\n", user_content });
+                chat.merged = merged;
+                chat.buf[0] = .{ .role = "user", .content = merged };
+                chat.len = 1;
+            },
+        }
+        return chat;
+    }
 
-    const user_content = try std.fmt.allocPrint(allocator, "Git diff:\n{s}", .{diff});
-    defer allocator.free(user_content);
+    pub fn deinit(self: *const ChatMessages, allocator: std.mem.Allocator) void {
+        if (self.merged) |merged| allocator.free(merged);
+    }
 
-    const messages = &[_]Message{
-        .{ .role = "system", .content = prompt },
-        .{ .role = "user", .content = user_content },
-    };
+    pub fn items(self: *const ChatMessages) []const Message {
+        return self.buf[0..self.len];
+    }
+};
+
+pub fn buildRequest(provider: llm.Provider, user_content: []const u8, prompt: []const u8) ![]const u8 {
+    const allocator = provider.allocator;
+
+    const chat = try ChatMessages.init(allocator, provider.config.systemPromptPlacement(), user_content, prompt);
+    defer chat.deinit(allocator);
 
     const request = .{
         .model = provider.config.model,
-        .messages = messages,
-        .temperature = @as(f32, 0.7),
+        .system = chat.system,
+        .messages = chat.items(),
+        .temperature = provider.temperature,
         .max_tokens = @as(u32, 1000),
+        .stream = provider.stream,
     };
 
     return std.json.stringifyAlloc(allocator, request, .{
@@ -52,11 +91,26 @@ pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError!
             if (error_map.get("code")) |code_val| {
                 if (code_val == .string) {
                     const code_str = code_val.string;
+                    if (std.mem.eql(u8, code_str, "context_length_exceeded")) {
+                        return llm.LlmError.ContextLengthExceeded;
+                    }
+                    // Azure uses DeploymentNotFound and the HTTP status as a string code
+                    if (std.mem.eql(u8, code_str, "model_not_found") or std.mem.eql(u8, code_str, "model_decommissioned") or
+                        std.mem.eql(u8, code_str, "DeploymentNotFound"))
+                    {
+                        return llm.LlmError.ModelNotFound;
+                    }
+                    if (std.mem.eql(u8, code_str, "429")) return llm.LlmError.RateLimited;
                     if (std.mem.eql(u8, code_str, "invalid_api_key") or
-                        std.mem.eql(u8, code_str, "unauthorized"))
+                        std.mem.eql(u8, code_str, "unauthorized") or
+                        std.mem.eql(u8, code_str, "401"))
                     {
                         is_auth_error = true;
                     }
+                } else if (code_val == .integer) {
+                    // OpenRouter reports the HTTP status as a numeric code
+                    if (code_val.integer == 429) return llm.LlmError.RateLimited;
+                    if (code_val.integer == 401 or code_val.integer == 403) is_auth_error = true;
                 }
             }
             if (!is_auth_error) {
@@ -79,6 +133,14 @@ pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError!
                         return llm.LlmError.RateLimited;
                     }
 
+                    if (llm.isContextLengthMessage(error_message)) {
+                        return llm.LlmError.ContextLengthExceeded;
+                    }
+
+                    if (llm.isModelNotFoundMessage(error_message)) {
+                        return llm.LlmError.ModelNotFound;
+                    }
+
                     // Check for auth errors in message
                     if (std.mem.indexOf(u8, error_message, "invalid api key") != null or
                         std.mem.indexOf(u8, error_message, "Invalid API key") != null or
@@ -105,6 +167,8 @@ pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError!
         return llm.LlmError.ApiError;
     }
 
+    if (usageObject(root.object)) |usage| provider.recordUsage(parseUsage(usage));
+
     const choices = root.object.get("choices") orelse return llm.LlmError.InvalidResponse;
     if (choices != .array) return llm.LlmError.InvalidResponse;
     if (choices.array.items.len == 0) return llm.LlmError.EmptyContent;
@@ -118,19 +182,74 @@ pub fn parseResponse(provider: llm.Provider, response: []const u8) llm.LlmError!
     const content = message.object.get("content") orelse return llm.LlmError.InvalidResponse;
     if (content != .string) return llm.LlmError.InvalidResponse;
 
-    const content_str = content.string;
-    if (content_str.len == 0) return llm.LlmError.EmptyContent;
+    return provider.finalizeContent(content.string);
+}
+
+/// Token counts from an OpenAI-style `usage` object; total falls back to prompt + completion
+pub fn parseUsage(usage: std.json.ObjectMap) llm.Usage {
+    const prompt_tokens = llm.Usage.field(usage, "prompt_tokens");
+    const completion_tokens = llm.Usage.field(usage, "completion_tokens");
+    const total_tokens = llm.Usage.field(usage, "total_tokens");
+    return .{
+        .prompt_tokens = prompt_tokens,
+        .completion_tokens = completion_tokens,
+        .total_tokens = if (total_tokens > 0) total_tokens else prompt_tokens + completion_tokens,
+    };
+}
+
+/// The `usage` object of a response or stream chunk; Groq streams nest it under `x_groq`
+fn usageObject(root: std.json.ObjectMap) ?std.json.ObjectMap {
+    if (root.get("usage")) |usage| {
+        if (usage == .object) return usage.object;
+    }
+    const x_groq = root.get("x_groq") orelse return null;
+    if (x_groq != .object) return null;
+    const usage = x_groq.object.get("usage") orelse return null;
+    return if (usage == .object) usage.object else null;
+}
+
+/// Append the delta content from one SSE line (`data: {...}`) of a streamed chat completion
+pub fn appendStreamLine(provider: llm.Provider, line: []const u8, out: *std.ArrayList(u8)) llm.LlmError!bool {
+    const trimmed_line = std.mem.trim(u8, line, " \r\t");
+    // Blank lines separate SSE events; lines starting with ':' are keep-alive comments
+    if (trimmed_line.len == 0 or trimmed_line[0] == ':') return true;
 
-    const trimmed = std.mem.trim(u8, content_str, " \n\r\t");
-    if (trimmed.len == 0) return llm.LlmError.EmptyContent;
+    const prefix = "data:";
+    if (!std.mem.startsWith(u8, trimmed_line, prefix)) return false;
 
-    return allocator.dupe(u8, trimmed) catch |err| switch (err) {
-        error.OutOfMemory => return llm.LlmError.OutOfMemory,
+    const data = std.mem.trim(u8, trimmed_line[prefix.len..], " ");
+    if (data.len == 0 or std.mem.eql(u8, data, "[DONE]")) return true;
+
+    var parsed = std.json.parseFromSlice(std.json.Value, provider.allocator, data, .{}) catch {
+        return llm.LlmError.InvalidResponse;
     };
+    defer parsed.deinit();
+
+    const root = parsed.value;
+    if (root != .object) return llm.LlmError.InvalidResponse;
+    if (root.object.get("error") != null) return llm.LlmError.ApiError;
+
+    // Servers that report usage while streaming send it on the last chunk(s)
+    if (usageObject(root.object)) |usage| provider.recordUsage(parseUsage(usage));
+
+    const choices = root.object.get("choices") orelse return true;
+    if (choices != .array or choices.array.items.len == 0) return true;
+
+    const first_choice = choices.array.items[0];
+    if (first_choice != .object) return llm.LlmError.InvalidResponse;
+
+    // Role-only and finish chunks carry no (or null) content
+    const delta = first_choice.object.get("delta") orelse return true;
+    if (delta != .object) return true;
+    const content = delta.object.get("content") orelse return true;
+    if (content != .string) return true;
+
+    out.appendSlice(content.string) catch return llm.LlmError.OutOfMemory;
+    return true;
 }
 
 pub fn getEndpoint(provider: llm.Provider) []const u8 {
-    return provider.config.endpoint;
+    return provider.config.endpointOrDefault();
 }
 
 pub fn getAuthHeader(provider: llm.Provider) ![]const u8 {
@@ -143,5 +262,190 @@ pub fn makeVTable() llm.Provider.VTable {
         .parseResponse = parseResponse,
         .getEndpoint = getEndpoint,
         .getAuthHeader = getAuthHeader,
+        .appendStreamLine = appendStreamLine,
+    };
+}
+
+/// Same as makeVTable but with a provider-specific post-processing hook
+pub fn makeVTableWithPostProcess(comptime post_process: llm.Provider.PostProcessFn) llm.Provider.VTable {
+    var vtable = makeVTable();
+    vtable.postProcess = post_process;
+    return vtable;
+}
+
+// Test section
+fn requestTemperature(body: []const u8) !f64 {
+    var parsed = try std.json.parseFromSlice(std.json.Value, std.testing.allocator, body, .{});
+    defer parsed.deinit();
+    return parsed.value.object.get("temperature").?.float;
+}
+
+test "buildRequest temperature increases across regenerates" {
+    var provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+
+    var previous: f64 = 0;
+    for (0..3) |attempt| {
+        provider.temperature = llm.regenerateTemperature(@intCast(attempt));
+        const body = try buildRequest(provider, "diff", "prompt");
+        defer std.testing.allocator.free(body);
+
+        const temperature = try requestTemperature(body);
+        try std.testing.expect(temperature > previous);
+        previous = temperature;
+    }
+}
+
+test "buildRequest places the system prompt per provider config" {
+    const cases = [_]struct { []const u8, []const u8 }{
+        .{ "", "{\"model\":\"m\",\"messages\":[{\"role\":\"system\",\"content\":\"prompt\"},{\"role\":\"user\",\"content\":\"diff\"}]" },
+        .{ "top_level", "{\"model\":\"m\",\"system\":\"prompt\",\"messages\":[{\"role\":\"user\",\"content\":\"diff\"}]" },
+        .{ "user_prefix", "{\"model\":\"m\",\"messages\":[{\"role\":\"user\",\"content\":\"prompt\\n\\ndiff\"}]" },
+    };
+
+    for (cases) |case| {
+        const provider = llm.Provider{
+            .name = "groq",
+            .config = .{ .name = "groq", .model = "m", .system_prompt_placement = case[0] },
+            .http = undefined,
+            .allocator = std.testing.allocator,
+            .vtable = undefined,
+            .debug_log = null,
+            .debug_ctx = null,
+        };
+
+        const body = try buildRequest(provider, "diff", "prompt");
+        defer std.testing.allocator.free(body);
+        try std.testing.expect(std.mem.startsWith(u8, body, case[1]));
+    }
+}
+
+test "getAuthHeader has no trailing whitespace from a pasted key" {
+    const config = @import("../config.zig");
+    var env_map = std.process.EnvMap.init(std.testing.allocator);
+    defer env_map.deinit();
+
+    const key = try config.resolveApiKeyWithEnv(std.testing.allocator, &.{ .name = "groq", .api_key = "gsk_pasted\n", .model = "m" }, &env_map);
+    defer std.testing.allocator.free(key);
+
+    const provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .api_key = key, .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+    const header = try getAuthHeader(provider);
+    defer std.testing.allocator.free(header);
+    try std.testing.expectEqualStrings("Bearer gsk_pasted", header);
+}
+
+test "parseResponse detects context window errors" {
+    const provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+
+    try std.testing.expectError(llm.LlmError.ContextLengthExceeded, parseResponse(provider, "{\"error\":{\"message\":\"Please reduce the length of the messages\",\"code\":\"context_length_exceeded\"}}"));
+    try std.testing.expectError(llm.LlmError.ContextLengthExceeded, parseResponse(provider, "{\"error\":{\"message\":\"This model's maximum context length is 8192 tokens\"}}"));
+    try std.testing.expectError(llm.LlmError.ModelNotFound, parseResponse(provider, "{\"error\":{\"message\":\"model not found\"}}"));
+    try std.testing.expectError(llm.LlmError.ModelNotFound, parseResponse(provider, "{\"error\":{\"message\":\"The model `mixtral-8x7b-32768` has been decommissioned and is no longer supported.\",\"type\":\"invalid_request_error\",\"code\":\"model_decommissioned\"}}"));
+    try std.testing.expectError(llm.LlmError.ModelNotFound, parseResponse(provider, "{\"error\":{\"message\":\"The model `gpt-x` does not exist or you do not have access to it.\",\"code\":\"model_not_found\"}}"));
+    try std.testing.expectError(llm.LlmError.ApiError, parseResponse(provider, "{\"error\":{\"message\":\"Something went wrong\"}}"));
+}
+
+test "parseResponse records reported token usage" {
+    var usage = llm.Usage{};
+    const vtable = comptime makeVTable();
+    const provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+        .usage = &usage,
     };
+
+    const response =
+        \\{"choices":[{"message":{"content":"feat: count tokens"}}],"usage":{"prompt_tokens":812,"completion_tokens":9,"total_tokens":821}}
+    ;
+    const message = try parseResponse(provider, response);
+    defer std.testing.allocator.free(message);
+
+    try std.testing.expectEqualStrings("feat: count tokens", message);
+    try std.testing.expectEqual(@as(u64, 812), usage.prompt_tokens);
+    try std.testing.expectEqual(@as(u64, 9), usage.completion_tokens);
+    try std.testing.expectEqual(@as(u64, 821), usage.total_tokens);
+}
+
+test "appendStreamLine accumulates SSE deltas" {
+    const provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+
+    const lines = [_][]const u8{
+        "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}",
+        "",
+        "data: {\"choices\":[{\"delta\":{\"content\":\"feat: add \"}}]}",
+        ": keep-alive",
+        "data: {\"choices\":[{\"delta\":{\"content\":\"streaming\"}}]}",
+        "data: {\"choices\":[{\"delta\":{\"content\":null},\"finish_reason\":\"stop\"}]}",
+        "data: [DONE]",
+    };
+    for (lines) |line| {
+        try std.testing.expect(try appendStreamLine(provider, line, &out));
+    }
+
+    try std.testing.expectEqualStrings("feat: add streaming", out.items);
+    try std.testing.expect(!try appendStreamLine(provider, "{\"error\":{\"message\":\"Invalid API key\"}}", &out));
+    try std.testing.expectError(llm.LlmError.ApiError, appendStreamLine(provider, "data: {\"error\":{\"message\":\"boom\"}}", &out));
+}
+
+test "appendStreamLine records usage from the final chunk" {
+    var usage = llm.Usage{};
+    const provider = llm.Provider{
+        .name = "groq",
+        .config = .{ .name = "groq", .model = "m" },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = undefined,
+        .debug_log = null,
+        .debug_ctx = null,
+        .usage = &usage,
+    };
+
+    var out = std.ArrayList(u8).init(std.testing.allocator);
+    defer out.deinit();
+
+    try std.testing.expect(try appendStreamLine(provider, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}],\"x_groq\":{\"usage\":{\"prompt_tokens\":300,\"completion_tokens\":7,\"total_tokens\":307}}}", &out));
+    try std.testing.expectEqual(@as(u64, 307), usage.total_tokens);
+
+    try std.testing.expect(try appendStreamLine(provider, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":812,\"completion_tokens\":9}}", &out));
+    try std.testing.expectEqual(@as(u64, 812), usage.prompt_tokens);
+    try std.testing.expectEqual(@as(u64, 821), usage.total_tokens);
 }
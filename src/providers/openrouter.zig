@@ -0,0 +1,44 @@
+const std = @import("std");
+const llm = @import("../llm.zig");
+const openai_compat = @import("openai_compat.zig");
+
+pub const metadata = .{
+    .name = "openrouter",
+    .display_name = "OpenRouter",
+    .default_model = "openai/gpt-4o-mini",
+    // Any "vendor/model" id from openrouter.ai/models works; these are only the retry order
+    .models = &[_][]const u8{ "openai/gpt-4o-mini", "meta-llama/llama-3.3-70b-instruct" },
+    .endpoint = "https://openrouter.ai/api/v1/chat/completions",
+    .api_key_placeholder = "paste-key-here",
+    .requires_api_key = true,
+    .capabilities = .{ .streaming = true },
+    .system_prompt_placement = .system_role,
+    // Attribution shown on openrouter.ai; configured headers with the same name win,
+    // and anonymize strips both
+    .default_headers = &[_][]const u8{
+        "HTTP-Referer: https://github.com/jsmenzies/autocommit",
+        "X-Title: autocommit",
+    },
+};
+
+pub const vtable = openai_compat.makeVTable();
+
+// Test section
+test "parseResponse maps OpenRouter's numeric error codes" {
+    const provider = llm.Provider{
+        .name = "openrouter",
+        .config = .{ .name = "openrouter", .model = metadata.default_model, .endpoint = metadata.endpoint },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+
+    try std.testing.expectError(llm.LlmError.InvalidApiKey, openai_compat.parseResponse(provider, "{\"error\":{\"code\":401,\"message\":\"No auth credentials found\"}}"));
+    try std.testing.expectError(llm.LlmError.RateLimited, openai_compat.parseResponse(provider, "{\"error\":{\"code\":429,\"message\":\"Rate limit exceeded: free-models-per-day\"}}"));
+
+    const message = try openai_compat.parseResponse(provider, "{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"docs: add OpenRouter setup\"}}]}");
+    defer std.testing.allocator.free(message);
+    try std.testing.expectEqualStrings("docs: add OpenRouter setup", message);
+}
@@ -2,10 +2,18 @@ const std = @import("std");
 
 const zai = @import("zai.zig");
 const groq = @import("groq.zig");
+const ollama = @import("ollama.zig");
+const openrouter = @import("openrouter.zig");
+const gemini = @import("gemini.zig");
+const azure = @import("azure.zig");
 
 pub const ProviderId = enum {
     zai,
     groq,
+    ollama,
+    openrouter,
+    gemini,
+    azure,
 
     pub fn name(self: ProviderId) []const u8 {
         return @tagName(self);
@@ -21,17 +29,60 @@ pub const ProviderId = enum {
     }
 };
 
+/// Optional API features a provider may support natively
+pub const Capability = enum {
+    streaming,
+    structured_output,
+    multi_choice,
+    tools,
+};
+
+pub const Capabilities = struct {
+    streaming: bool = false,
+    structured_output: bool = false,
+    /// Multiple choices in one request (`n > 1`)
+    multi_choice: bool = false,
+    tools: bool = false,
+
+    pub fn supports(self: Capabilities, capability: Capability) bool {
+        return switch (capability) {
+            .streaming => self.streaming,
+            .structured_output => self.structured_output,
+            .multi_choice => self.multi_choice,
+            .tools => self.tools,
+        };
+    }
+};
+
+/// Where a request carries the system prompt
+pub const SystemPromptPlacement = enum {
+    /// A leading {"role": "system"} message
+    system_role,
+    /// A top-level "system" request field (Anthropic-style APIs)
+    top_level,
+    /// Prepended to the user message, for models that ignore the system role
+    user_prefix,
+};
+
 pub const ProviderMetadata = struct {
     id: ProviderId,
     name: []const u8,
     display_name: []const u8,
     default_model: []const u8,
+    /// Models tried in order when the configured one is not found or retired, default first
+    models: []const []const u8,
     endpoint: []const u8,
     api_key_placeholder: []const u8,
+    /// Local providers (e.g. ollama) work without an API key
+    requires_api_key: bool,
+    capabilities: Capabilities,
+    system_prompt_placement: SystemPromptPlacement,
+    /// "Name: value" headers sent on every request; configured headers with the same name replace them
+    default_headers: []const []const u8,
 };
 
 const RegistryBuilder = struct {
-    const provider_modules = .{ zai, groq };
+    const provider_modules = .{ zai, groq, ollama, openrouter, gemini, azure };
 
     fn buildMetadata() [provider_modules.len]ProviderMetadata {
         comptime {
@@ -43,8 +94,13 @@ const RegistryBuilder = struct {
                     .name = provider_module.metadata.name,
                     .display_name = provider_module.metadata.display_name,
                     .default_model = provider_module.metadata.default_model,
+                    .models = provider_module.metadata.models,
                     .endpoint = provider_module.metadata.endpoint,
                     .api_key_placeholder = provider_module.metadata.api_key_placeholder,
+                    .requires_api_key = provider_module.metadata.requires_api_key,
+                    .capabilities = provider_module.metadata.capabilities,
+                    .system_prompt_placement = provider_module.metadata.system_prompt_placement,
+                    .default_headers = if (@hasField(@TypeOf(provider_module.metadata), "default_headers")) provider_module.metadata.default_headers else &.{},
                 };
             }
 
@@ -78,6 +134,19 @@ pub fn getVtable(name: []const u8) !*const @import("../llm.zig").Provider.VTable
     unreachable; // Should never reach here if metadata is valid
 }
 
+/// The registry model to try after `current` was reported as not found: the next one in the
+/// provider's list, or the first when `current` isn't listed (e.g. a custom model)
+/// Returns null once the list is exhausted or for unknown providers
+pub fn nextModel(provider_name: []const u8, current: []const u8) ?[]const u8 {
+    const metadata = getByName(provider_name) orelse return null;
+    for (metadata.models, 0..) |model, i| {
+        if (std.mem.eql(u8, model, current)) {
+            return if (i + 1 < metadata.models.len) metadata.models[i + 1] else null;
+        }
+    }
+    return if (metadata.models.len > 0) metadata.models[0] else null;
+}
+
 pub fn getIndex(id: ProviderId) usize {
     return @intFromEnum(id);
 }
@@ -87,6 +156,17 @@ pub fn isValidProvider(name: []const u8) bool {
 }
 
 // Test section
+test "nextModel walks the registry list in order" {
+    const groq_models = getById(.groq).?.models;
+    try std.testing.expectEqualStrings(groq_models[0], getById(.groq).?.default_model);
+
+    // A retired custom model falls back to the default first, then down the list
+    try std.testing.expectEqualStrings(groq_models[0], nextModel("groq", "mixtral-8x7b-32768").?);
+    try std.testing.expectEqualStrings(groq_models[1], nextModel("groq", groq_models[0]).?);
+    try std.testing.expect(nextModel("groq", groq_models[groq_models.len - 1]) == null);
+    try std.testing.expect(nextModel("unknown", "m") == null);
+}
+
 test "ProviderId name and fromString" {
     try std.testing.expectEqualStrings("zai", ProviderId.zai.name());
     try std.testing.expectEqualStrings("groq", ProviderId.groq.name());
@@ -107,6 +187,12 @@ test "getById returns correct metadata" {
     try std.testing.expectEqualStrings("groq", groq_metadata.name);
     try std.testing.expectEqualStrings("Groq", groq_metadata.display_name);
     try std.testing.expectEqualStrings("llama-3.1-8b-instant", groq_metadata.default_model);
+
+    const ollama_metadata = getById(.ollama).?;
+    try std.testing.expectEqualStrings("ollama", ollama_metadata.name);
+    try std.testing.expectEqualStrings("http://localhost:11434/api/chat", ollama_metadata.endpoint);
+    try std.testing.expect(!ollama_metadata.requires_api_key);
+    try std.testing.expect(groq_metadata.requires_api_key);
 }
 
 test "getByName returns correct metadata" {
@@ -122,11 +208,19 @@ test "getByName returns correct metadata" {
 test "getIndex returns correct indices" {
     try std.testing.expectEqual(0, getIndex(.zai));
     try std.testing.expectEqual(1, getIndex(.groq));
+    try std.testing.expectEqual(2, getIndex(.ollama));
+    try std.testing.expectEqual(3, getIndex(.openrouter));
+    try std.testing.expectEqual(4, getIndex(.gemini));
+    try std.testing.expectEqual(5, getIndex(.azure));
 }
 
 test "isValidProvider correctly identifies valid names" {
     try std.testing.expect(isValidProvider("zai"));
     try std.testing.expect(isValidProvider("groq"));
+    try std.testing.expect(isValidProvider("ollama"));
+    try std.testing.expect(isValidProvider("openrouter"));
+    try std.testing.expect(isValidProvider("gemini"));
+    try std.testing.expect(isValidProvider("azure"));
     try std.testing.expect(!isValidProvider("unknown"));
     try std.testing.expect(!isValidProvider("openai"));
 }
@@ -135,6 +229,20 @@ test "getVtable returns vtable for implemented providers" {
     // Just verify we can get the vtable without error
     _ = try getVtable("zai");
     _ = try getVtable("groq");
+    _ = try getVtable("ollama");
+    _ = try getVtable("openrouter");
+    _ = try getVtable("gemini");
+    _ = try getVtable("azure");
+}
+
+test "capabilities reflect provider support" {
+    const groq_capabilities = getById(.groq).?.capabilities;
+    try std.testing.expect(groq_capabilities.supports(.streaming));
+    try std.testing.expect(!groq_capabilities.supports(.multi_choice));
+
+    const ollama_capabilities = getById(.ollama).?.capabilities;
+    try std.testing.expect(ollama_capabilities.supports(.structured_output));
+    try std.testing.expect(!ollama_capabilities.supports(.multi_choice));
 }
 
 test "getVtable returns error for unknown providers" {
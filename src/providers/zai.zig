@@ -1,11 +1,56 @@
+const std = @import("std");
+const llm = @import("../llm.zig");
 const openai_compat = @import("openai_compat.zig");
 
 pub const metadata = .{
     .name = "zai",
     .display_name = "Z AI",
     .default_model = "glm-4.7-Flash",
+    .models = &[_][]const u8{ "glm-4.7-Flash", "glm-4.5-Flash" },
     .endpoint = "https://api.z.ai/api/paas/v4/chat/completions",
     .api_key_placeholder = "paste-key-here",
+    .requires_api_key = true,
+    .capabilities = .{ .streaming = true, .tools = true },
+    .system_prompt_placement = .system_role,
 };
 
-pub const vtable = openai_compat.makeVTable();
+pub const vtable = openai_compat.makeVTableWithPostProcess(postProcess);
+
+/// GLM models sometimes wrap the answer in <|begin_of_box|>...<|end_of_box|> tokens
+fn postProcess(_: llm.Provider, raw: []const u8) []const u8 {
+    const begin = "<|begin_of_box|>";
+    const end = "<|end_of_box|>";
+
+    var result = std.mem.trim(u8, raw, " \n\r\t");
+    if (std.mem.startsWith(u8, result, begin)) result = result[begin.len..];
+    if (std.mem.endsWith(u8, result, end)) result = result[0 .. result.len - end.len];
+    return result;
+}
+
+// Test section
+test "postProcess strips GLM box tokens" {
+    const provider: llm.Provider = undefined;
+    try std.testing.expectEqualStrings("feat: add login", postProcess(provider, "<|begin_of_box|>feat: add login<|end_of_box|>"));
+    try std.testing.expectEqualStrings("feat: add login", postProcess(provider, "feat: add login"));
+}
+
+test "parseResponse applies zai post-processing" {
+    const provider = llm.Provider{
+        .name = "zai",
+        .config = .{ .name = "zai", .model = "glm-4.7-Flash", .endpoint = metadata.endpoint },
+        .http = undefined,
+        .allocator = std.testing.allocator,
+        .vtable = &vtable,
+        .debug_log = null,
+        .debug_ctx = null,
+    };
+
+    const response =
+        \\{"choices":[{"message":{"role":"assistant","content":"<|begin_of_box|>fix(api): handle timeouts<|end_of_box|>"}}]}
+    ;
+
+    const message = try openai_compat.parseResponse(provider, response);
+    defer std.testing.allocator.free(message);
+
+    try std.testing.expectEqualStrings("fix(api): handle timeouts", message);
+}
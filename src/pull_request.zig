@@ -0,0 +1,172 @@
+const std = @import("std");
+const http_client = @import("http_client.zig");
+
+/// Most of a pull request description sent to the LLM
+pub const MAX_DESCRIPTION_BYTES = 4 * 1024;
+
+const API_BASE = "https://api.github.com";
+
+/// A GitHub repository; slices point into the parsed remote URL
+pub const Repo = struct {
+    owner: []const u8,
+    name: []const u8,
+};
+
+/// Owner and name from a GitHub remote URL (https, ssh://, or scp-style git@github.com:owner/repo)
+/// Returns null for other hosts
+pub fn parseGitHubRemote(url: []const u8) ?Repo {
+    const trimmed = std.mem.trim(u8, url, " \t\r\n");
+    const prefixes = [_][]const u8{ "https://github.com/", "http://github.com/", "ssh://git@github.com/", "git@github.com:" };
+
+    const path = for (prefixes) |prefix| {
+        if (std.mem.startsWith(u8, trimmed, prefix)) break trimmed[prefix.len..];
+    } else return null;
+
+    const without_suffix = std.mem.trimRight(u8, if (std.mem.endsWith(u8, path, ".git")) path[0 .. path.len - 4] else path, "/");
+    const slash = std.mem.indexOfScalar(u8, without_suffix, '/') orelse return null;
+    const owner = without_suffix[0..slash];
+    const name = without_suffix[slash + 1 ..];
+    if (owner.len == 0 or name.len == 0 or std.mem.indexOfScalar(u8, name, '/') != null) return null;
+    return .{ .owner = owner, .name = name };
+}
+
+/// `GET /repos/{owner}/{repo}/pulls` URL listing the open pull requests whose head is `branch`
+/// Caller owns the returned memory
+pub fn pullsUrl(allocator: std.mem.Allocator, repo: Repo, branch: []const u8) ![]const u8 {
+    var url = std.ArrayList(u8).init(allocator);
+    errdefer url.deinit();
+    const writer = url.writer();
+
+    try writer.print(API_BASE ++ "/repos/{s}/{s}/pulls?state=open&head=", .{ repo.owner, repo.name });
+    try writeQueryValue(writer, repo.owner);
+    try writer.writeByte(':');
+    try writeQueryValue(writer, branch);
+    return url.toOwnedSlice();
+}
+
+/// Percent-encode everything but unreserved characters and '/'
+fn writeQueryValue(writer: anytype, value: []const u8) !void {
+    for (value) |c| {
+        if (std.ascii.isAlphanumeric(c) or c == '-' or c == '_' or c == '.' or c == '~' or c == '/') {
+            try writer.writeByte(c);
+        } else {
+            try writer.print("%{X:0>2}", .{c});
+        }
+    }
+}
+
+/// Context text for the first pull request in a `pulls` API response: its number and title,
+/// then the description capped at MAX_DESCRIPTION_BYTES. Null when the list is empty
+/// Caller owns the returned memory
+pub fn contextFromResponse(allocator: std.mem.Allocator, response: []const u8) !?[]const u8 {
+    var parsed = std.json.parseFromSlice(std.json.Value, allocator, response, .{}) catch return error.InvalidResponse;
+    defer parsed.deinit();
+
+    if (parsed.value != .array) return error.InvalidResponse;
+    if (parsed.value.array.items.len == 0) return null;
+
+    const pull = parsed.value.array.items[0];
+    if (pull != .object) return error.InvalidResponse;
+
+    const number = pull.object.get("number") orelse return error.InvalidResponse;
+    const title = pull.object.get("title") orelse return error.InvalidResponse;
+    if (number != .integer or title != .string) return error.InvalidResponse;
+
+    // The description is null when the PR was opened without one
+    const raw_body = if (pull.object.get("body")) |body| switch (body) {
+        .string => |text| text,
+        else => "",
+    } else "";
+    const body = std.mem.trim(u8, raw_body, " \t\r\n");
+
+    if (body.len == 0) {
+        return try std.fmt.allocPrint(allocator, "#{d} {s}", .{ number.integer, title.string });
+    }
+    return try std.fmt.allocPrint(allocator, "#{d} {s}\n\n{s}", .{ number.integer, title.string, body[0..@min(body.len, MAX_DESCRIPTION_BYTES)] });
+}
+
+/// API token from GITHUB_TOKEN or GH_TOKEN; null means unauthenticated (public repositories only)
+/// Caller owns the returned memory
+pub fn tokenFromEnv(allocator: std.mem.Allocator) ?[]const u8 {
+    for ([_][]const u8{ "GITHUB_TOKEN", "GH_TOKEN" }) |name| {
+        const value = std.process.getEnvVarOwned(allocator, name) catch continue;
+        if (std.mem.trim(u8, value, " \t\r\n").len > 0) return value;
+        allocator.free(value);
+    }
+    return null;
+}
+
+/// Look up the open pull request for `branch` of the GitHub repository behind `remote_url`
+/// Returns null for non-GitHub remotes or when the branch has no open pull request
+/// Caller owns the returned memory
+pub fn fetchContext(
+    allocator: std.mem.Allocator,
+    http: *http_client.HttpClient,
+    remote_url: []const u8,
+    branch: []const u8,
+    token: ?[]const u8,
+) !?[]const u8 {
+    const repo = parseGitHubRemote(remote_url) orelse return null;
+
+    const url = try pullsUrl(allocator, repo, branch);
+    defer allocator.free(url);
+
+    var auth_buf: [512]u8 = undefined;
+    var headers_buf: [2]std.http.Header = undefined;
+    headers_buf[0] = .{ .name = "Accept", .value = "application/vnd.github+json" };
+    var header_count: usize = 1;
+    if (token) |value| {
+        const trimmed = std.mem.trim(u8, value, " \t\r\n");
+        headers_buf[1] = .{ .name = "Authorization", .value = try std.fmt.bufPrint(&auth_buf, "Bearer {s}", .{trimmed}) };
+        header_count = 2;
+    }
+
+    const response = try http.getWithHeaders(url, headers_buf[0..header_count]);
+    defer allocator.free(response);
+
+    return contextFromResponse(allocator, response);
+}
+
+// Test section
+test "parseGitHubRemote accepts https, ssh, and scp-style URLs" {
+    const https = parseGitHubRemote("https://github.com/jsmenzies/autocommit.git").?;
+    try std.testing.expectEqualStrings("jsmenzies", https.owner);
+    try std.testing.expectEqualStrings("autocommit", https.name);
+
+    const scp = parseGitHubRemote("git@github.com:jsmenzies/autocommit.git\n").?;
+    try std.testing.expectEqualStrings("autocommit", scp.name);
+
+    const ssh = parseGitHubRemote("ssh://git@github.com/jsmenzies/autocommit").?;
+    try std.testing.expectEqualStrings("jsmenzies", ssh.owner);
+
+    try std.testing.expect(parseGitHubRemote("https://gitlab.com/group/project.git") == null);
+    try std.testing.expect(parseGitHubRemote("https://github.com/jsmenzies") == null);
+}
+
+test "pullsUrl filters by the branch's head" {
+    const url = try pullsUrl(std.testing.allocator, .{ .owner = "jsmenzies", .name = "autocommit" }, "feature/pr context");
+    defer std.testing.allocator.free(url);
+
+    try std.testing.expectEqualStrings("https://api.github.com/repos/jsmenzies/autocommit/pulls?state=open&head=jsmenzies:feature/pr%20context", url);
+}
+
+test "contextFromResponse uses the first open pull request" {
+    const response =
+        \\[{"number": 42, "title": "Add retry support", "body": "Retries transient 5xx errors.\r\n\r\nCloses #7", "state": "open"},
+        \\ {"number": 43, "title": "Other", "body": null}]
+    ;
+    const context = (try contextFromResponse(std.testing.allocator, response)).?;
+    defer std.testing.allocator.free(context);
+
+    try std.testing.expectEqualStrings("#42 Add retry support\n\nRetries transient 5xx errors.\r\n\r\nCloses #7", context);
+}
+
+test "contextFromResponse handles no pull request and an empty description" {
+    try std.testing.expect(try contextFromResponse(std.testing.allocator, "[]") == null);
+
+    const context = (try contextFromResponse(std.testing.allocator, "[{\"number\": 5, \"title\": \"Fix typo\", \"body\": null}]")).?;
+    defer std.testing.allocator.free(context);
+    try std.testing.expectEqualStrings("#5 Fix typo", context);
+
+    try std.testing.expectError(error.InvalidResponse, contextFromResponse(std.testing.allocator, "{\"message\": \"Bad credentials\"}"));
+}
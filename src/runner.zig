@@ -0,0 +1,231 @@
+const std = @import("std");
+
+/// Result of running a shell command
+pub const Result = struct {
+    allocator: std.mem.Allocator,
+    /// Exit code, or null when the command was killed (e.g. on timeout)
+    exit_code: ?u8,
+    timed_out: bool = false,
+    /// Combined stdout followed by stderr
+    output: []const u8,
+
+    pub fn deinit(self: Result) void {
+        self.allocator.free(self.output);
+    }
+
+    pub fn passed(self: Result) bool {
+        return !self.timed_out and self.exit_code != null and self.exit_code.? == 0;
+    }
+};
+
+/// Runs commands through `sh -c`, killing them after a timeout
+pub const ShellRunner = struct {
+    allocator: std.mem.Allocator,
+    timeout_ms: u64,
+
+    pub fn run(self: ShellRunner, command: []const u8) !Result {
+        return self.runCommand(command, null);
+    }
+
+    /// Like run, but writes `input` to the command's stdin and then closes it
+    pub fn runWithInput(self: ShellRunner, command: []const u8, input: []const u8) !Result {
+        return self.runCommand(command, input);
+    }
+
+    fn runCommand(self: ShellRunner, command: []const u8, input: ?[]const u8) !Result {
+        var child = std.process.Child.init(&[_][]const u8{ "sh", "-c", command }, self.allocator);
+        child.stdin_behavior = if (input != null) .Pipe else .Ignore;
+        child.stdout_behavior = .Pipe;
+        child.stderr_behavior = .Pipe;
+        try child.spawn();
+
+        if (input) |bytes| {
+            // A linter may exit without reading everything; a broken pipe is not our failure
+            child.stdin.?.writeAll(bytes) catch {};
+            child.stdin.?.close();
+            child.stdin = null;
+        }
+
+        var watchdog = Watchdog{ .pid = child.id, .timeout_ms = self.timeout_ms };
+        const watchdog_thread = try std.Thread.spawn(.{}, Watchdog.watch, .{&watchdog});
+
+        var stdout = std.ArrayList(u8).init(self.allocator);
+        defer stdout.deinit();
+        var stderr = std.ArrayList(u8).init(self.allocator);
+        defer stderr.deinit();
+
+        const collected = child.collectOutput(&stdout, &stderr, 1024 * 1024);
+        const term = child.wait();
+
+        watchdog.done.store(true, .release);
+        watchdog_thread.join();
+
+        try collected;
+        const exit_code: ?u8 = switch (try term) {
+            .Exited => |code| code,
+            else => null,
+        };
+
+        const output = try std.mem.concat(self.allocator, u8, &.{ stdout.items, stderr.items });
+        return .{
+            .allocator = self.allocator,
+            .exit_code = exit_code,
+            .timed_out = watchdog.fired.load(.acquire),
+            .output = output,
+        };
+    }
+};
+
+/// Kills the child process if it outlives the timeout
+const Watchdog = struct {
+    pid: std.process.Child.Id,
+    timeout_ms: u64,
+    done: std.atomic.Value(bool) = std.atomic.Value(bool).init(false),
+    fired: std.atomic.Value(bool) = std.atomic.Value(bool).init(false),
+
+    fn watch(self: *Watchdog) void {
+        const poll_ms = 50;
+        var waited_ms: u64 = 0;
+        while (!self.done.load(.acquire)) {
+            if (waited_ms >= self.timeout_ms) {
+                self.fired.store(true, .release);
+                std.posix.kill(self.pid, std.posix.SIG.KILL) catch {};
+                return;
+            }
+            std.time.sleep(poll_ms * std.time.ns_per_ms);
+            waited_ms += poll_ms;
+        }
+    }
+};
+
+/// Outcome of the pre-commit check
+pub const CheckOutcome = union(enum) {
+    not_configured,
+    skipped,
+    passed,
+    /// Caller owns the result and must deinit it
+    failed: Result,
+};
+
+/// Run the configured pre-commit command with `runner` (anything with `run(command) !Result`)
+pub fn runPreCommitCheck(runner: anytype, command: []const u8, skip: bool) !CheckOutcome {
+    if (command.len == 0) return .not_configured;
+    if (skip) return .skipped;
+
+    const result = try runner.run(command);
+    if (result.passed()) {
+        result.deinit();
+        return .passed;
+    }
+    return .{ .failed = result };
+}
+
+/// Pipe `message` to the configured linter command with `runner` (anything with
+/// `runWithInput(command, input) !Result`); a non-zero exit fails with the linter's output
+pub fn runLinter(runner: anytype, command: []const u8, message: []const u8) !CheckOutcome {
+    if (command.len == 0) return .not_configured;
+
+    const result = try runner.runWithInput(command, message);
+    if (result.passed()) {
+        result.deinit();
+        return .passed;
+    }
+    return .{ .failed = result };
+}
+
+// Test section
+const FakeRunner = struct {
+    exit_code: ?u8,
+    timed_out: bool = false,
+    calls: *usize,
+
+    pub fn run(self: FakeRunner, _: []const u8) !Result {
+        self.calls.* += 1;
+        return .{
+            .allocator = std.testing.allocator,
+            .exit_code = self.exit_code,
+            .timed_out = self.timed_out,
+            .output = try std.testing.allocator.dupe(u8, "test output"),
+        };
+    }
+
+    pub fn runWithInput(self: FakeRunner, command: []const u8, _: []const u8) !Result {
+        return self.run(command);
+    }
+};
+
+test "runPreCommitCheck passes when the command succeeds" {
+    var calls: usize = 0;
+    const outcome = try runPreCommitCheck(FakeRunner{ .exit_code = 0, .calls = &calls }, "zig build test", false);
+    try std.testing.expectEqual(CheckOutcome.passed, outcome);
+    try std.testing.expectEqual(@as(usize, 1), calls);
+}
+
+test "runPreCommitCheck fails with output when the command fails" {
+    var calls: usize = 0;
+    const outcome = try runPreCommitCheck(FakeRunner{ .exit_code = 1, .calls = &calls }, "zig build test", false);
+    switch (outcome) {
+        .failed => |result| {
+            defer result.deinit();
+            try std.testing.expectEqual(@as(?u8, 1), result.exit_code);
+            try std.testing.expectEqualStrings("test output", result.output);
+        },
+        else => return error.TestUnexpectedResult,
+    }
+}
+
+test "runPreCommitCheck treats a timeout as a failure" {
+    var calls: usize = 0;
+    const outcome = try runPreCommitCheck(FakeRunner{ .exit_code = null, .timed_out = true, .calls = &calls }, "sleep 999", false);
+    try std.testing.expect(outcome == .failed);
+    outcome.failed.deinit();
+}
+
+test "runPreCommitCheck skips without running the command" {
+    var calls: usize = 0;
+    try std.testing.expectEqual(CheckOutcome.skipped, try runPreCommitCheck(FakeRunner{ .exit_code = 1, .calls = &calls }, "zig build test", true));
+    try std.testing.expectEqual(CheckOutcome.not_configured, try runPreCommitCheck(FakeRunner{ .exit_code = 1, .calls = &calls }, "", false));
+    try std.testing.expectEqual(@as(usize, 0), calls);
+}
+
+test "ShellRunner captures output and exit code" {
+    const runner = ShellRunner{ .allocator = std.testing.allocator, .timeout_ms = 5000 };
+
+    const ok = try runner.run("echo hello");
+    defer ok.deinit();
+    try std.testing.expect(ok.passed());
+    try std.testing.expectEqualStrings("hello\n", ok.output);
+
+    const failing = try runner.run("echo oops >&2; exit 3");
+    defer failing.deinit();
+    try std.testing.expect(!failing.passed());
+    try std.testing.expectEqual(@as(?u8, 3), failing.exit_code);
+    try std.testing.expectEqualStrings("oops\n", failing.output);
+}
+
+test "runLinter passes and fails with the linter's exit code" {
+    var calls: usize = 0;
+    try std.testing.expectEqual(CheckOutcome.not_configured, try runLinter(FakeRunner{ .exit_code = 1, .calls = &calls }, "", "feat: x"));
+    try std.testing.expectEqual(@as(usize, 0), calls);
+
+    try std.testing.expectEqual(CheckOutcome.passed, try runLinter(FakeRunner{ .exit_code = 0, .calls = &calls }, "commitlint", "feat: x"));
+
+    const outcome = try runLinter(FakeRunner{ .exit_code = 1, .calls = &calls }, "commitlint", "bad message");
+    try std.testing.expect(outcome == .failed);
+    try std.testing.expectEqualStrings("test output", outcome.failed.output);
+    outcome.failed.deinit();
+    try std.testing.expectEqual(@as(usize, 2), calls);
+}
+
+test "ShellRunner.runWithInput pipes the message to the command" {
+    const shell = ShellRunner{ .allocator = std.testing.allocator, .timeout_ms = 10 * std.time.ms_per_s };
+    const linter = "grep -q '^feat: ' || { echo 'subject must start with feat:'; exit 1; }";
+
+    try std.testing.expectEqual(CheckOutcome.passed, try runLinter(shell, linter, "feat: add login\n\n- Add form\n"));
+
+    const outcome = try runLinter(shell, linter, "added login");
+    try std.testing.expect(outcome == .failed);
+    defer outcome.failed.deinit();
+    try std.testing.expectEqual(@as(?u8, 1), outcome.failed.exit_code);
+    try std.testing.expectEqualStrings("subject must start with feat:\n", outcome.failed.output);
+}
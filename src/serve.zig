@@ -0,0 +1,170 @@
+const std = @import("std");
+const git = @import("git.zig");
+const llm = @import("llm.zig");
+const prompt_builder = @import("prompt.zig");
+
+/// Longest request line accepted; longer lines are skipped with an error response
+pub const MAX_REQUEST_LINE = 64 * 1024 * 1024;
+
+/// One request line: {"diff": "...", "recent_commits": ["subject", ...]}
+const Request = struct {
+    diff: []const u8,
+    recent_commits: []const []const u8 = &.{},
+};
+
+/// Generates messages for serve requests, reusing one provider (and its HTTP client)
+pub const ProviderGenerator = struct {
+    allocator: std.mem.Allocator,
+    provider: llm.Provider,
+    system_prompt: []const u8,
+    max_diff_bytes: usize,
+    include_recent_commits: bool = true,
+    /// Token counts of the last response (zero when the provider didn't report them)
+    last_usage: llm.Usage = .{},
+
+    pub fn generate(self: *ProviderGenerator, diff: []const u8, recent_commits: []const git.CommitInfo) ![]const u8 {
+        self.last_usage = .{};
+        var provider = self.provider;
+        provider.usage = &self.last_usage;
+
+        const truncated_diff = try git.truncateDiff(self.allocator, diff, self.max_diff_bytes);
+        defer self.allocator.free(truncated_diff);
+
+        const context_commits = if (self.include_recent_commits) recent_commits else recent_commits[0..0];
+        const user_content = try prompt_builder.buildUserContent(self.allocator, truncated_diff, .{ .recent_commits = context_commits });
+        defer self.allocator.free(user_content);
+
+        return provider.generateCommitMessage(user_content, self.system_prompt);
+    }
+
+    pub fn usage(self: *const ProviderGenerator) ?llm.Usage {
+        return if (self.last_usage.reported()) self.last_usage else null;
+    }
+};
+
+/// Answer one request line with `generator` (anything with `generate(diff, recent_commits) ![]const u8`
+/// and `usage() ?llm.Usage`). Failures become {"error": "..."} responses. Caller owns the returned JSON
+pub fn handleLine(allocator: std.mem.Allocator, generator: anytype, line: []const u8) ![]const u8 {
+    var parsed = std.json.parseFromSlice(Request, allocator, line, .{ .ignore_unknown_fields = true }) catch {
+        return errorResponse(allocator, "malformed request: expected {\"diff\": string, \"recent_commits\": [string]}");
+    };
+    defer parsed.deinit();
+
+    if (std.mem.trim(u8, parsed.value.diff, " \n\r\t").len == 0) {
+        return errorResponse(allocator, "diff is empty");
+    }
+
+    const commits = try allocator.alloc(git.CommitInfo, parsed.value.recent_commits.len);
+    defer allocator.free(commits);
+    for (parsed.value.recent_commits, 0..) |subject, i| {
+        commits[i] = .{ .subject = subject };
+    }
+
+    const message = generator.generate(parsed.value.diff, commits) catch |err| {
+        return errorResponse(allocator, @errorName(err));
+    };
+    defer allocator.free(message);
+
+    return std.json.stringifyAlloc(allocator, .{ .message = message, .usage = generator.usage() }, .{
+        .emit_null_optional_fields = false,
+    });
+}
+
+fn errorResponse(allocator: std.mem.Allocator, message: []const u8) ![]const u8 {
+    return std.json.stringifyAlloc(allocator, .{ .@"error" = message }, .{});
+}
+
+/// Answer newline-delimited JSON requests from `reader` until EOF, one response line each
+pub fn run(allocator: std.mem.Allocator, generator: anytype, reader: anytype, writer: anytype) !void {
+    var line = std.ArrayList(u8).init(allocator);
+    defer line.deinit();
+
+    while (true) {
+        line.clearRetainingCapacity();
+        reader.streamUntilDelimiter(line.writer(), '\n', MAX_REQUEST_LINE) catch |err| switch (err) {
+            // A final line without a newline is still answered
+            error.EndOfStream => if (line.items.len == 0) return,
+            error.StreamTooLong => {
+                try reader.skipUntilDelimiterOrEof('\n');
+                const response = try errorResponse(allocator, "request too large");
+                defer allocator.free(response);
+                try writer.print("{s}\n", .{response});
+                continue;
+            },
+            else => return err,
+        };
+
+        const request = std.mem.trim(u8, line.items, " \r\t");
+        if (request.len == 0) continue;
+
+        const response = try handleLine(allocator, generator, request);
+        defer allocator.free(response);
+        try writer.print("{s}\n", .{response});
+    }
+}
+
+// Test section
+const FakeGenerator = struct {
+    calls: usize = 0,
+    report_usage: bool = false,
+
+    /// Echoes how much context arrived; a diff of "fail" simulates a provider error
+    pub fn generate(self: *FakeGenerator, diff: []const u8, recent_commits: []const git.CommitInfo) ![]const u8 {
+        self.calls += 1;
+        if (std.mem.eql(u8, diff, "fail")) return error.RateLimited;
+        const last = if (recent_commits.len > 0) recent_commits[recent_commits.len - 1].subject else "none";
+        return std.fmt.allocPrint(std.testing.allocator, "feat: request {d} after {s}", .{ self.calls, last });
+    }
+
+    pub fn usage(self: *const FakeGenerator) ?llm.Usage {
+        if (!self.report_usage) return null;
+        return .{ .prompt_tokens = 120, .completion_tokens = 8, .total_tokens = 128 };
+    }
+};
+
+test "run answers each request line in order" {
+    const input =
+        \\{"diff":"diff --git a/x b/x","recent_commits":["fix: one","docs: two"]}
+        \\not json
+        \\
+        \\{"diff":"fail"}
+        \\{"diff":"  "}
+        \\{"diff":"diff --git a/y b/y","editor":"vim"}
+    ;
+
+    var generator = FakeGenerator{};
+    var in_stream = std.io.fixedBufferStream(input);
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    try run(std.testing.allocator, &generator, in_stream.reader(), output.writer());
+
+    const expected =
+        \\{"message":"feat: request 1 after docs: two"}
+        \\{"error":"malformed request: expected {\"diff\": string, \"recent_commits\": [string]}"}
+        \\{"error":"RateLimited"}
+        \\{"error":"diff is empty"}
+        \\{"message":"feat: request 3 after none"}
+        \\
+    ;
+    try std.testing.expectEqualStrings(expected, output.items);
+    try std.testing.expectEqual(@as(usize, 3), generator.calls);
+}
+
+test "handleLine includes reported token usage" {
+    var generator = FakeGenerator{ .report_usage = true };
+    const response = try handleLine(std.testing.allocator, &generator, "{\"diff\":\"diff --git a/x b/x\"}");
+    defer std.testing.allocator.free(response);
+
+    try std.testing.expectEqualStrings("{\"message\":\"feat: request 1 after none\",\"usage\":{\"prompt_tokens\":120,\"completion_tokens\":8,\"total_tokens\":128}}", response);
+}
+
+test "run stops cleanly on empty input" {
+    var generator = FakeGenerator{};
+    var in_stream = std.io.fixedBufferStream("");
+    var output = std.ArrayList(u8).init(std.testing.allocator);
+    defer output.deinit();
+
+    try run(std.testing.allocator, &generator, in_stream.reader(), output.writer());
+    try std.testing.expectEqualStrings("", output.items);
+}